@@ -4,4 +4,6 @@ package main
 const (
 	CreateCluster  = "create-cluster"
 	DestroyCluster = "destroy-cluster"
+	RunJob         = "run"
+	ResizePool     = "resize-pool"
 )
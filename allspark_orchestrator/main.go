@@ -7,6 +7,7 @@ import (
 	"allspark/daemon"
 	"allspark/logger"
 	"allspark/monitor"
+	"allspark/scheduler"
 	"os"
 )
 
@@ -24,6 +25,7 @@ func main() {
 		daemon.GetAllSparkConfig().ClusterPendingTimeout,
 		daemon.GetAllSparkConfig().DoneReportTime,
 		daemon.GetAllSparkConfig().CancelTerminationDelay)
+	go scheduler.Run(-1)
 
 	api.Init()
 }
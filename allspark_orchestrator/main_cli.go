@@ -3,16 +3,23 @@
 package main
 
 import (
+	"allspark/api"
 	"allspark/cloud"
 	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 )
 
 func printDefaultUsage() {
-	fmt.Printf("usage: allspark <%s|%s>\n", CreateCluster, DestroyCluster)
+	fmt.Printf("usage: allspark <%s|%s|%s|%s>\n", CreateCluster, DestroyCluster, RunJob, ResizePool)
 }
 
 func handleErrors(options *flag.FlagSet,
@@ -66,19 +73,178 @@ func handleDestroyCluster(options *flag.FlagSet,
 	client.DestroyCluster()
 }
 
+func readRunResponse(resp *http.Response) (monitor.SparkRun, error) {
+	var run monitor.SparkRun
+	defer resp.Body.Close()
+
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return run, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return run, errors.New(string(buffer))
+	}
+
+	err = serializer.Deserialize(buffer, &run)
+	return run, err
+}
+
+// daemonClient - identifies the cli to the allspark daemon
+type daemonClient struct {
+	url   string
+	token string
+	team  string
+}
+
+func (c daemonClient) call(method string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if len(c.team) > 0 {
+		req.Header.Set("X-Allspark-Team", c.team)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func handleRun(options *flag.FlagSet, cloudEnvironment string,
+	templatePath string, jobPath string, client daemonClient) {
+	handleErrors(options, cloudEnvironment, templatePath)
+	if len(jobPath) == 0 {
+		options.Usage()
+		os.Exit(1)
+	}
+
+	templateConfig, err := cloud.ReadTemplateConfiguration(templatePath)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	var job cloud.SparkJobSpec
+	err = serializer.DeserializePath(jobPath, &job)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	body, err := serializer.Serialize(monitor.RunRequest{
+		CloudEnvironment: cloudEnvironment,
+		Template:         templateConfig,
+		Job:              job,
+	})
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	resp, err := client.call("POST", "/runs", body)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	run, err := readRunResponse(resp)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+	logger.GetInfo().Printf("started run %s on cluster %s", run.ID, run.ClusterID)
+
+	for !monitor.IsRunComplete(run) {
+		time.Sleep(10 * time.Second)
+
+		resp, err = client.call("GET", "/runs/"+run.ID, nil)
+		if err != nil {
+			logger.GetError().Println(err)
+			continue
+		}
+
+		run, err = readRunResponse(resp)
+		if err != nil {
+			logger.GetFatal().Fatalln(err)
+		}
+		logger.GetInfo().Printf("run %s status: %s", run.ID, run.Status)
+	}
+
+	logger.GetInfo().Printf("run %s completed with status %s, driver state %s, app state %s",
+		run.ID, run.Status, run.DriverState, run.AppState)
+
+	if run.Status != monitor.RunStatusSucceeded {
+		logger.GetFatal().Fatalln(run.Error)
+	}
+}
+
+func handleResizePool(options *flag.FlagSet, clusterID string, pool string,
+	nodes int64, client daemonClient) {
+	if len(clusterID) == 0 || len(pool) == 0 || nodes < 0 {
+		options.Usage()
+		os.Exit(1)
+	}
+
+	body, err := serializer.Serialize(api.ResizeRequest{Nodes: nodes})
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	resp, err := client.call("POST", "/clusters/"+clusterID+"/pools/"+pool+"/resize", body)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+	defer resp.Body.Close()
+
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.GetFatal().Fatalln(err)
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		logger.GetFatal().Fatalln(string(buffer))
+	}
+	logger.GetInfo().Printf("resizing pool %s of cluster %s to %v workers", pool, clusterID, nodes)
+}
+
 func main() {
 	createCluster := flag.NewFlagSet(CreateCluster, flag.ExitOnError)
 	createCloudEnvironment := createCluster.String("cloud-environment", "",
-		"Cloud environment; options include docker, aws, azure")
+		"Cloud environment; options include docker, aws, azure, kubernetes, static, local")
 	createTemplate := createCluster.String("template", "",
 		"/path/to/deployment-template")
 
 	destroyCluster := flag.NewFlagSet(DestroyCluster, flag.ExitOnError)
 	destroyCloudEnvironment := destroyCluster.String("cloud-environment", "",
-		"Cloud environment; options include docker, aws, azure")
+		"Cloud environment; options include docker, aws, azure, kubernetes, static, local")
 	destroyTemplate := destroyCluster.String("template", "",
 		"/path/to/deployment-template")
 
+	runJob := flag.NewFlagSet(RunJob, flag.ExitOnError)
+	runCloudEnvironment := runJob.String("cloud-environment", "",
+		"Cloud environment; options include docker, aws, azure, kubernetes, static, local")
+	runTemplate := runJob.String("template", "",
+		"/path/to/deployment-template")
+	runJobSpec := runJob.String("job", "",
+		"/path/to/job-specification")
+	runDaemonURL := runJob.String("url", "http://localhost:32418",
+		"allspark daemon url")
+	runToken := runJob.String("token", "",
+		"bearer token identifying the caller to the daemon")
+	runTeam := runJob.String("team", "",
+		"team owning the run; defaults to the caller's first team")
+
+	resizePool := flag.NewFlagSet(ResizePool, flag.ExitOnError)
+	resizeClusterID := resizePool.String("cluster-id", "",
+		"ID of the cluster to resize")
+	resizePoolName := resizePool.String("pool", cloud.DefaultWorkerPool,
+		"worker pool to resize")
+	resizeNodes := resizePool.Int64("nodes", -1,
+		"number of worker nodes of the pool")
+	resizeDaemonURL := resizePool.String("url", "http://localhost:32418",
+		"allspark daemon url")
+	resizeToken := resizePool.String("token", "",
+		"bearer token identifying the caller to the daemon")
+
 	if len(os.Args) <= 1 {
 		printDefaultUsage()
 		os.Exit(1)
@@ -93,6 +259,14 @@ func main() {
 		destroyCluster.Parse(os.Args[2:])
 		handleDestroyCluster(destroyCluster,
 			*destroyCloudEnvironment, *destroyTemplate)
+	case RunJob:
+		runJob.Parse(os.Args[2:])
+		handleRun(runJob, *runCloudEnvironment, *runTemplate, *runJobSpec,
+			daemonClient{url: *runDaemonURL, token: *runToken, team: *runTeam})
+	case ResizePool:
+		resizePool.Parse(os.Args[2:])
+		handleResizePool(resizePool, *resizeClusterID, *resizePoolName, *resizeNodes,
+			daemonClient{url: *resizeDaemonURL, token: *resizeToken})
 	default:
 		printDefaultUsage()
 		os.Exit(1)
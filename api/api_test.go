@@ -2,11 +2,15 @@ package api
 
 import (
 	"allspark/cloud"
+	"allspark/daemon"
 	"allspark/monitor"
 	"allspark/util/serializer"
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -271,6 +275,143 @@ func TestCheckin(t *testing.T) {
 	monitor.DeregisterCluster(client.ClusterID)
 }
 
+func TestWatchCluster(t *testing.T) {
+	testHTTPRequest(t, routeClusters, "POST", "/clusters/watch",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "GET", "/clusters/local/watch/extra",
+		nil, http.StatusNotFound, false)
+
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath(awsTemplatePath, &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(routeClusters))
+	defer server.Close()
+
+	monitor.DeregisterCluster("local")
+	resp, err := http.Get(server.URL + "/clusters/local/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("unexpected content type: " + resp.Header.Get("Content-Type"))
+	}
+
+	monitor.RegisterCluster("local", cloud.Aws, serlializedClient)
+
+	events := make(chan monitor.ClusterEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data: ") {
+				var event monitor.ClusterEvent
+				serializer.Deserialize([]byte(strings.TrimPrefix(line, "data: ")), &event)
+				events <- event
+			}
+		}
+	}()
+
+	select {
+	case event := <-events:
+		if event.ClusterID != "local" || event.Status != monitor.StatusPending {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for cluster event")
+	}
+
+	// the stream ends once the cluster is deregistered
+	monitor.DeregisterCluster("local")
+	for _, status := range []string{monitor.StatusNotRegistered, ""} {
+		select {
+		case event := <-events:
+			if event.Status != status {
+				t.Errorf("unexpected event: %+v", event)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the end of the stream")
+		}
+	}
+}
+
+func TestGetCluster(t *testing.T) {
+	testHTTPRequest(t, routeClusters, "POST", "/clusters/does-not-exist",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "GET", "/clusters/does-not-exist",
+		nil, http.StatusNotFound, false)
+}
+
+func TestJobs(t *testing.T) {
+	testHTTPRequest(t, routeClusters, "PUT", "/clusters/local/jobs",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "POST", "/clusters/local/jobs",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "POST", "/clusters/local/jobs",
+		strings.NewReader(`{"AppResource": "s3://bucket/app.jar"}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "POST", "/clusters/does-not-exist/jobs",
+		strings.NewReader(`{"PythonFile": "s3://bucket/app.py"}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeClusters, "GET", "/clusters/does-not-exist/jobs",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeClusters, "GET", "/clusters/does-not-exist/jobs/driver-1",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeClusters, "DELETE", "/clusters/does-not-exist/jobs/driver-1",
+		nil, http.StatusBadRequest, false)
+}
+
+func TestRuns(t *testing.T) {
+	testHTTPRequest(t, routeRuns, "POST", "/runs",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeRuns, "POST", "/runs",
+		strings.NewReader(`{"CloudEnvironment": "docker", "Job": {}}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeRuns, "POST", "/runs",
+		strings.NewReader(`{"CloudEnvironment": "does-not-exist", "Template": {},
+			"Job": {"PythonFile": "s3://bucket/app.py"}}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeRuns, "GET", "/runs/does-not-exist",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeRuns, "GET", "/runs",
+		nil, http.StatusOK, false)
+}
+
+func TestSchedules(t *testing.T) {
+	testHTTPRequest(t, routeSchedules, "POST", "/schedules",
+		nil, http.StatusBadRequest, false)
+	testHTTPRequest(t, routeSchedules, "POST", "/schedules",
+		strings.NewReader(`{"Name": "Nightly", "Cron": "0 2 * * *",
+			"CloudEnvironment": "docker", "Template": {}}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeSchedules, "POST", "/schedules",
+		strings.NewReader(`{"Name": "nightly", "Cron": "0 25 * * *",
+			"CloudEnvironment": "docker", "Template": {}}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeSchedules, "POST", "/schedules",
+		strings.NewReader(`{"Name": "nightly", "Cron": "0 2 * * *",
+			"Timezone": "Not/AZone", "CloudEnvironment": "docker", "Template": {}}`),
+		http.StatusBadRequest, false)
+	testHTTPRequest(t, routeSchedules, "GET", "/schedules/does-not-exist",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeSchedules, "GET", "/schedules/does-not-exist/history",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeSchedules, "DELETE", "/schedules/does-not-exist",
+		nil, http.StatusNotFound, false)
+	testHTTPRequest(t, routeSchedules, "GET", "/schedules",
+		nil, http.StatusOK, false)
+}
+
 func TestGetStatus(t *testing.T) {
 	testHTTPRequest(t, getStatus, "POST", "/getStatus",
 		nil, http.StatusBadRequest, false)
@@ -374,3 +515,157 @@ func TestCreateAndDestroyClusterDocker(t *testing.T) {
 
 	getDockerClient(t).DestroyCluster()
 }
+
+func TestResolveTeam(t *testing.T) {
+	member := daemon.Identity{Name: "analyst", Teams: []string{"etl", "ml"}}
+	admin := daemon.Identity{Name: "ops", Admin: true}
+
+	tests := []struct {
+		identity  daemon.Identity
+		requested string
+		expected  string
+		valid     bool
+	}{
+		{member, "", "etl", true},
+		{member, "ml", "ml", true},
+		{member, "finance", "", false},
+		{admin, "finance", "finance", true},
+		{admin, "", "", true},
+		{daemon.Identity{Name: "orphan"}, "", "", false},
+	}
+
+	for _, el := range tests {
+		team, err := resolveTeam(el.identity, el.requested)
+		if (err == nil) != el.valid || team != el.expected {
+			t.Error("team mismatch for " + el.identity.Name + " requesting " + el.requested)
+			t.Error("-expected: " + el.expected)
+			t.Error("-actual: " + team)
+		}
+	}
+
+	if !canAccess(member, "ml") || canAccess(member, "finance") || canAccess(member, "") {
+		t.Error("unexpected access for team member")
+	}
+
+	if !canAccess(admin, "finance") || !canAccess(admin, "") {
+		t.Error("expected admin to access all teams")
+	}
+}
+
+func TestTeams(t *testing.T) {
+	testHTTPRequest(t, routeTeams, "GET", "/teams",
+		nil, http.StatusOK, false)
+	testHTTPRequest(t, routeTeams, "PUT", "/teams/etl/quota",
+		strings.NewReader(`{"MaxClusters": -1}`), http.StatusBadRequest, false)
+	testHTTPRequest(t, routeTeams, "PUT", "/teams/etl/quota",
+		strings.NewReader(`{"MaxClusters": 2, "AllowedInstanceTypes": ["m5.2xlarge"]}`),
+		http.StatusOK, false)
+	testHTTPRequest(t, routeTeams, "GET", "/teams/etl/quota",
+		nil, http.StatusOK, false)
+	testHTTPRequest(t, routeTeams, "GET", "/teams/etl",
+		nil, http.StatusOK, false)
+	testHTTPRequest(t, routeTeams, "DELETE", "/teams/etl/quota",
+		nil, http.StatusOK, false)
+	testHTTPRequest(t, routeTeams, "GET", "/teams/etl/quota",
+		nil, http.StatusNotFound, false)
+}
+
+func TestAzureSampleTemplates(t *testing.T) {
+	for _, path := range []string{azureTemplatePath, "../dist/sample_templates/azure_single_node.json"} {
+		var template cloud.AzureEnvironment
+		err := serializer.DeserializePath(path, &template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = validateAzureTemplate(template)
+		if err != nil {
+			t.Errorf("expected %v to be valid: %v", path, err)
+		}
+
+		template.ConfigTags = false
+		if validateAzureTemplate(template) == nil {
+			t.Errorf("expected %v to require ConfigTags without an image", path)
+		}
+	}
+}
+
+func TestGetCaller(t *testing.T) {
+	request := httptest.NewRequest("POST", "/docker/create", nil)
+	request.RemoteAddr = "10.0.0.7:52114"
+	request.Header.Set("X-Allspark-Caller", "someone-else")
+
+	if caller := getCaller(request); caller != "10.0.0.7" {
+		t.Error("expected anonymous callers to be identified by remote address: " + caller)
+	}
+
+	request = request.WithContext(context.WithValue(request.Context(),
+		identityContextKey, daemon.Identity{Name: "analyst"}))
+	if caller := getCaller(request); caller != "analyst" {
+		t.Error("expected callers to be identified by their identity: " + caller)
+	}
+}
+
+func TestTemplateRetryPolicy(t *testing.T) {
+	var template cloud.DockerEnvironment
+	err := serializer.DeserializePath(dockerTemplatePath, &template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.RetryPolicy = &cloud.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 60}
+	if err = validateDockerTemplate(template); err != nil {
+		t.Errorf("expected the retry policy to be valid: %v", err)
+	}
+
+	template.RetryPolicy.BackoffSeconds = -1
+	if validateDockerTemplate(template) == nil {
+		t.Error("expected a negative backoff to be rejected")
+	}
+}
+
+func TestLocalClustersRequireAdmin(t *testing.T) {
+	template, err := ioutil.ReadFile("../dist/sample_templates/local.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("POST", "/local/create", bytes.NewReader(template))
+	request = request.WithContext(context.WithValue(request.Context(),
+		identityContextKey, daemon.Identity{Name: "analyst", Teams: []string{"etl"}}))
+	rr := httptest.NewRecorder()
+	createClusterLocal(rr, request)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected non-admins to be denied local clusters, got %v", rr.Code)
+	}
+
+	if !isAdminEnvironment(cloud.Local) || isAdminEnvironment(cloud.Docker) {
+		t.Error("expected only local clusters to require admins")
+	}
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	request := httptest.NewRequest("GET", "/reports/cost?from=2021-03-01&to=2021-03-31", nil)
+	from, to, err := parseReportPeriod(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if from.Format(reportDayFormat) != "2021-03-01" || to.Format(reportDayFormat) != "2021-03-31" {
+		t.Error("report period mismatch")
+		t.Error("-expected: 2021-03-01 - 2021-03-31")
+		t.Error("-actual: " + from.Format(reportDayFormat) + " - " + to.Format(reportDayFormat))
+	}
+
+	request = httptest.NewRequest("GET", "/reports/cost?to=2021-03-31", nil)
+	from, _, err = parseReportPeriod(request)
+	if err != nil || from.Format(reportDayFormat) != "2021-03-02" {
+		t.Error("expected the default report period to span 30 days")
+	}
+
+	request = httptest.NewRequest("GET", "/reports/cost?from=yesterday", nil)
+	_, _, err = parseReportPeriod(request)
+	if err == nil {
+		t.Error("expected non-nil error for invalid report day")
+	}
+}
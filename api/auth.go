@@ -0,0 +1,114 @@
+package api
+
+import (
+	"allspark/daemon"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	identityContextKey contextKey = "identity"
+	teamHeader                    = "X-Allspark-Team"
+	bearerPrefix                  = "Bearer "
+)
+
+func isAuthenticationEnabled() bool {
+	return len(daemon.GetAllSparkConfig().Identities) > 0
+}
+
+// authenticate - resolves the identity of the bearer token; all requests
+// are treated as admin requests if no identities are configured
+func authenticate(r *http.Request) (daemon.Identity, error) {
+	if !isAuthenticationEnabled() {
+		return daemon.Identity{Admin: true}, nil
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return daemon.Identity{}, errors.New("bearer token not specified")
+	}
+
+	token := strings.TrimPrefix(authorization, bearerPrefix)
+	identity, ok := daemon.GetAllSparkConfig().Identities[token]
+	if !ok {
+		return daemon.Identity{}, errors.New("invalid bearer token")
+	}
+
+	return identity, nil
+}
+
+// authorized - rejects unauthenticated requests and makes the identity
+// of the caller available to the handler
+func authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := authenticate(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(),
+			identityContextKey, identity)))
+	}
+}
+
+func getIdentity(r *http.Request) daemon.Identity {
+	identity, ok := r.Context().Value(identityContextKey).(daemon.Identity)
+	if !ok {
+		return daemon.Identity{Admin: !isAuthenticationEnabled()}
+	}
+	return identity
+}
+
+func isTeamMember(identity daemon.Identity, team string) bool {
+	for _, el := range identity.Teams {
+		if el == team {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccess - returns true if the identity may inspect or manage
+// resources owned by the team
+func canAccess(identity daemon.Identity, team string) bool {
+	return identity.Admin || (len(team) > 0 && isTeamMember(identity, team))
+}
+
+// resolveTeam - returns the team owning a new resource; the requested
+// team must be one of the identity's teams unless the identity is an
+// admin, and defaults to the identity's first team
+func resolveTeam(identity daemon.Identity, requested string) (string, error) {
+	if len(requested) == 0 {
+		if len(identity.Teams) > 0 {
+			return identity.Teams[0], nil
+		}
+		if identity.Admin {
+			return "", nil
+		}
+		return "", errors.New("identity " + identity.Name + " is not a member of any team")
+	}
+
+	if !identity.Admin && !isTeamMember(identity, requested) {
+		return "", errors.New("identity " + identity.Name +
+			" is not a member of team " + requested)
+	}
+
+	return requested, nil
+}
+
+// getTeam - returns the team owning a resource created by the request,
+// either from the team header or the caller's identity
+func getTeam(r *http.Request) (string, error) {
+	return resolveTeam(getIdentity(r), r.Header.Get(teamHeader))
+}
+
+func writeForbidden(w http.ResponseWriter, resource string) {
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("access to " + resource + " denied"))
+}
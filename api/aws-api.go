@@ -11,19 +11,52 @@ import (
 )
 
 func validateAwsTemplate(template cloud.AwsEnvironment) error {
+	// the image, instance profile and security groups may be provided
+	// by a launch template
+	launchTemplate := template.LaunchTemplate != nil
 	if len(template.ClusterID) == 0 ||
 		template.EBSVolumeSize < 10 ||
-		len(template.IAMRole) == 0 ||
-		len(template.Image) == 0 ||
+		(len(template.IAMRole) == 0 && len(template.IAMInstanceProfileArn) == 0 && !launchTemplate) ||
+		(len(template.Image) == 0 && !launchTemplate) ||
 		len(template.InstanceType) == 0 ||
 		len(template.Region) == 0 ||
-		len(template.SecurityGroupIds) == 0 ||
-		len(template.SubnetID) == 0 ||
-		template.WorkerNodes < 0 {
+		(len(template.SecurityGroupIds) == 0 && !launchTemplate) ||
+		(len(template.SubnetID) == 0 && len(template.SubnetIDs) == 0) ||
+		template.WorkerNodes < 0 ||
+		(template.MasterEBSVolumeSize != 0 && template.MasterEBSVolumeSize < 10) {
 		return errors.New("invalid template object")
 	}
 
-	return nil
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	pools := make([]cloud.WorkerPool, len(template.WorkerPools))
+	for idx, el := range template.WorkerPools {
+		if el.EBSVolumeSize != 0 && el.EBSVolumeSize < 10 {
+			return errors.New("invalid template object")
+		}
+		pools[idx] = el.WorkerPool
+	}
+
+	err = cloud.ValidateWorkerPools(pools, template.WorkerNodes)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateLaunchOptions(template.LaunchTemplate, template.RootVolume,
+		template.DataVolumes)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateAutoScalingOptions(template.AutoScaling)
+	if err != nil {
+		return err
+	}
+
+	return cloud.ValidateSpotOptions(template.Spot)
 }
 
 func validateAwsFormBody(r *http.Request) (*cloud.AwsEnvironment, error) {
@@ -73,29 +106,15 @@ func createClusterAws(w http.ResponseWriter, r *http.Request) {
 		logger.GetError().Println(err)
 	}
 
-	err = monitor.RegisterCluster(client.ClusterID, cloud.Aws, serializedClient)
-	if err != nil {
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	_, err = client.CreateCluster()
-	if err != nil {
-		monitor.SetCanceled(client.ClusterID)
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("successfully launched cluster"))
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Aws,
+		Client:           serializedClient,
+	})
 }
 
 // InitAwsAPI - Initialize the AWS API
 func InitAwsAPI() {
-	http.HandleFunc("/aws/create", createClusterAws)
-	http.HandleFunc("/aws/terminate", terminateAws)
+	http.HandleFunc("/aws/create", authorized(createClusterAws))
+	http.HandleFunc("/aws/terminate", authorized(terminateAws))
 }
@@ -21,15 +21,76 @@ func validateAzureTemplate(template cloud.AzureEnvironment) error {
 		len(template.VMNet) == 0 ||
 		len(template.VMSubnet) == 0 ||
 		len(template.VMSize) == 0 ||
-		len(template.ImageStorageAccount) == 0 ||
-		len(template.ImageContainer) == 0 ||
-		len(template.ImageBlob) == 0 ||
+		(len(template.ImageID) == 0 && template.GalleryImage == nil &&
+			(len(template.ImageStorageAccount) == 0 ||
+				len(template.ImageContainer) == 0 ||
+				len(template.ImageBlob) == 0)) ||
 		template.DiskSizeGB < 30 ||
-		template.WorkerNodes < 0 {
+		(template.MasterDiskSizeGB != 0 && template.MasterDiskSizeGB < 30) ||
+		template.WorkerNodes < 0 ||
+		template.ParallelLaunches < 0 {
 		return errors.New("invalid template object")
 	}
 
-	return nil
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateSecretReference(template.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateEnvParams(template.EnvParams)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateEnvParamReferences(template.EnvParams)
+	if err != nil {
+		return err
+	}
+
+	pools := make([]cloud.WorkerPool, len(template.WorkerPools))
+	for idx, el := range template.WorkerPools {
+		if el.DiskSizeGB != 0 && el.DiskSizeGB < 30 {
+			return errors.New("invalid template object")
+		}
+		err = cloud.ValidateEnvParams(el.EnvParams)
+		if err != nil {
+			return err
+		}
+		err = cloud.ValidateEnvParamReferences(el.EnvParams)
+		if err != nil {
+			return err
+		}
+		pools[idx] = el.WorkerPool
+	}
+
+	err = cloud.ValidateWorkerPools(pools, template.WorkerNodes)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateAzureImage(template.ImageID, template.GalleryImage, template.SSHPublicKey)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateManagedIdentities(template.ManagedIdentities, template.StorageIdentity)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateNodeConfig(len(template.ImageID) > 0 || template.GalleryImage != nil,
+		template.ConfigTags)
+	if err != nil {
+		return err
+	}
+
+	return cloud.ValidateAzureScaleSet(template.ScaleSet,
+		len(template.ImageID) > 0 || template.GalleryImage != nil)
 }
 
 func validateAzureFormBody(r *http.Request) (*cloud.AzureEnvironment, error) {
@@ -79,29 +140,15 @@ func createClusterAzure(w http.ResponseWriter, r *http.Request) {
 
 	logger.GetInfo().Println("http-request: /azure/create, clusterID: " + client.ClusterID)
 
-	err = monitor.RegisterCluster(client.ClusterID, cloud.Azure, serializedClient)
-	if err != nil {
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	_, err = client.CreateCluster()
-	if err != nil {
-		monitor.SetCanceled(client.ClusterID)
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("successfully launched cluster"))
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Azure,
+		Client:           serializedClient,
+	})
 }
 
 // InitAzureAPI - Initialize the Azure API
 func InitAzureAPI() {
-	http.HandleFunc("/azure/create", createClusterAzure)
-	http.HandleFunc("/azure/terminate", terminateAzure)
+	http.HandleFunc("/azure/create", authorized(createClusterAzure))
+	http.HandleFunc("/azure/terminate", authorized(terminateAzure))
 }
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"allspark/daemon"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// canAccessBudget - team budgets are visible to team members, environment
+// budgets to all callers
+func canAccessBudget(identity daemon.Identity, budget monitor.Budget) bool {
+	return budget.Scope != monitor.BudgetScopeTeam || canAccess(identity, budget.Target)
+}
+
+func listBudgets(w http.ResponseWriter, r *http.Request) {
+	identity := getIdentity(r)
+	budgets := make([]monitor.BudgetStatus, 0)
+	for _, el := range monitor.GetBudgets() {
+		if canAccessBudget(identity, el) {
+			budgets = append(budgets, monitor.GetBudgetStatus(el))
+		}
+	}
+
+	writeJSON(w, http.StatusOK, budgets)
+}
+
+func getBudget(w http.ResponseWriter, r *http.Request, scope string, target string) {
+	budget, err := monitor.GetBudget(scope, target)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !canAccessBudget(getIdentity(r), budget) {
+		writeForbidden(w, "budget "+monitor.BudgetID(scope, target))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, monitor.GetBudgetStatus(budget))
+}
+
+func setBudget(w http.ResponseWriter, r *http.Request, scope string, target string) {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("form body is null"))
+		return
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	logger.GetInfo().Printf("Form body: %s", buffer)
+
+	var budget monitor.Budget
+	err = serializer.Deserialize(buffer, &budget)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	budget.Scope = scope
+	budget.Target = target
+	err = monitor.SetBudget(budget)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, monitor.GetBudgetStatus(budget))
+}
+
+func deleteBudget(w http.ResponseWriter, r *http.Request, scope string, target string) {
+	err := monitor.DeleteBudget(scope, target)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func routeBudgets(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: " + r.URL.Path)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/budgets"), "/")
+	segments := strings.Split(path, "/")
+
+	isBudget := len(segments) == 2 && len(segments[0]) > 0 && len(segments[1]) > 0
+	if isBudget && r.Method != "GET" && !getIdentity(r).Admin {
+		writeForbidden(w, "budgets")
+		return
+	}
+
+	switch {
+	case len(path) == 0 && r.Method == "GET":
+		listBudgets(w, r)
+	case isBudget && r.Method == "GET":
+		getBudget(w, r, segments[0], segments[1])
+	case isBudget && (r.Method == "PUT" || r.Method == "POST"):
+		setBudget(w, r, segments[0], segments[1])
+	case isBudget && r.Method == "DELETE":
+		deleteBudget(w, r, segments[0], segments[1])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitBudgetsAPI - Initialize the budgets API
+func InitBudgetsAPI() {
+	http.HandleFunc("/budgets", authorized(routeBudgets))
+	http.HandleFunc("/budgets/", authorized(routeBudgets))
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExtendRequest - form body for the /clusters/{id}/extend endpoint
+type ExtendRequest struct {
+	Seconds int64
+}
+
+// ResizeRequest - form body for the /clusters/{id}/pools/{pool}/resize endpoint
+type ResizeRequest struct {
+	Nodes int64
+}
+
+const (
+	watchKeepAliveInterval = 15 * time.Second
+)
+
+func writeClusterEvent(w http.ResponseWriter, flusher http.Flusher,
+	event monitor.ClusterEvent) error {
+
+	buffer, err := serializer.Serialize(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write([]byte("event: status\ndata: " + string(buffer) + "\n\n"))
+	if err != nil {
+		return err
+	}
+
+	flusher.Flush()
+	return nil
+}
+
+func watchClusters(w http.ResponseWriter, r *http.Request, clusterID string) {
+	err := validateRequest(r, "GET")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+
+	events, err := monitor.WatchClusters(r.Context(), clusterID)
+	if err != nil {
+		logger.GetError().Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	identity := getIdentity(r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range monitor.GetClusterEvents(clusterID) {
+		if !canAccess(identity, event.Team) {
+			continue
+		}
+		if writeClusterEvent(w, flusher, event) != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(watchKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !canAccess(identity, event.Team) {
+				continue
+			}
+			if writeClusterEvent(w, flusher, event) != nil {
+				return
+			}
+			// the cluster has been deregistered, and will not report again
+			if len(clusterID) > 0 && event.Status == monitor.StatusNotRegistered {
+				return
+			}
+		case <-keepAlive.C:
+			_, err = w.Write([]byte(": keep-alive\n\n"))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func getCluster(w http.ResponseWriter, r *http.Request, clusterID string) {
+	err := validateRequest(r, "GET")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	detail, err := monitor.GetClusterDetail(clusterID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func listClusters(w http.ResponseWriter, r *http.Request) {
+	identity := getIdentity(r)
+	clusters := make([]monitor.ClusterDetail, 0)
+	for _, el := range monitor.GetClusters() {
+		if canAccess(identity, el.Team) {
+			clusters = append(clusters, el)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, clusters)
+}
+
+func extendCluster(w http.ResponseWriter, r *http.Request, clusterID string) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var request ExtendRequest
+	err = serializer.Deserialize(buffer, &request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	detail, err := monitor.ExtendCluster(clusterID, request.Seconds)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func resizePool(w http.ResponseWriter, r *http.Request, clusterID string, pool string) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var request ResizeRequest
+	err = serializer.Deserialize(buffer, &request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	detail, err := monitor.ResizeWorkerPool(clusterID, pool, request.Nodes)
+	if errors.Is(err, monitor.ErrQuotaExceeded) || errors.Is(err, monitor.ErrBudgetExceeded) {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if errors.Is(err, monitor.ErrCapacityExceeded) {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, detail)
+}
+
+// authorizeCluster - verifies the caller may access a registered cluster;
+// unregistered clusters are left to the handler to report
+func authorizeCluster(w http.ResponseWriter, r *http.Request, clusterID string) bool {
+	detail, err := monitor.GetClusterDetail(clusterID)
+	if err == nil && !canAccess(getIdentity(r), detail.Team) {
+		writeForbidden(w, "cluster "+clusterID)
+		return false
+	}
+	return true
+}
+
+func routeClusters(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: " + r.URL.Path)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/clusters"), "/")
+	segments := strings.Split(path, "/")
+
+	if path != "watch" && len(segments[0]) > 0 && !authorizeCluster(w, r, segments[0]) {
+		return
+	}
+
+	switch {
+	case len(path) == 0 && r.Method == "GET":
+		listClusters(w, r)
+	case path == "watch":
+		watchClusters(w, r, "")
+	case len(segments) == 1 && len(segments[0]) > 0:
+		getCluster(w, r, segments[0])
+	case len(segments) == 2 && len(segments[0]) > 0 && segments[1] == "extend":
+		extendCluster(w, r, segments[0])
+	case len(segments) == 4 && len(segments[0]) > 0 && segments[1] == "pools" &&
+		len(segments[2]) > 0 && segments[3] == "resize":
+		resizePool(w, r, segments[0], segments[2])
+	case len(segments) == 2 && len(segments[0]) > 0 && segments[1] == "watch":
+		watchClusters(w, r, segments[0])
+	case len(segments) == 2 && len(segments[0]) > 0 && segments[1] == "jobs":
+		routeJobs(w, r, segments[0], "")
+	case len(segments) == 3 && len(segments[0]) > 0 && segments[1] == "jobs" &&
+		len(segments[2]) > 0:
+		routeJobs(w, r, segments[0], segments[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitClustersAPI - Initialize the cluster API
+func InitClustersAPI() {
+	http.HandleFunc("/clusters", authorized(routeClusters))
+	http.HandleFunc("/clusters/", authorized(routeClusters))
+}
@@ -8,9 +8,14 @@ import (
 	"allspark/util/serializer"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 )
 
+const (
+	templateHeader = "X-Allspark-Template"
+)
+
 func validateRequest(r *http.Request, method string) error {
 	if r.Method != method {
 		return errors.New("invalid request method: " + r.Method)
@@ -23,6 +28,164 @@ func validateRequest(r *http.Request, method string) error {
 	return nil
 }
 
+func isEnvironmentEnabled(environment string) bool {
+	config := daemon.GetAllSparkConfig()
+	switch environment {
+	case cloud.Aws:
+		return config.AwsEnabled
+	case cloud.Azure:
+		return config.AzureEnabled
+	case cloud.Docker:
+		return config.DockerEnabled
+	case cloud.Kubernetes:
+		return config.KubernetesEnabled
+	case cloud.Static:
+		return config.StaticEnabled
+	case cloud.Local:
+		return config.LocalEnabled
+	}
+	return false
+}
+
+// isAdminEnvironment - returns true if only admins may launch clusters in
+// the environment; local clusters run commands on the daemon host
+func isAdminEnvironment(environment string) bool {
+	return environment == cloud.Local
+}
+
+// getCaller - identifies the client making the request; used to
+// evaluate per-caller capacity limits, so it must not be chosen by the
+// client: the authenticated identity, or the remote address when
+// authentication is disabled
+func getCaller(r *http.Request) string {
+	identity := getIdentity(r)
+	if len(identity.Name) > 0 {
+		return identity.Name
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// launchCluster - launches the cluster on behalf of the caller and
+// reports the admission outcome
+func launchCluster(w http.ResponseWriter, r *http.Request, request monitor.ClusterRequest) {
+	team, err := getTeam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	request.Caller = getCaller(r)
+	request.Team = team
+	request.Owner = getIdentity(r).Name
+	request.Template = r.Header.Get(templateHeader)
+
+	status, err := monitor.LaunchCluster(request)
+	if errors.Is(err, monitor.ErrQuotaExceeded) || errors.Is(err, monitor.ErrBudgetExceeded) {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if errors.Is(err, monitor.ErrCapacityExceeded) {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if status == monitor.StatusQueued {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("cluster queued; awaiting capacity"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("successfully launched cluster"))
+}
+
+// validateTemplate - validates a serialized template for the
+// specified cloud environment
+func validateTemplate(environment string, buffer []byte) error {
+	if !isEnvironmentEnabled(environment) {
+		return errors.New("cloud environment " + environment + " is not enabled")
+	}
+
+	switch environment {
+	case cloud.Aws:
+		var template cloud.AwsEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateAwsTemplate(template)
+	case cloud.Azure:
+		var template cloud.AzureEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateAzureTemplate(template)
+	case cloud.Docker:
+		var template cloud.DockerEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateDockerTemplate(template)
+	case cloud.Kubernetes:
+		var template cloud.KubernetesEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateKubernetesTemplate(template)
+	case cloud.Static:
+		var template cloud.StaticEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateStaticTemplate(template)
+	case cloud.Local:
+		var template cloud.LocalEnvironment
+		err := serializer.Deserialize(buffer, &template)
+		if err != nil {
+			return err
+		}
+		return validateLocalTemplate(template)
+	}
+
+	return errors.New("invalid cloud-environment " + environment)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, object interface{}) {
+	buffer, err := serializer.Serialize(object)
+	if err != nil {
+		logger.GetError().Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(buffer)
+}
+
 func getStatus(w http.ResponseWriter, r *http.Request) {
 	logger.GetDebug().Println("http-request: /status")
 	err := validateRequest(r, "GET")
@@ -40,6 +203,12 @@ func getStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.GetInfo().Printf("checking status on clusterID %v", clusterID)
 
+	detail, err := monitor.GetClusterDetail(clusterID)
+	if err == nil && !canAccess(getIdentity(r), detail.Team) {
+		writeForbidden(w, "cluster "+clusterID)
+		return
+	}
+
 	status := monitor.GetLastKnownStatus(clusterID)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(status))
@@ -69,6 +238,12 @@ func terminate(w http.ResponseWriter, r *http.Request, environment string) {
 		return
 	}
 
+	detail, err := monitor.GetClusterDetail(clusterID)
+	if err == nil && !canAccess(getIdentity(r), detail.Team) {
+		writeForbidden(w, "cluster "+clusterID)
+		return
+	}
+
 	if clientEnvironment != environment {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("cloud environment does not match for clusterID " + clusterID))
@@ -107,7 +282,21 @@ func checkIn(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.GetInfo().Printf("Form body: %s", buffer)
 
-	monitor.HandleCheckIn(body.ClusterID, body.AppExitStatus, body.Status)
+	if body.Worker != nil {
+		monitor.RegisterWorker(body.ClusterID, *body.Worker)
+	}
+
+	if body.Interruption != nil {
+		monitor.HandleInterruption(body.ClusterID, *body.Interruption)
+	}
+
+	// worker nodes only report their pool and interruptions
+	if len(body.Status.URL) == 0 && (body.Worker != nil || body.Interruption != nil) {
+		return
+	}
+
+	monitor.HandleCheckIn(body.ClusterID, body.AppExitStatus,
+		body.AppResults, body.Status)
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -137,8 +326,29 @@ func Init() {
 		InitDockerAPI()
 	}
 
+	if daemon.GetAllSparkConfig().KubernetesEnabled {
+		InitKubernetesAPI()
+	}
+
+	if daemon.GetAllSparkConfig().StaticEnabled {
+		InitStaticAPI()
+	}
+
+	if daemon.GetAllSparkConfig().LocalEnabled {
+		InitLocalAPI()
+	}
+
+	InitClustersAPI()
+	InitRunsAPI()
+	InitSchedulesAPI()
+	InitTeamsAPI()
+	InitReportsAPI()
+	InitBudgetsAPI()
+	InitHistoryAPI()
+	InitMetricsAPI()
+
 	http.HandleFunc("/check-in", checkIn)
-	http.HandleFunc("/status", getStatus)
+	http.HandleFunc("/status", authorized(getStatus))
 	http.HandleFunc("/health-check", healthCheck)
 	http.ListenAndServe(":32418", nil)
 }
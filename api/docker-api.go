@@ -15,11 +15,27 @@ func validateDockerTemplate(template cloud.DockerEnvironment) error {
 		template.MemBytes < 10 ||
 		template.NanoCpus < 10 ||
 		template.WorkerNodes < 0 ||
+		(template.MasterMemBytes != 0 && template.MasterMemBytes < 10) ||
+		(template.MasterNanoCpus != 0 && template.MasterNanoCpus < 10) ||
 		len(template.Image) == 0 {
 		return errors.New("invalid template object")
 	}
 
-	return nil
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	pools := make([]cloud.WorkerPool, len(template.WorkerPools))
+	for idx, el := range template.WorkerPools {
+		if (el.MemBytes != 0 && el.MemBytes < 10) ||
+			(el.NanoCpus != 0 && el.NanoCpus < 10) {
+			return errors.New("invalid template object")
+		}
+		pools[idx] = el.WorkerPool
+	}
+
+	return cloud.ValidateWorkerPools(pools, int64(template.WorkerNodes))
 }
 
 func validateDockerFormBody(r *http.Request) (*cloud.DockerEnvironment, error) {
@@ -68,29 +84,15 @@ func createClusterDocker(w http.ResponseWriter, r *http.Request) {
 		logger.GetError().Println(err)
 	}
 
-	err = monitor.RegisterCluster(client.ClusterID, cloud.Docker, serializedClient)
-	if err != nil {
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	_, err = client.CreateCluster()
-	if err != nil {
-		monitor.SetCanceled(client.ClusterID)
-		logger.GetError().Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("successfully launched cluster"))
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Docker,
+		Client:           serializedClient,
+	})
 }
 
 // InitDockerAPI - Initialize the Docker API
 func InitDockerAPI() {
-	http.HandleFunc("/docker/create", createClusterDocker)
-	http.HandleFunc("/docker/terminate", terminateDocker)
+	http.HandleFunc("/docker/create", authorized(createClusterDocker))
+	http.HandleFunc("/docker/terminate", authorized(terminateDocker))
 }
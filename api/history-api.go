@@ -0,0 +1,92 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"encoding/csv"
+	"net/http"
+	"time"
+)
+
+// Cluster history export formats
+const (
+	historyFormatJSON      = "json"
+	historyFormatJSONLines = "jsonl"
+	historyFormatCSV       = "csv"
+)
+
+func writeHistoryJSONLines(w http.ResponseWriter, history []monitor.ClusterSummary) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	for _, el := range history {
+		buffer, err := serializer.Serialize(el)
+		if err != nil {
+			logger.GetError().Println(err)
+			continue
+		}
+		w.Write(append(buffer, '\n'))
+	}
+}
+
+func writeHistoryCSV(w http.ResponseWriter, history []monitor.ClusterSummary) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"cluster-history.csv\"")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(monitor.HistoryCSVHeader())
+	for _, el := range history {
+		writer.Write(el.CSVRecord())
+	}
+	writer.Flush()
+}
+
+// getHistory - exports the summaries of clusters deregistered between
+// the from and to days (inclusive) as json, json lines or csv
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: /history")
+	err := validateRequest(r, "GET")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	from, to, err := parseReportPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day()+1, 0, 0, 0, 0, time.UTC)
+	if toDay.Before(fromDay) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("history end precedes history start"))
+		return
+	}
+
+	identity := getIdentity(r)
+	history := monitor.GetClusterHistory(fromDay.Unix(), toDay.Unix()-1, func(team string) bool {
+		return canAccess(identity, team)
+	})
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", historyFormatJSON:
+		writeJSON(w, http.StatusOK, history)
+	case historyFormatJSONLines:
+		writeHistoryJSONLines(w, history)
+	case historyFormatCSV:
+		writeHistoryCSV(w, history)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid format " + format + "; expected json, jsonl or csv"))
+	}
+}
+
+// InitHistoryAPI - Initialize the cluster history API
+func InitHistoryAPI() {
+	http.HandleFunc("/history", authorized(getHistory))
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"allspark/cloud"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"io/ioutil"
+	"net/http"
+)
+
+func validateJobFormBody(r *http.Request) (*cloud.SparkJobSpec, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.GetInfo().Printf("Form body: %s", buffer)
+
+	var spec cloud.SparkJobSpec
+	err = serializer.Deserialize(buffer, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cloud.ValidateSparkJobSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+func submitJob(w http.ResponseWriter, r *http.Request, clusterID string) {
+	spec, err := validateJobFormBody(r)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := monitor.SubmitJob(clusterID, *spec)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func listJobs(w http.ResponseWriter, r *http.Request, clusterID string) {
+	jobs, err := monitor.GetJobs(clusterID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func getJob(w http.ResponseWriter, r *http.Request, clusterID string, jobID string) {
+	job, err := monitor.GetJob(clusterID, jobID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func killJob(w http.ResponseWriter, r *http.Request, clusterID string, jobID string) {
+	logger.GetInfo().Printf("handling kill request for job %v on cluster %v",
+		jobID, clusterID)
+
+	job, err := monitor.KillJob(clusterID, jobID)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func routeJobs(w http.ResponseWriter, r *http.Request, clusterID string, jobID string) {
+	switch {
+	case len(jobID) == 0 && r.Method == "POST":
+		submitJob(w, r, clusterID)
+	case len(jobID) == 0 && r.Method == "GET":
+		listJobs(w, r, clusterID)
+	case len(jobID) > 0 && r.Method == "GET":
+		getJob(w, r, clusterID, jobID)
+	case len(jobID) > 0 && r.Method == "DELETE":
+		killJob(w, r, clusterID, jobID)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid request method: " + r.Method))
+	}
+}
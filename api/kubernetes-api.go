@@ -0,0 +1,129 @@
+package api
+
+import (
+	"allspark/cloud"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+func validateKubernetesTemplate(template cloud.KubernetesEnvironment) error {
+	if len(template.ClusterID) == 0 ||
+		template.WorkerNodes < 0 ||
+		len(template.Image) == 0 {
+		return errors.New("invalid template object")
+	}
+
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateKubernetesNamespace(template.Namespace)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateKubernetesWorkerResources(template.Resources)
+	if err != nil {
+		return err
+	}
+
+	if template.MasterResources != nil {
+		err = cloud.ValidateKubernetesResources(*template.MasterResources)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cloud.ValidateKubernetesTolerations(template.Tolerations)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateEnvParams(template.EnvParams)
+	if err != nil {
+		return err
+	}
+
+	pools := make([]cloud.WorkerPool, len(template.WorkerPools))
+	for idx, el := range template.WorkerPools {
+		if el.Resources != nil {
+			err = cloud.ValidateKubernetesWorkerResources(*el.Resources)
+			if err != nil {
+				return err
+			}
+		}
+		err = cloud.ValidateKubernetesTolerations(el.Tolerations)
+		if err != nil {
+			return err
+		}
+		err = cloud.ValidateEnvParams(el.EnvParams)
+		if err != nil {
+			return err
+		}
+		pools[idx] = el.WorkerPool
+	}
+
+	return cloud.ValidateWorkerPools(pools, template.WorkerNodes)
+}
+
+func validateKubernetesFormBody(r *http.Request) (*cloud.KubernetesEnvironment, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var template cloud.KubernetesEnvironment
+	err = serializer.Deserialize(buffer, &template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateKubernetesTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func terminateKubernetes(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /kubernetes/terminate")
+	terminate(w, r, cloud.Kubernetes)
+}
+
+func createClusterKubernetes(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /kubernetes/create")
+	client, err := validateKubernetesFormBody(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Kubernetes,
+		Client:           serializedClient,
+	})
+}
+
+// InitKubernetesAPI - Initialize the Kubernetes API
+func InitKubernetesAPI() {
+	http.HandleFunc("/kubernetes/create", authorized(createClusterKubernetes))
+	http.HandleFunc("/kubernetes/terminate", authorized(terminateKubernetes))
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"allspark/cloud"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+func validateLocalTemplate(template cloud.LocalEnvironment) error {
+	if len(template.ClusterID) == 0 ||
+		template.WorkerNodes < 0 ||
+		template.Cores < 0 ||
+		template.MemBytes < 0 {
+		return errors.New("invalid template object")
+	}
+
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateEnvParams(template.EnvParams)
+	if err != nil {
+		return err
+	}
+
+	for _, el := range template.WorkerPools {
+		err = cloud.ValidateEnvParams(el.EnvParams)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cloud.ValidateWorkerPools(template.WorkerPools, template.WorkerNodes)
+}
+
+func validateLocalFormBody(r *http.Request) (*cloud.LocalEnvironment, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.GetInfo().Printf("Form body: %s", buffer)
+
+	var template cloud.LocalEnvironment
+	err = serializer.Deserialize(buffer, &template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateLocalTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func terminateLocal(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /local/terminate")
+	terminate(w, r, cloud.Local)
+}
+
+func createClusterLocal(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /local/create")
+	if !getIdentity(r).Admin {
+		writeForbidden(w, "local clusters")
+		return
+	}
+
+	client, err := validateLocalFormBody(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Local,
+		Client:           serializedClient,
+	})
+}
+
+// InitLocalAPI - Initialize the local process API
+func InitLocalAPI() {
+	http.HandleFunc("/local/create", authorized(createClusterLocal))
+	http.HandleFunc("/local/terminate", authorized(terminateLocal))
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/monitor"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetricHeader(builder *strings.Builder, name string, help string) {
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func writeClusterMetric(builder *strings.Builder, name string,
+	cluster monitor.ClusterDetail, value float64) {
+
+	fmt.Fprintf(builder, "%s{cluster=\"%s\",environment=\"%s\",team=\"%s\",template=\"%s\"} %v\n",
+		name, metricLabelEscaper.Replace(cluster.ClusterID),
+		metricLabelEscaper.Replace(cluster.CloudEnvironment),
+		metricLabelEscaper.Replace(cluster.Team),
+		metricLabelEscaper.Replace(cluster.Template), value)
+}
+
+// getMetrics - reports the estimated cost of registered clusters in the
+// prometheus text exposition format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: /metrics")
+	err := validateRequest(r, "GET")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	identity := getIdentity(r)
+	clusters := make([]monitor.ClusterDetail, 0)
+	for _, el := range monitor.GetClusters() {
+		if canAccess(identity, el.Team) {
+			clusters = append(clusters, el)
+		}
+	}
+
+	var builder strings.Builder
+	writeMetricHeader(&builder, "allspark_cluster_hourly_cost",
+		"Estimated hourly cost of the cluster.")
+	for _, el := range clusters {
+		writeClusterMetric(&builder, "allspark_cluster_hourly_cost", el, el.HourlyCost)
+	}
+
+	writeMetricHeader(&builder, "allspark_cluster_estimated_cost",
+		"Estimated cost accrued by the cluster since registration.")
+	for _, el := range clusters {
+		writeClusterMetric(&builder, "allspark_cluster_estimated_cost", el, el.EstimatedCost)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(builder.String()))
+}
+
+// InitMetricsAPI - Initialize the metrics API
+func InitMetricsAPI() {
+	http.HandleFunc("/metrics", authorized(getMetrics))
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/monitor"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	reportDayFormat     = "2006-01-02"
+	defaultReportPeriod = 30
+)
+
+// parseReportPeriod - returns the days requested by the from and to query
+// parameters; defaults to the last 30 days
+func parseReportPeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if value := r.URL.Query().Get("to"); len(value) > 0 {
+		parsed, err := time.Parse(reportDayFormat, value)
+		if err != nil {
+			return to, to, err
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, 1-defaultReportPeriod)
+	if value := r.URL.Query().Get("from"); len(value) > 0 {
+		parsed, err := time.Parse(reportDayFormat, value)
+		if err != nil {
+			return from, to, err
+		}
+		from = parsed
+	}
+
+	return from, to, nil
+}
+
+func getCostReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseReportPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	identity := getIdentity(r)
+	report, err := monitor.GetCostReport(from, to, func(team string) bool {
+		return canAccess(identity, team)
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+func routeReports(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: " + r.URL.Path)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/reports"), "/")
+
+	switch {
+	case path == "cost" && r.Method == "GET":
+		getCostReport(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitReportsAPI - Initialize the reports API
+func InitReportsAPI() {
+	http.HandleFunc("/reports/", authorized(routeReports))
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"allspark/cloud"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func validateRunFormBody(r *http.Request) (*monitor.RunRequest, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var request monitor.RunRequest
+	err = serializer.Deserialize(buffer, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cloud.ValidateSparkJobSpec(request.Job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func createRun(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /runs")
+	request, err := validateRunFormBody(r)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	runID := monitor.NewRunID()
+	template, err := cloud.SetClusterID(request.Template, runID)
+	if err == nil {
+		err = validateTemplate(request.CloudEnvironment, template)
+	}
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if isAdminEnvironment(request.CloudEnvironment) && !getIdentity(r).Admin {
+		writeForbidden(w, request.CloudEnvironment+" clusters")
+		return
+	}
+
+	team, err := getTeam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	run, err := monitor.StartRun(monitor.ClusterRequest{
+		CloudEnvironment: request.CloudEnvironment,
+		Client:           template,
+		RunID:            runID,
+		Caller:           getCaller(r),
+		Team:             team,
+		Owner:            getIdentity(r).Name,
+		Template:         r.Header.Get(templateHeader),
+	}, request.Job)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
+
+func getRun(w http.ResponseWriter, r *http.Request, runID string) {
+	err := validateRequest(r, "GET")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	run, err := monitor.GetRun(runID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !canAccess(getIdentity(r), run.Team) {
+		writeForbidden(w, "run "+runID)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
+
+func listRuns(w http.ResponseWriter, r *http.Request) {
+	identity := getIdentity(r)
+	runs := make([]monitor.SparkRun, 0)
+	for _, el := range monitor.GetRuns() {
+		if canAccess(identity, el.Team) {
+			runs = append(runs, el)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func routeRuns(w http.ResponseWriter, r *http.Request) {
+	runID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+
+	switch {
+	case len(runID) == 0 && r.Method == "GET":
+		listRuns(w, r)
+	case len(runID) == 0:
+		createRun(w, r)
+	case !strings.Contains(runID, "/"):
+		getRun(w, r, runID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitRunsAPI - Initialize the runs API
+func InitRunsAPI() {
+	http.HandleFunc("/runs", authorized(routeRuns))
+	http.HandleFunc("/runs/", authorized(routeRuns))
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/scheduler"
+	"allspark/util/serializer"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func validateScheduleFormBody(r *http.Request) (*scheduler.Schedule, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedule scheduler.Schedule
+	err = serializer.Deserialize(buffer, &schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scheduler.ValidateSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateTemplate(schedule.CloudEnvironment, schedule.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+func putSchedule(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /schedules")
+	request, err := validateScheduleFormBody(r)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	identity := getIdentity(r)
+	if isAdminEnvironment(request.CloudEnvironment) && !identity.Admin {
+		writeForbidden(w, request.CloudEnvironment+" clusters")
+		return
+	}
+
+	existing, err := scheduler.GetSchedule(request.Name)
+	if err == nil && !canAccess(identity, existing.Team) {
+		writeForbidden(w, "schedule "+request.Name)
+		return
+	}
+
+	request.Team, err = resolveTeam(identity, r.Header.Get(teamHeader))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	request.Owner = identity.Name
+
+	schedule, err := scheduler.PutSchedule(*request)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func getSchedule(w http.ResponseWriter, r *http.Request, name string) {
+	schedule, err := scheduler.GetSchedule(name)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func listSchedules(w http.ResponseWriter, r *http.Request) {
+	identity := getIdentity(r)
+	schedules := make([]scheduler.Schedule, 0)
+	for _, el := range scheduler.GetSchedules() {
+		if canAccess(identity, el.Team) {
+			schedules = append(schedules, el)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// authorizeSchedule - verifies the caller may access an existing schedule;
+// missing schedules are left to the handler to report
+func authorizeSchedule(w http.ResponseWriter, r *http.Request, name string) bool {
+	schedule, err := scheduler.GetSchedule(name)
+	if err == nil && !canAccess(getIdentity(r), schedule.Team) {
+		writeForbidden(w, "schedule "+name)
+		return false
+	}
+	return true
+}
+
+func deleteSchedule(w http.ResponseWriter, r *http.Request, name string) {
+	logger.GetInfo().Printf("handling delete request for schedule %v", name)
+	err := scheduler.DeleteSchedule(name)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func getScheduleHistory(w http.ResponseWriter, r *http.Request, name string) {
+	history, err := scheduler.GetScheduleHistory(name)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func routeSchedules(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: " + r.URL.Path)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedules"), "/")
+	segments := strings.Split(path, "/")
+
+	if len(path) > 0 && !authorizeSchedule(w, r, segments[0]) {
+		return
+	}
+
+	switch {
+	case len(path) == 0 && r.Method == "GET":
+		listSchedules(w, r)
+	case len(path) == 0:
+		putSchedule(w, r)
+	case len(segments) == 1 && r.Method == "GET":
+		getSchedule(w, r, segments[0])
+	case len(segments) == 1 && r.Method == "DELETE":
+		deleteSchedule(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "history" && r.Method == "GET":
+		getScheduleHistory(w, r, segments[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitSchedulesAPI - Initialize the schedules API
+func InitSchedulesAPI() {
+	http.HandleFunc("/schedules", authorized(routeSchedules))
+	http.HandleFunc("/schedules/", authorized(routeSchedules))
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"allspark/cloud"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+func validateStaticTemplate(template cloud.StaticEnvironment) error {
+	if len(template.ClusterID) == 0 ||
+		template.MemBytes < 10 ||
+		template.NanoCpus < 10 ||
+		template.WorkerNodes < 0 ||
+		(template.MasterMemBytes != 0 && template.MasterMemBytes < 10) ||
+		(template.MasterNanoCpus != 0 && template.MasterNanoCpus < 10) ||
+		len(template.Image) == 0 {
+		return errors.New("invalid template object")
+	}
+
+	err := cloud.ValidateRetryPolicy(template.RetryPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.ValidateEnvParams(template.EnvParams)
+	if err != nil {
+		return err
+	}
+
+	pools := make([]cloud.WorkerPool, len(template.WorkerPools))
+	for idx, el := range template.WorkerPools {
+		if (el.MemBytes != 0 && el.MemBytes < 10) ||
+			(el.NanoCpus != 0 && el.NanoCpus < 10) {
+			return errors.New("invalid template object")
+		}
+		err = cloud.ValidateEnvParams(el.EnvParams)
+		if err != nil {
+			return err
+		}
+		pools[idx] = el.WorkerPool
+	}
+
+	return cloud.ValidateWorkerPools(pools, template.WorkerNodes)
+}
+
+func validateStaticFormBody(r *http.Request) (*cloud.StaticEnvironment, error) {
+	err := validateRequest(r, "POST")
+	if err != nil {
+		return nil, err
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.GetInfo().Printf("Form body: %s", buffer)
+
+	var template cloud.StaticEnvironment
+	err = serializer.Deserialize(buffer, &template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateStaticTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func terminateStatic(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /static/terminate")
+	terminate(w, r, cloud.Static)
+}
+
+func createClusterStatic(w http.ResponseWriter, r *http.Request) {
+	logger.GetInfo().Println("http-request: /static/create")
+	client, err := validateStaticFormBody(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+
+	launchCluster(w, r, monitor.ClusterRequest{
+		ClusterID:        client.ClusterID,
+		CloudEnvironment: cloud.Static,
+		Client:           serializedClient,
+	})
+}
+
+// InitStaticAPI - Initialize the static host pool API
+func InitStaticAPI() {
+	http.HandleFunc("/static/create", authorized(createClusterStatic))
+	http.HandleFunc("/static/terminate", authorized(terminateStatic))
+}
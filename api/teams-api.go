@@ -0,0 +1,132 @@
+package api
+
+import (
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func listTeams(w http.ResponseWriter, r *http.Request) {
+	identity := getIdentity(r)
+	teams := identity.Teams
+	if identity.Admin {
+		teams = monitor.GetTeams()
+	}
+
+	usage := make([]monitor.TeamUsage, 0, len(teams))
+	for _, team := range teams {
+		usage = append(usage, monitor.GetTeamUsage(team, time.Now().Unix()))
+	}
+
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func getTeamUsage(w http.ResponseWriter, r *http.Request, team string) {
+	if !canAccess(getIdentity(r), team) {
+		writeForbidden(w, "team "+team)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, monitor.GetTeamUsage(team, time.Now().Unix()))
+}
+
+func getTeamQuota(w http.ResponseWriter, r *http.Request, team string) {
+	if !canAccess(getIdentity(r), team) {
+		writeForbidden(w, "team "+team)
+		return
+	}
+
+	quota := monitor.GetTeamQuota(team)
+	if quota == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("quota for team " + team + " not found"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, quota)
+}
+
+func setTeamQuota(w http.ResponseWriter, r *http.Request, team string) {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("form body is null"))
+		return
+	}
+
+	buffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	logger.GetInfo().Printf("Form body: %s", buffer)
+
+	var quota monitor.TeamQuota
+	err = serializer.Deserialize(buffer, &quota)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	quota.Team = team
+	err = monitor.SetTeamQuota(quota)
+	if err != nil {
+		logger.GetError().Println(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, quota)
+}
+
+func deleteTeamQuota(w http.ResponseWriter, r *http.Request, team string) {
+	err := monitor.DeleteTeamQuota(team)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func routeTeams(w http.ResponseWriter, r *http.Request) {
+	logger.GetDebug().Println("http-request: " + r.URL.Path)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/teams"), "/")
+	segments := strings.Split(path, "/")
+
+	isQuota := len(segments) == 2 && len(segments[0]) > 0 && segments[1] == "quota"
+	if isQuota && r.Method != "GET" && !getIdentity(r).Admin {
+		writeForbidden(w, "team quotas")
+		return
+	}
+
+	switch {
+	case len(path) == 0 && r.Method == "GET":
+		listTeams(w, r)
+	case len(segments) == 1 && r.Method == "GET":
+		getTeamUsage(w, r, segments[0])
+	case isQuota && r.Method == "GET":
+		getTeamQuota(w, r, segments[0])
+	case isQuota && (r.Method == "PUT" || r.Method == "POST"):
+		setTeamQuota(w, r, segments[0])
+	case isQuota && r.Method == "DELETE":
+		deleteTeamQuota(w, r, segments[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("route not found: " + r.URL.Path))
+	}
+}
+
+// InitTeamsAPI - Initialize the teams API
+func InitTeamsAPI() {
+	http.HandleFunc("/teams", authorized(routeTeams))
+	http.HandleFunc("/teams/", authorized(routeTeams))
+}
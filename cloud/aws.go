@@ -18,24 +18,55 @@ type imageFilter struct {
 	Values []string
 }
 
-// AwsEnvironment interface
+// AwsEnvironment interface; InstanceType and EBSVolumeSize describe the
+// worker nodes and, unless overridden, the master node and worker pools.
+// SubnetID and SubnetIDs are tried in turn when an availability zone lacks
+// capacity; the subnet of the master is recorded as LaunchSubnetID
 type AwsEnvironment struct {
-	ClusterID        string
-	Image            []imageFilter
-	InstanceType     string
-	EBSVolumeSize    int64
-	SubnetID         string
-	SecurityGroupIds []string
-	WorkerNodes      int64
-	Region           string
-	IAMRole          string
-	KeyName          string
-	EnvParams        []string
-	AssumeArn        string
-	ExternalID       string
+	ClusterID             string
+	Image                 []imageFilter
+	InstanceType          string
+	EBSVolumeSize         int64
+	MasterInstanceType    string `json:",omitempty"`
+	MasterEBSVolumeSize   int64  `json:",omitempty"`
+	SubnetID              string
+	SubnetIDs             []string `json:",omitempty"`
+	LaunchSubnetID        string   `json:",omitempty"`
+	AllowCrossZoneWorkers bool     `json:",omitempty"`
+	SecurityGroupIds      []string
+	WorkerNodes           int64
+	Region                string
+	IAMRole               string
+	KeyName               string
+	EnvParams             []string
+	AssumeArn             string
+	ExternalID            string
+	RetryPolicy           *RetryPolicy
+	Spot                  *SpotOptions        `json:",omitempty"`
+	WorkerPools           []AwsWorkerPool     `json:",omitempty"`
+	LaunchTemplate        *AwsLaunchTemplate  `json:",omitempty"`
+	RootVolume            *AwsVolume          `json:",omitempty"`
+	DataVolumes           []AwsVolume         `json:",omitempty"`
+	KmsKeyID              string              `json:",omitempty"`
+	PlacementGroup        string              `json:",omitempty"`
+	RequireIMDSv2         bool                `json:",omitempty"`
+	Tags                  map[string]string   `json:",omitempty"`
+	IAMInstanceProfileArn string              `json:",omitempty"`
+	AutoScaling           *AutoScalingOptions `json:",omitempty"`
 }
 
-func (e *AwsEnvironment) getEc2Client() *ec2.EC2 {
+// AwsWorkerPool - a worker pool of an AWS cluster; the nodes of Spot
+// pools are launched with the Spot options of the template
+type AwsWorkerPool struct {
+	WorkerPool
+	InstanceType  string `json:",omitempty"`
+	EBSVolumeSize int64  `json:",omitempty"`
+	Spot          bool   `json:",omitempty"`
+}
+
+// getAwsSession - returns the session and configuration of the clients
+// of the environment, assuming the configured role if any
+func (e *AwsEnvironment) getAwsSession() (*session.Session, *aws.Config) {
 	if len(e.AssumeArn) > 0 {
 		sess := session.Must(session.NewSession(&aws.Config{
 			Region: aws.String(e.Region)},
@@ -45,10 +76,10 @@ func (e *AwsEnvironment) getEc2Client() *ec2.EC2 {
 			creds := stscreds.NewCredentials(sess, e.AssumeArn, func(p *stscreds.AssumeRoleProvider) {
 				p.ExternalID = aws.String(e.ExternalID)
 			})
-			return ec2.New(sess, &aws.Config{Credentials: creds})
+			return sess, &aws.Config{Credentials: creds}
 		}
 		creds := stscreds.NewCredentials(sess, e.AssumeArn)
-		return ec2.New(sess, &aws.Config{Credentials: creds})
+		return sess, &aws.Config{Credentials: creds}
 	}
 
 	sess, err := session.NewSession(&aws.Config{
@@ -59,7 +90,12 @@ func (e *AwsEnvironment) getEc2Client() *ec2.EC2 {
 		logger.GetError().Println(err)
 	}
 
-	return ec2.New(sess)
+	return sess, &aws.Config{}
+}
+
+func (e *AwsEnvironment) getEc2Client() *ec2.EC2 {
+	sess, config := e.getAwsSession()
+	return ec2.New(sess, config)
 }
 
 func (e *AwsEnvironment) resolveAMI() (string, error) {
@@ -94,58 +130,164 @@ func (e *AwsEnvironment) resolveAMI() (string, error) {
 	return *resp.Images[0].ImageId, err
 }
 
-func (e *AwsEnvironment) launchInstances(identifier string,
-	instanceCount int64, userData string) (*ec2.Reservation, error) {
+// describeMemoryGB - returns the memory of an EC2 instance type
+func (e *AwsEnvironment) describeMemoryGB(instanceType string) (float64, error) {
+	resp, err := e.getEc2Client().DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{
+		InstanceTypes: aws.StringSlice([]string{instanceType}),
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	cli := e.getEc2Client()
-	encodedUserData := b64.StdEncoding.EncodeToString([]byte(userData))
+	if len(resp.InstanceTypes) == 0 || resp.InstanceTypes[0].MemoryInfo == nil {
+		return 0, errors.New("instance type " + instanceType + " not found")
+	}
 
-	imageID, err := e.resolveAMI()
-	if err != nil {
-		return nil, err
+	return float64(aws.Int64Value(resp.InstanceTypes[0].MemoryInfo.SizeInMiB)) / 1024, nil
+}
+
+// masterGroup - returns the shape of the master node
+func (e *AwsEnvironment) masterGroup() NodeGroup {
+	group := NodeGroup{InstanceType: e.InstanceType, Nodes: 1, DiskGB: e.EBSVolumeSize}
+	if len(e.MasterInstanceType) > 0 {
+		group.InstanceType = e.MasterInstanceType
+	}
+	if e.MasterEBSVolumeSize > 0 {
+		group.DiskGB = e.MasterEBSVolumeSize
+	}
+	return group
+}
+
+// workerPools - returns the worker pools of the cluster with the shapes
+// of the template applied; templates without pools have a default pool
+func (e *AwsEnvironment) workerPools() []AwsWorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []AwsWorkerPool{{
+			WorkerPool:    WorkerPool{Name: DefaultWorkerPool, Nodes: e.WorkerNodes},
+			InstanceType:  e.InstanceType,
+			EBSVolumeSize: e.EBSVolumeSize,
+			Spot:          e.Spot != nil,
+		}}
+	}
+
+	pools := make([]AwsWorkerPool, len(e.WorkerPools))
+	for idx, el := range e.WorkerPools {
+		if len(el.InstanceType) == 0 {
+			el.InstanceType = e.InstanceType
+		}
+		if el.EBSVolumeSize == 0 {
+			el.EBSVolumeSize = e.EBSVolumeSize
+		}
+		pools[idx] = el
+	}
+	return pools
+}
+
+// workerGroups - returns the shape of the worker nodes of each pool
+func (e *AwsEnvironment) workerGroups() []NodeGroup {
+	var groups []NodeGroup
+	for _, el := range e.workerPools() {
+		groups = append(groups, NodeGroup{InstanceType: el.InstanceType,
+			Nodes: el.Nodes, DiskGB: el.EBSVolumeSize, Pool: el.Name})
+	}
+	return groups
+}
+
+// workerCount - returns the number of worker nodes across all pools
+func (e *AwsEnvironment) workerCount() int64 {
+	var count int64
+	for _, el := range e.workerPools() {
+		count += el.Nodes
 	}
+	return count
+}
+
+// spotOptions - returns the spot options of a pool, or nil if its nodes
+// are launched on-demand
+func (e *AwsEnvironment) spotOptions(pool AwsWorkerPool) *SpotOptions {
+	if !pool.Spot {
+		return nil
+	}
+	if e.Spot == nil {
+		return &SpotOptions{}
+	}
+	return e.Spot
+}
+
+// runInstancesInput - returns the parameters of the instances of a node group
+func (e *AwsEnvironment) runInstancesInput(identifier string, group NodeGroup,
+	userData string, labels map[string]string) (*ec2.RunInstancesInput, error) {
+
+	encodedUserData := b64.StdEncoding.EncodeToString([]byte(userData))
 
 	input := &ec2.RunInstancesInput{
 
-		ImageId:          aws.String(imageID),
-		InstanceType:     aws.String(e.InstanceType),
-		MinCount:         aws.Int64(instanceCount),
-		MaxCount:         aws.Int64(instanceCount),
-		SecurityGroupIds: aws.StringSlice(e.SecurityGroupIds),
-		SubnetId:         aws.String(e.SubnetID),
-		UserData:         aws.String(encodedUserData),
-		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-			Name: aws.String(e.IAMRole),
-		},
+		InstanceType:        aws.String(group.InstanceType),
+		MinCount:            aws.Int64(group.Nodes),
+		MaxCount:            aws.Int64(group.Nodes),
+		UserData:            aws.String(encodedUserData),
+		TagSpecifications:   e.tagSpecifications(identifier, labels),
+		BlockDeviceMappings: e.blockDeviceMappings(group.DiskGB),
+	}
 
-		TagSpecifications: []*ec2.TagSpecification{
-			{
-				ResourceType: aws.String("instance"),
-				Tags: []*ec2.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(identifier),
-					},
-				},
-			},
-		},
+	// the image may be provided by the launch template instead
+	if len(e.Image) > 0 {
+		imageID, err := e.resolveAMI()
+		if err != nil {
+			return nil, err
+		}
+		input.ImageId = aws.String(imageID)
+	}
 
-		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
-			{
-				DeviceName: aws.String("/dev/xvda"),
-				Ebs: &ec2.EbsBlockDevice{
-					Encrypted:  aws.Bool(true),
-					VolumeSize: aws.Int64(e.EBSVolumeSize),
-					VolumeType: aws.String("gp2"),
-				},
-			},
-		},
+	if len(e.SecurityGroupIds) > 0 {
+		input.SecurityGroupIds = aws.StringSlice(e.SecurityGroupIds)
+	}
+
+	e.applyLaunchOptions(input)
+
+	// the volumes of the launch template take precedence
+	if e.LaunchTemplate != nil {
+		definesVolumes, err := e.launchTemplateDefinesVolumes()
+		if err != nil {
+			return nil, err
+		}
+		if definesVolumes {
+			input.BlockDeviceMappings = nil
+			if e.RootVolume != nil || len(e.DataVolumes) > 0 {
+				logger.GetInfo().Printf("launch template of %s maps block devices; "+
+					"ignoring RootVolume and DataVolumes", identifier)
+			}
+		}
 	}
 
 	if len(e.KeyName) > 0 {
 		input.KeyName = aws.String(e.KeyName)
 	}
 
+	return input, nil
+}
+
+func (e *AwsEnvironment) launchInstances(identifier string, group NodeGroup, userData string,
+	labels map[string]string, spot *SpotOptions, subnets []string) (*ec2.Reservation, string, error) {
+
+	input, err := e.runInstancesInput(identifier, group, userData, labels)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cli := e.getEc2Client()
+	return launchInSubnets(identifier, subnets, input,
+		func(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+			if spot != nil {
+				return launchSpotInstances(cli, identifier, input, spot)
+			}
+			return runInstances(cli, identifier, input)
+		})
+}
+
+func runInstances(cli *ec2.EC2, identifier string,
+	input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+
 	resp, err := cli.RunInstances(input)
 
 	if err != nil {
@@ -155,11 +297,6 @@ func (e *AwsEnvironment) launchInstances(identifier string,
 	for _, el := range resp.Instances {
 		logger.GetInfo().Printf("launched ec2 instance %s, with identifier %s",
 			*el.InstanceId, identifier)
-
-		if err != nil {
-			return resp, err
-		}
-
 	}
 
 	return resp, nil
@@ -189,55 +326,116 @@ func (e *AwsEnvironment) getPublicIP(instanceID string) (string, error) {
 
 func (e *AwsEnvironment) launchMaster() (string, string, error) {
 
-	workers := strconv.FormatInt(e.WorkerNodes, 10)
+	workers := strconv.FormatInt(e.workerCount(), 10)
 	userData := "EXPECTED_WORKERS=" + workers +
 		"\nSPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10) +
 		"\nCLUSTER_ID=" + e.ClusterID +
 		"\nALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL
 
+	var instanceTypes []string
+	for _, el := range e.workerPools() {
+		if el.Nodes > 0 {
+			instanceTypes = append(instanceTypes, el.InstanceType)
+		}
+	}
+
+	if e.AutoScaling != nil {
+		instanceTypes = append(instanceTypes, e.AutoScaling.InstanceTypes...)
+	}
+
+	executorMemory, err := getExecutorMemory(e.describeMemoryGB, instanceTypes...)
+	if err != nil {
+		return "", "", err
+	}
+	if len(executorMemory) > 0 {
+		userData += "\nEXECUTOR_MEMORY=" + executorMemory
+	}
+
 	for _, el := range e.EnvParams {
 		userData += "\n" + el
 	}
 
-	res, err := e.launchInstances(e.ClusterID+masterIdentifier, 1, userData)
+	var spot *SpotOptions
+	if e.Spot != nil && e.Spot.IncludeMaster {
+		spot = e.Spot
+		userData += "\n" + spotEnvParam
+	}
+
+	res, subnet, err := e.launchInstances(e.ClusterID+masterIdentifier, e.masterGroup(),
+		userData, nil, spot, e.candidateSubnets())
 	if err != nil {
 		return "", "", err
 	}
 
+	logger.GetInfo().Printf("launched master of cluster %s in subnet %s", e.ClusterID, subnet)
+	e.LaunchSubnetID = subnet
+
 	privateIP := *res.Instances[0].PrivateIpAddress
 
 	return *res.Instances[0].InstanceId, privateIP, err
 }
 
-func (e *AwsEnvironment) launchWorkers(masterIP string) (*ec2.Reservation, error) {
+func (e *AwsEnvironment) launchWorkers(masterIP string, pool AwsWorkerPool) (*ec2.Reservation, error) {
 
 	userData := "MASTER_IP=" + masterIP +
-		"\nSPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10)
+		"\nSPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10) +
+		"\nCLUSTER_ID=" + e.ClusterID +
+		"\nALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL
 
-	for _, el := range e.EnvParams {
+	for _, el := range poolEnvParams(e.EnvParams, pool.WorkerPool) {
 		userData += "\n" + el
 	}
 
-	return e.launchInstances(e.ClusterID+workerIdentifier,
-		e.WorkerNodes, userData)
+	group := NodeGroup{InstanceType: pool.InstanceType, Nodes: pool.Nodes,
+		DiskGB: pool.EBSVolumeSize, Pool: pool.Name}
+	if e.AutoScaling != nil {
+		// interrupted workers are replaced by the auto scaling group
+		userData += "\nALLSPARK_REPLACES_WORKERS=true"
+		return nil, e.createWorkerGroup(poolIdentifier(e.ClusterID, pool.Name), group,
+			userData, pool)
+	}
+
+	spot := e.spotOptions(pool)
+	if spot != nil {
+		userData += "\n" + spotEnvParam
+	}
+
+	res, _, err := e.launchInstances(poolIdentifier(e.ClusterID, pool.Name), group,
+		userData, pool.Labels, spot, e.workerSubnets())
+	return res, err
 }
 
 // CreateCluster - creates a spark cluster in AWS
 func (e *AwsEnvironment) CreateCluster() (string, error) {
+	e.LaunchSubnetID = ""
 	_, privateIP, err := e.launchMaster()
 	if err != nil {
 		return "", err
 	}
 
-	if e.WorkerNodes > 0 {
-		_, err = e.launchWorkers(privateIP)
+	for _, el := range e.workerPools() {
+		if el.Nodes == 0 {
+			continue
+		}
+
+		_, err = e.launchWorkers(privateIP, el)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	return "", err
+	return "", nil
 }
 
-// DestroyCluster - destroys a spark cluster in AWS
+// DestroyCluster - destroys a spark cluster in AWS; the deletion of the
+// auto scaling groups of the workers is requested first, so that they do
+// not replace the terminated instances
 func (e *AwsEnvironment) DestroyCluster() error {
+	err := e.deleteWorkerGroups()
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+
 	cli := e.getEc2Client()
 	instances, err := e.getClusterNodes()
 	if err != nil {
@@ -258,7 +456,9 @@ func (e *AwsEnvironment) DestroyCluster() error {
 	return err
 }
 
-// DestructionConfirmed - returns true if the cluster has been terminated; false otherwise
+// DestructionConfirmed - returns true if the cluster has been terminated; false otherwise.
+// The launch templates of the auto scaling groups are deleted once the
+// groups are gone
 func (e *AwsEnvironment) DestructionConfirmed() bool {
 	instances, err := e.getClusterNodes()
 	if err != nil {
@@ -267,9 +467,44 @@ func (e *AwsEnvironment) DestructionConfirmed() bool {
 		return false
 	}
 
+	groups, err := e.getWorkerGroups()
+	if err != nil {
+		logger.GetError().Println(err)
+		logger.GetError().Printf("unable to confirm destruction of cluster %v; failed to retrieve auto scaling groups",
+			e.ClusterID)
+		return false
+	}
+
+	if len(groups) > 0 {
+		return false
+	}
+
+	names := e.workerGroupNames()
+	templates, err := e.getLaunchTemplates(names)
+	if err != nil {
+		logger.GetError().Println(err)
+		logger.GetError().Printf("unable to confirm destruction of cluster %v; failed to retrieve launch templates",
+			e.ClusterID)
+		return false
+	}
+
+	if len(templates) > 0 {
+		e.deleteLaunchTemplates(names)
+		return false
+	}
+
 	return len(instances) == 0
 }
 
+// GetResources - returns the compute resources requested by the cluster
+func (e *AwsEnvironment) GetResources() ClusterResources {
+	groups := append([]NodeGroup{e.masterGroup()}, e.workerGroups()...)
+	for idx := range groups {
+		groups[idx].DiskGB += e.dataVolumeGB()
+	}
+	return newClusterResources(groups...)
+}
+
 func (e *AwsEnvironment) getClusterNodes() ([]string, error) {
 	var instances []string
 
@@ -283,7 +518,7 @@ func (e *AwsEnvironment) getClusterNodes() ([]string, error) {
 				},
 				{
 					Name:   aws.String("network-interface.subnet-id"),
-					Values: aws.StringSlice([]string{e.SubnetID}),
+					Values: aws.StringSlice(e.discoverySubnets()),
 				},
 				{
 					Name: aws.String("instance-state-name"),
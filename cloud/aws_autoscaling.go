@@ -0,0 +1,323 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Spot allocation strategies of auto scaling groups
+const (
+	AutoScalingSpotLowestPrice       = "lowest-price"
+	AutoScalingSpotCapacityOptimized = "capacity-optimized"
+)
+
+// AutoScalingOptions describes the auto scaling groups which manage the
+// workers of an AWS cluster, one group per worker pool; InstanceTypes are
+// launched in addition to the instance type of each pool. Spot pools keep
+// OnDemandBaseCapacity nodes on-demand and launch the remainder on spot
+type AutoScalingOptions struct {
+	InstanceTypes          []string `json:",omitempty"`
+	OnDemandBaseCapacity   int64    `json:",omitempty"`
+	SpotAllocationStrategy string   `json:",omitempty"`
+}
+
+// ValidateAutoScalingOptions - verifies the spot allocation strategy
+// is supported
+func ValidateAutoScalingOptions(options *AutoScalingOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	if options.OnDemandBaseCapacity < 0 {
+		return errors.New("on-demand base capacity must not be negative")
+	}
+
+	switch options.SpotAllocationStrategy {
+	case "", AutoScalingSpotLowestPrice, AutoScalingSpotCapacityOptimized:
+		return nil
+	}
+
+	return errors.New("spot allocation strategy must be " + AutoScalingSpotLowestPrice +
+		" or " + AutoScalingSpotCapacityOptimized)
+}
+
+func (e *AwsEnvironment) getAutoScalingClient() *autoscaling.AutoScaling {
+	sess, config := e.getAwsSession()
+	return autoscaling.New(sess, config)
+}
+
+// workerGroupNames - returns the names of the auto scaling groups, and of
+// their launch templates, of the worker pools; pools without nodes are
+// included, as they may have been resized
+func (e *AwsEnvironment) workerGroupNames() []string {
+	var names []string
+	if e.AutoScaling == nil {
+		return names
+	}
+
+	for _, el := range e.workerPools() {
+		names = append(names, poolIdentifier(e.ClusterID, el.Name))
+	}
+	return names
+}
+
+// groupInstanceTypes - returns the instance types an auto scaling group
+// of the pool may launch, the pool's instance type first
+func (e *AwsEnvironment) groupInstanceTypes(pool AwsWorkerPool) []string {
+	instanceTypes := []string{pool.InstanceType}
+	for _, el := range e.AutoScaling.InstanceTypes {
+		if el != pool.InstanceType {
+			instanceTypes = append(instanceTypes, el)
+		}
+	}
+	return instanceTypes
+}
+
+// instancesDistribution - returns how the nodes of a pool are split
+// between on-demand and spot capacity
+func (e *AwsEnvironment) instancesDistribution(pool AwsWorkerPool) *autoscaling.InstancesDistribution {
+	distribution := &autoscaling.InstancesDistribution{
+		OnDemandPercentageAboveBaseCapacity: aws.Int64(100),
+	}
+
+	spot := e.spotOptions(pool)
+	if spot == nil {
+		return distribution
+	}
+
+	strategy := e.AutoScaling.SpotAllocationStrategy
+	if len(strategy) == 0 {
+		strategy = AutoScalingSpotCapacityOptimized
+	}
+
+	distribution.OnDemandBaseCapacity = aws.Int64(e.AutoScaling.OnDemandBaseCapacity)
+	distribution.OnDemandPercentageAboveBaseCapacity = aws.Int64(0)
+	distribution.SpotAllocationStrategy = aws.String(strategy)
+	if len(spot.MaxPrice) > 0 {
+		distribution.SpotMaxPrice = aws.String(spot.MaxPrice)
+	}
+	return distribution
+}
+
+// createWorkerGroup - creates the launch template, or the version of the
+// cluster's launch template, and auto scaling group of a worker pool; the
+// group spans the subnets workers may be launched in
+func (e *AwsEnvironment) createWorkerGroup(identifier string, group NodeGroup,
+	userData string, pool AwsWorkerPool) error {
+
+	input, err := e.runInstancesInput(identifier, group, userData, pool.Labels)
+	if err != nil {
+		return err
+	}
+
+	templateID, version, err := createNodeTemplate(e.getEc2Client(), identifier, input)
+	if err != nil {
+		return err
+	}
+
+	var overrides []*autoscaling.LaunchTemplateOverrides
+	for _, el := range e.groupInstanceTypes(pool) {
+		overrides = append(overrides, &autoscaling.LaunchTemplateOverrides{
+			InstanceType: aws.String(el),
+		})
+	}
+
+	_, err = e.getAutoScalingClient().CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(identifier),
+		MinSize:              aws.Int64(group.Nodes),
+		MaxSize:              aws.Int64(group.Nodes),
+		DesiredCapacity:      aws.Int64(group.Nodes),
+		VPCZoneIdentifier:    aws.String(strings.Join(e.workerSubnets(), ",")),
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+			InstancesDistribution: e.instancesDistribution(pool),
+			LaunchTemplate: &autoscaling.LaunchTemplate{
+				LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateId: templateID,
+					Version:          version,
+				},
+				Overrides: overrides,
+			},
+		},
+	})
+	if err != nil {
+		e.deleteLaunchTemplates([]string{identifier})
+		return err
+	}
+
+	logger.GetInfo().Printf("created auto scaling group %s with %v workers", identifier, group.Nodes)
+	return nil
+}
+
+// getWorkerGroups - returns the names of the auto scaling groups of the
+// cluster which have not been deleted
+func (e *AwsEnvironment) getWorkerGroups() ([]string, error) {
+	var groups []string
+	names := e.workerGroupNames()
+	if len(names) == 0 {
+		return groups, nil
+	}
+
+	resp, err := e.getAutoScalingClient().DescribeAutoScalingGroups(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice(names),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, el := range resp.AutoScalingGroups {
+		groups = append(groups, aws.StringValue(el.AutoScalingGroupName))
+	}
+	return groups, nil
+}
+
+// getLaunchTemplates - returns the IDs of the launch templates of the
+// worker groups which have not been deleted; with a launch template, the
+// numbers of its versions created for the groups are returned instead
+func (e *AwsEnvironment) getLaunchTemplates(names []string) ([]string, error) {
+	var templates []string
+	if len(names) == 0 {
+		return templates, nil
+	}
+
+	if e.LaunchTemplate != nil {
+		return e.getTemplateVersions(names)
+	}
+
+	resp, err := e.getEc2Client().DescribeLaunchTemplates(&ec2.DescribeLaunchTemplatesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("launch-template-name"),
+				Values: aws.StringSlice(names),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, el := range resp.LaunchTemplates {
+		templates = append(templates, aws.StringValue(el.LaunchTemplateId))
+	}
+	return templates, nil
+}
+
+func (e *AwsEnvironment) deleteLaunchTemplates(names []string) {
+	templates, err := e.getLaunchTemplates(names)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	cli := e.getEc2Client()
+	if e.LaunchTemplate != nil {
+		if len(templates) == 0 {
+			return
+		}
+		input := &ec2.DeleteLaunchTemplateVersionsInput{Versions: aws.StringSlice(templates)}
+		if len(e.LaunchTemplate.ID) > 0 {
+			input.LaunchTemplateId = aws.String(e.LaunchTemplate.ID)
+		} else {
+			input.LaunchTemplateName = aws.String(e.LaunchTemplate.Name)
+		}
+		resp, err := cli.DeleteLaunchTemplateVersions(input)
+		if err != nil {
+			logger.GetError().Println(err)
+			return
+		}
+		for _, el := range resp.UnsuccessfullyDeletedLaunchTemplateVersions {
+			logger.GetError().Printf("failed to delete version %v of launch template %s: %v",
+				aws.Int64Value(el.VersionNumber), aws.StringValue(el.LaunchTemplateId), el.ResponseError)
+		}
+		return
+	}
+
+	for _, el := range templates {
+		_, err := cli.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(el),
+		})
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+	}
+}
+
+// deleteWorkerGroups - requests the deletion of the auto scaling groups of
+// the cluster along with their instances; their launch templates are
+// deleted by DestructionConfirmed once the groups are gone
+func (e *AwsEnvironment) deleteWorkerGroups() error {
+	groups, err := e.getWorkerGroups()
+	if err != nil {
+		return err
+	}
+
+	cli := e.getAutoScalingClient()
+	for _, el := range groups {
+		logger.GetInfo().Printf("deleting auto scaling group %s of cluster %v", el, e.ClusterID)
+		_, err := cli.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(el),
+			ForceDelete:          aws.Bool(true),
+		})
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+	}
+	return nil
+}
+
+// resizeWorkerGroup - sets the capacity of the auto scaling group of a
+// pool; when shrinking, the most recently launched instances are
+// terminated and their private IPs returned
+func (e *AwsEnvironment) resizeWorkerGroup(pool string, nodes int64) ([]string, error) {
+	identifier := poolIdentifier(e.ClusterID, pool)
+	instances, err := e.describeNamedInstances(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := e.getAutoScalingClient()
+	current := int64(len(instances))
+	var removed []string
+	if nodes < current {
+		// lower the minimum first, so that the group accepts the terminations
+		_, err = cli.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(identifier),
+			MinSize:              aws.Int64(nodes),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, el := range newestInstances(instances, int(current-nodes)) {
+			logger.GetInfo().Printf("terminating instance %s of auto scaling group %s",
+				aws.StringValue(el.InstanceId), identifier)
+			_, err = cli.TerminateInstanceInAutoScalingGroup(
+				&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+					InstanceId:                     el.InstanceId,
+					ShouldDecrementDesiredCapacity: aws.Bool(true),
+				},
+			)
+			if err != nil {
+				return removed, err
+			}
+			if el.PrivateIpAddress != nil {
+				removed = append(removed, *el.PrivateIpAddress)
+			}
+		}
+	}
+
+	logger.GetInfo().Printf("setting capacity of auto scaling group %s to %v workers", identifier, nodes)
+	_, err = cli.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(identifier),
+		MinSize:              aws.Int64(nodes),
+		MaxSize:              aws.Int64(nodes),
+		DesiredCapacity:      aws.Int64(nodes),
+	})
+	return removed, err
+}
@@ -0,0 +1,335 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	defaultRootDeviceName = "/dev/xvda"
+	defaultVolumeType     = ec2.VolumeTypeGp2
+	volumeTypeGp3         = ec2.VolumeTypeGp3
+)
+
+// EBS volume types which accept provisioned IOPS
+var provisionedIopsVolumeTypes = map[string]bool{
+	volumeTypeGp3:     true,
+	ec2.VolumeTypeIo1: true,
+	ec2.VolumeTypeIo2: true,
+}
+
+// AwsLaunchTemplate identifies an EC2 launch template by ID or name;
+// an empty Version selects the default version of the template
+type AwsLaunchTemplate struct {
+	ID      string `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Version string `json:",omitempty"`
+}
+
+// AwsVolume describes an EBS volume of the cluster nodes; the size of the
+// root volume is EBSVolumeSize, and SizeGB applies to data volumes only.
+// Throughput (MiB/s) applies to gp3 volumes only
+type AwsVolume struct {
+	DeviceName string `json:",omitempty"`
+	VolumeType string `json:",omitempty"`
+	SizeGB     int64  `json:",omitempty"`
+	Iops       int64  `json:",omitempty"`
+	Throughput int64  `json:",omitempty"`
+}
+
+// ValidateLaunchOptions - verifies the launch template and volumes of
+// an AWS template
+func ValidateLaunchOptions(template *AwsLaunchTemplate, rootVolume *AwsVolume,
+	dataVolumes []AwsVolume) error {
+
+	if template != nil && (len(template.ID) > 0) == (len(template.Name) > 0) {
+		return errors.New("launch template requires either an ID or a name")
+	}
+
+	volumes := dataVolumes
+	if rootVolume != nil {
+		volumes = append([]AwsVolume{*rootVolume}, dataVolumes...)
+	}
+
+	devices := make(map[string]bool)
+	for idx, el := range volumes {
+		isRoot := rootVolume != nil && idx == 0
+		if !isRoot && (len(el.DeviceName) == 0 || el.SizeGB <= 0) {
+			return errors.New("data volumes require a device name and size")
+		}
+
+		if devices[el.DeviceName] {
+			return errors.New("device " + el.DeviceName + " is mapped more than once")
+		}
+		devices[el.DeviceName] = true
+
+		if el.Iops > 0 && !provisionedIopsVolumeTypes[el.VolumeType] {
+			return errors.New("IOPS require a gp3, io1 or io2 volume")
+		}
+
+		if el.Throughput > 0 && el.VolumeType != volumeTypeGp3 {
+			return errors.New("throughput requires a gp3 volume")
+		}
+	}
+
+	return nil
+}
+
+// ebsVolume - returns the EBS parameters of a volume, encrypted with the
+// configured KMS key or the default key
+func (e *AwsEnvironment) ebsVolume(volume AwsVolume, sizeGB int64) *ec2.EbsBlockDevice {
+	ebs := &ec2.EbsBlockDevice{
+		DeleteOnTermination: aws.Bool(true),
+		Encrypted:           aws.Bool(true),
+		VolumeSize:          aws.Int64(sizeGB),
+		VolumeType:          aws.String(defaultVolumeType),
+	}
+
+	if len(volume.VolumeType) > 0 {
+		ebs.VolumeType = aws.String(volume.VolumeType)
+	}
+	if volume.Iops > 0 {
+		ebs.Iops = aws.Int64(volume.Iops)
+	}
+	if volume.Throughput > 0 {
+		ebs.Throughput = aws.Int64(volume.Throughput)
+	}
+	if len(e.KmsKeyID) > 0 {
+		ebs.KmsKeyId = aws.String(e.KmsKeyID)
+	}
+	return ebs
+}
+
+// rootVolume - returns the root volume of the nodes
+func (e *AwsEnvironment) rootVolume() AwsVolume {
+	volume := AwsVolume{DeviceName: defaultRootDeviceName}
+	if e.RootVolume != nil {
+		volume = *e.RootVolume
+		if len(volume.DeviceName) == 0 {
+			volume.DeviceName = defaultRootDeviceName
+		}
+	}
+	return volume
+}
+
+// blockDeviceMappings - returns the root volume of the specified size
+// followed by the data volumes
+func (e *AwsEnvironment) blockDeviceMappings(rootSizeGB int64) []*ec2.BlockDeviceMapping {
+	root := e.rootVolume()
+	mappings := []*ec2.BlockDeviceMapping{
+		{
+			DeviceName: aws.String(root.DeviceName),
+			Ebs:        e.ebsVolume(root, rootSizeGB),
+		},
+	}
+
+	for _, el := range e.DataVolumes {
+		mappings = append(mappings, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(el.DeviceName),
+			Ebs:        e.ebsVolume(el, el.SizeGB),
+		})
+	}
+
+	return mappings
+}
+
+// launchTemplateDefinesVolumes - reports whether the launch template version
+// the nodes are launched from maps any block devices
+func (e *AwsEnvironment) launchTemplateDefinesVolumes() (bool, error) {
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: aws.StringSlice([]string{"$Default"}),
+	}
+	if len(e.LaunchTemplate.ID) > 0 {
+		input.LaunchTemplateId = aws.String(e.LaunchTemplate.ID)
+	} else {
+		input.LaunchTemplateName = aws.String(e.LaunchTemplate.Name)
+	}
+	if len(e.LaunchTemplate.Version) > 0 {
+		input.Versions = aws.StringSlice([]string{e.LaunchTemplate.Version})
+	}
+
+	resp, err := e.getEc2Client().DescribeLaunchTemplateVersions(input)
+	if err != nil {
+		return false, err
+	}
+
+	for _, el := range resp.LaunchTemplateVersions {
+		if el.LaunchTemplateData != nil && len(el.LaunchTemplateData.BlockDeviceMappings) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// createNodeTemplate - creates the launch template which auto scaling
+// groups and fleets launch nodes from, returning its ID and version; given
+// the launch template of the cluster, a version of it carrying the
+// parameters of the nodes is created instead, so that the nodes inherit
+// the rest of the template. The version is described by the identifier
+// of the nodes
+func createNodeTemplate(cli *ec2.EC2, identifier string,
+	input *ec2.RunInstancesInput) (*string, *string, error) {
+
+	if input.LaunchTemplate == nil {
+		resp, err := cli.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+			LaunchTemplateName: aws.String(identifier),
+			LaunchTemplateData: launchTemplateData(input),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return resp.LaunchTemplate.LaunchTemplateId, aws.String("$Latest"), nil
+	}
+
+	sourceVersion := input.LaunchTemplate.Version
+	if sourceVersion == nil {
+		sourceVersion = aws.String("$Default")
+	}
+
+	resp, err := cli.CreateLaunchTemplateVersion(&ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateId:   input.LaunchTemplate.LaunchTemplateId,
+		LaunchTemplateName: input.LaunchTemplate.LaunchTemplateName,
+		SourceVersion:      sourceVersion,
+		VersionDescription: aws.String(identifier),
+		LaunchTemplateData: launchTemplateData(input),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version := resp.LaunchTemplateVersion
+	return version.LaunchTemplateId,
+		aws.String(strconv.FormatInt(aws.Int64Value(version.VersionNumber), 10)), nil
+}
+
+// deleteNodeTemplate - deletes the launch template, or the version of the
+// cluster's launch template, created by createNodeTemplate
+func deleteNodeTemplate(cli *ec2.EC2, input *ec2.RunInstancesInput,
+	templateID *string, version *string) {
+
+	var err error
+	if input.LaunchTemplate == nil {
+		_, err = cli.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: templateID,
+		})
+	} else {
+		_, err = cli.DeleteLaunchTemplateVersions(&ec2.DeleteLaunchTemplateVersionsInput{
+			LaunchTemplateId: templateID,
+			Versions:         []*string{version},
+		})
+	}
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+}
+
+// getTemplateVersions - returns the numbers of the versions of the launch
+// template of the cluster created for the named nodes
+func (e *AwsEnvironment) getTemplateVersions(names []string) ([]string, error) {
+	input := &ec2.DescribeLaunchTemplateVersionsInput{}
+	if len(e.LaunchTemplate.ID) > 0 {
+		input.LaunchTemplateId = aws.String(e.LaunchTemplate.ID)
+	} else {
+		input.LaunchTemplateName = aws.String(e.LaunchTemplate.Name)
+	}
+
+	described := make(map[string]bool)
+	for _, el := range names {
+		described[el] = true
+	}
+
+	var versions []string
+	err := e.getEc2Client().DescribeLaunchTemplateVersionsPages(input,
+		func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+			for _, el := range page.LaunchTemplateVersions {
+				if described[aws.StringValue(el.VersionDescription)] {
+					versions = append(versions, strconv.FormatInt(aws.Int64Value(el.VersionNumber), 10))
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// dataVolumeGB - returns the total size of the data volumes of a node
+func (e *AwsEnvironment) dataVolumeGB() int64 {
+	var size int64
+	for _, el := range e.DataVolumes {
+		size += el.SizeGB
+	}
+	return size
+}
+
+// tagSpecifications - returns the tags of the instances and volumes of
+// the nodes; the Name tag identifies the nodes of the cluster and takes
+// precedence over the configured tags
+func (e *AwsEnvironment) tagSpecifications(identifier string,
+	labels map[string]string) []*ec2.TagSpecification {
+
+	newTags := func(extra map[string]string) []*ec2.Tag {
+		tags := []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(identifier)}}
+		for _, set := range []map[string]string{e.Tags, extra} {
+			for key, value := range set {
+				if key != "Name" {
+					tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+				}
+			}
+		}
+		return tags
+	}
+
+	return []*ec2.TagSpecification{
+		{
+			ResourceType: aws.String(ec2.ResourceTypeInstance),
+			Tags:         newTags(labels),
+		},
+		{
+			ResourceType: aws.String(ec2.ResourceTypeVolume),
+			Tags:         newTags(nil),
+		},
+	}
+}
+
+// applyLaunchOptions - applies the launch template, instance profile,
+// placement group and metadata options of the template to the input
+func (e *AwsEnvironment) applyLaunchOptions(input *ec2.RunInstancesInput) {
+	if e.LaunchTemplate != nil {
+		input.LaunchTemplate = &ec2.LaunchTemplateSpecification{}
+		if len(e.LaunchTemplate.ID) > 0 {
+			input.LaunchTemplate.LaunchTemplateId = aws.String(e.LaunchTemplate.ID)
+		} else {
+			input.LaunchTemplate.LaunchTemplateName = aws.String(e.LaunchTemplate.Name)
+		}
+		if len(e.LaunchTemplate.Version) > 0 {
+			input.LaunchTemplate.Version = aws.String(e.LaunchTemplate.Version)
+		}
+	}
+
+	if len(e.IAMInstanceProfileArn) > 0 {
+		input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{
+			Arn: aws.String(e.IAMInstanceProfileArn),
+		}
+	} else if len(e.IAMRole) > 0 {
+		input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{
+			Name: aws.String(e.IAMRole),
+		}
+	}
+
+	if len(e.PlacementGroup) > 0 {
+		input.Placement = &ec2.Placement{GroupName: aws.String(e.PlacementGroup)}
+	}
+
+	if e.RequireIMDSv2 {
+		input.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+			HttpEndpoint: aws.String(ec2.InstanceMetadataEndpointStateEnabled),
+			HttpTokens:   aws.String(ec2.HttpTokensStateRequired),
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"errors"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// workerPool - returns the worker pool of the cluster with the shapes of
+// the template applied
+func (e *AwsEnvironment) workerPool(name string) (AwsWorkerPool, error) {
+	for _, el := range e.workerPools() {
+		if el.Name == name {
+			return el, nil
+		}
+	}
+	return AwsWorkerPool{}, errors.New("worker pool " + name + " not found")
+}
+
+// SetPoolNodes - records the node count of a worker pool in the template
+func (e *AwsEnvironment) SetPoolNodes(name string, nodes int64) error {
+	if _, err := e.workerPool(name); err != nil {
+		return err
+	}
+
+	if len(e.WorkerPools) == 0 {
+		e.WorkerNodes = nodes
+		return nil
+	}
+
+	for idx := range e.WorkerPools {
+		if e.WorkerPools[idx].Name == name {
+			e.WorkerPools[idx].Nodes = nodes
+		}
+	}
+	return nil
+}
+
+// describeNamedInstances - returns the pending and running instances of
+// the cluster with the specified Name tag
+func (e *AwsEnvironment) describeNamedInstances(name string) ([]*ec2.Instance, error) {
+	resp, err := e.getEc2Client().DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:Name"),
+				Values: aws.StringSlice([]string{name}),
+			},
+			{
+				Name:   aws.String("network-interface.subnet-id"),
+				Values: aws.StringSlice(e.discoverySubnets()),
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{"pending", "running"}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*ec2.Instance
+	for _, reservation := range resp.Reservations {
+		instances = append(instances, reservation.Instances...)
+	}
+	return instances, nil
+}
+
+// getMasterIP - returns the private IP of the master workers report to
+func (e *AwsEnvironment) getMasterIP() (string, error) {
+	instances, err := e.describeNamedInstances(e.ClusterID + masterIdentifier)
+	if err != nil {
+		return "", err
+	}
+
+	if len(instances) == 0 || instances[0].PrivateIpAddress == nil {
+		return "", errors.New("master of cluster " + e.ClusterID + " not found")
+	}
+	return *instances[0].PrivateIpAddress, nil
+}
+
+// newestInstances - returns count of the instances, most recently
+// launched first
+func newestInstances(instances []*ec2.Instance, count int) []*ec2.Instance {
+	sorted := append([]*ec2.Instance{}, instances...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return aws.TimeValue(sorted[i].LaunchTime).After(aws.TimeValue(sorted[j].LaunchTime))
+	})
+
+	if count > len(sorted) {
+		count = len(sorted)
+	}
+	return sorted[:count]
+}
+
+// ResizePool - launches or terminates workers of a pool to reach the
+// specified number of nodes; the most recently launched workers are
+// terminated first. Pools of auto scaling groups are resized by setting
+// the capacity of their group
+func (e *AwsEnvironment) ResizePool(pool string, nodes int64) ([]string, error) {
+	workerPool, err := e.workerPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.AutoScaling != nil {
+		groups, err := e.getWorkerGroups()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, el := range groups {
+			if el == poolIdentifier(e.ClusterID, pool) {
+				removed, err := e.resizeWorkerGroup(pool, nodes)
+				if err != nil {
+					return removed, err
+				}
+				e.SetPoolNodes(pool, nodes)
+				return removed, nil
+			}
+		}
+		// pools launched without nodes have no group yet
+	}
+
+	instances, err := e.describeNamedInstances(poolIdentifier(e.ClusterID, pool))
+	if err != nil {
+		return nil, err
+	}
+
+	current := int64(len(instances))
+	var removed []string
+	switch {
+	case nodes > current:
+		masterIP, err := e.getMasterIP()
+		if err != nil {
+			return nil, err
+		}
+
+		logger.GetInfo().Printf("growing pool %v of cluster %v from %v to %v workers",
+			pool, e.ClusterID, current, nodes)
+		workerPool.Nodes = nodes - current
+		_, err = e.launchWorkers(masterIP, workerPool)
+		if err != nil {
+			return nil, err
+		}
+	case nodes < current:
+		var instanceIDs []string
+		for _, el := range newestInstances(instances, int(current-nodes)) {
+			instanceIDs = append(instanceIDs, aws.StringValue(el.InstanceId))
+			if el.PrivateIpAddress != nil {
+				removed = append(removed, *el.PrivateIpAddress)
+			}
+		}
+
+		logger.GetInfo().Printf("shrinking pool %v of cluster %v from %v to %v workers; terminating %v",
+			pool, e.ClusterID, current, nodes, instanceIDs)
+		_, err = e.getEc2Client().TerminateInstances(&ec2.TerminateInstancesInput{
+			InstanceIds: aws.StringSlice(instanceIDs),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.SetPoolNodes(pool, nodes)
+	return removed, nil
+}
@@ -0,0 +1,274 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Spot instance strategies
+const (
+	SpotStrategyOneTime           = "one-time"
+	SpotStrategyCapacityOptimized = "capacity-optimized"
+)
+
+// spotEnvParam - tells the check-in agent of spot nodes to watch instance
+// metadata for termination notices
+const spotEnvParam = "ALLSPARK_SPOT=true"
+
+// error codes returned when spot capacity is unavailable at launch
+var spotCapacityErrorCodes = map[string]bool{
+	"InsufficientInstanceCapacity": true,
+	"InsufficientCapacity":         true,
+	"SpotMaxPriceTooLow":           true,
+	"MaxSpotInstanceCountExceeded": true,
+	"UnfulfillableCapacity":        true,
+}
+
+// SpotOptions describes how the nodes of an AWS cluster are launched on
+// spot instances; workers of templates without worker pools and of Spot
+// pools run on spot, the master only if IncludeMaster is set. An empty
+// MaxPrice defaults to the on-demand price.
+type SpotOptions struct {
+	IncludeMaster      bool
+	MaxPrice           string `json:",omitempty"`
+	Strategy           string `json:",omitempty"`
+	FallbackToOnDemand bool
+}
+
+// ValidateSpotOptions - verifies the spot strategy is supported
+func ValidateSpotOptions(options *SpotOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	switch options.Strategy {
+	case "", SpotStrategyOneTime, SpotStrategyCapacityOptimized:
+		return nil
+	}
+
+	return errors.New("spot strategy must be " + SpotStrategyOneTime +
+		" or " + SpotStrategyCapacityOptimized)
+}
+
+func isSpotCapacityError(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && spotCapacityErrorCodes[awsErr.Code()]
+}
+
+// launchSpotInstances - launches the instances on spot capacity using the
+// configured strategy; instances for which no spot capacity is available
+// are launched on-demand if fallback is enabled
+func launchSpotInstances(cli *ec2.EC2, identifier string,
+	input *ec2.RunInstancesInput, options *SpotOptions) (*ec2.Reservation, error) {
+
+	count := aws.Int64Value(input.MaxCount)
+
+	var instances []*ec2.Instance
+	var err error
+	if options.Strategy == SpotStrategyCapacityOptimized {
+		instances, err = createSpotFleet(cli, identifier, input, options)
+	} else {
+		instances, err = runSpotInstances(cli, identifier, input, options)
+	}
+
+	if err != nil && !isSpotCapacityError(err) {
+		return nil, err
+	}
+
+	launched := int64(len(instances))
+	if launched >= count {
+		return &ec2.Reservation{Instances: instances}, nil
+	}
+
+	if !options.FallbackToOnDemand {
+		if err == nil {
+			err = fmt.Errorf("spot capacity available for %v of %v instances", launched, count)
+		}
+		return &ec2.Reservation{Instances: instances}, err
+	}
+
+	logger.GetInfo().Printf("spot capacity unavailable for %v of %v instances with identifier %s; "+
+		"launching on-demand", count-launched, count, identifier)
+
+	onDemand := *input
+	onDemand.MinCount = aws.Int64(count - launched)
+	onDemand.MaxCount = aws.Int64(count - launched)
+	resp, err := runInstances(cli, identifier, &onDemand)
+	if err != nil {
+		return &ec2.Reservation{Instances: instances}, err
+	}
+
+	return &ec2.Reservation{Instances: append(instances, resp.Instances...)}, nil
+}
+
+// runSpotInstances - launches one-time spot instances; as many instances
+// as spot capacity permits are launched
+func runSpotInstances(cli *ec2.EC2, identifier string,
+	input *ec2.RunInstancesInput, options *SpotOptions) ([]*ec2.Instance, error) {
+
+	spotOptions := &ec2.SpotMarketOptions{
+		SpotInstanceType:             aws.String(ec2.SpotInstanceTypeOneTime),
+		InstanceInterruptionBehavior: aws.String(ec2.InstanceInterruptionBehaviorTerminate),
+	}
+	if len(options.MaxPrice) > 0 {
+		spotOptions.MaxPrice = aws.String(options.MaxPrice)
+	}
+
+	spotInput := *input
+	spotInput.MinCount = aws.Int64(1)
+	spotInput.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{
+		MarketType:  aws.String(ec2.MarketTypeSpot),
+		SpotOptions: spotOptions,
+	}
+
+	resp, err := runInstances(cli, identifier, &spotInput)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Instances, nil
+}
+
+func launchTemplateData(input *ec2.RunInstancesInput) *ec2.RequestLaunchTemplateData {
+	data := &ec2.RequestLaunchTemplateData{
+		ImageId:          input.ImageId,
+		InstanceType:     input.InstanceType,
+		KeyName:          input.KeyName,
+		SecurityGroupIds: input.SecurityGroupIds,
+		UserData:         input.UserData,
+	}
+
+	if input.IamInstanceProfile != nil {
+		data.IamInstanceProfile = &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Arn:  input.IamInstanceProfile.Arn,
+			Name: input.IamInstanceProfile.Name,
+		}
+	}
+
+	if input.Placement != nil {
+		data.Placement = &ec2.LaunchTemplatePlacementRequest{
+			GroupName: input.Placement.GroupName,
+		}
+	}
+
+	if input.MetadataOptions != nil {
+		data.MetadataOptions = &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint: input.MetadataOptions.HttpEndpoint,
+			HttpTokens:   input.MetadataOptions.HttpTokens,
+		}
+	}
+
+	for _, el := range input.TagSpecifications {
+		data.TagSpecifications = append(data.TagSpecifications,
+			&ec2.LaunchTemplateTagSpecificationRequest{
+				ResourceType: el.ResourceType,
+				Tags:         el.Tags,
+			})
+	}
+
+	for _, el := range input.BlockDeviceMappings {
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings,
+			&ec2.LaunchTemplateBlockDeviceMappingRequest{
+				DeviceName: el.DeviceName,
+				Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+					DeleteOnTermination: el.Ebs.DeleteOnTermination,
+					Encrypted:           el.Ebs.Encrypted,
+					Iops:                el.Ebs.Iops,
+					KmsKeyId:            el.Ebs.KmsKeyId,
+					Throughput:          el.Ebs.Throughput,
+					VolumeSize:          el.Ebs.VolumeSize,
+					VolumeType:          el.Ebs.VolumeType,
+				},
+			})
+	}
+
+	return data
+}
+
+// createSpotFleet - launches spot instances from the pools with the most
+// available capacity using an instant fleet; the launch template, or the
+// version of the cluster's launch template, the fleet requires is removed
+// once the fleet has been created
+func createSpotFleet(cli *ec2.EC2, identifier string,
+	input *ec2.RunInstancesInput, options *SpotOptions) ([]*ec2.Instance, error) {
+
+	templateID, version, err := createNodeTemplate(cli, identifier, input)
+	if err != nil {
+		return nil, err
+	}
+	defer deleteNodeTemplate(cli, input, templateID, version)
+
+	overrides := &ec2.FleetLaunchTemplateOverridesRequest{
+		InstanceType: input.InstanceType,
+		SubnetId:     input.SubnetId,
+	}
+	if len(options.MaxPrice) > 0 {
+		overrides.MaxPrice = aws.String(options.MaxPrice)
+	}
+
+	resp, err := cli.CreateFleet(&ec2.CreateFleetInput{
+		Type: aws.String(ec2.FleetTypeInstant),
+		LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
+			{
+				LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+					LaunchTemplateId: templateID,
+					Version:          version,
+				},
+				Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{overrides},
+			},
+		},
+		SpotOptions: &ec2.SpotOptionsRequest{
+			AllocationStrategy:           aws.String(ec2.SpotAllocationStrategyCapacityOptimized),
+			InstanceInterruptionBehavior: aws.String(ec2.SpotInstanceInterruptionBehaviorTerminate),
+		},
+		TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
+			TotalTargetCapacity:       input.MaxCount,
+			DefaultTargetCapacityType: aws.String(ec2.DefaultTargetCapacityTypeSpot),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var instanceIDs []*string
+	for _, el := range resp.Instances {
+		instanceIDs = append(instanceIDs, el.InstanceIds...)
+	}
+
+	for _, el := range resp.Errors {
+		code := aws.StringValue(el.ErrorCode)
+		logger.GetError().Printf("spot fleet for %s reported %s: %s",
+			identifier, code, aws.StringValue(el.ErrorMessage))
+		if len(instanceIDs) == 0 && !spotCapacityErrorCodes[code] {
+			return nil, awserr.New(code, aws.StringValue(el.ErrorMessage), nil)
+		}
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil, awserr.New("InsufficientInstanceCapacity",
+			"spot fleet launched no instances", nil)
+	}
+
+	described, err := cli.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*ec2.Instance
+	for _, reservation := range described.Reservations {
+		for _, el := range reservation.Instances {
+			logger.GetInfo().Printf("launched spot instance %s, with identifier %s",
+				aws.StringValue(el.InstanceId), identifier)
+			instances = append(instances, el)
+		}
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,96 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// error codes returned when a subnet's availability zone cannot host
+// the requested instances
+var subnetCapacityErrorCodes = map[string]bool{
+	"InsufficientInstanceCapacity": true,
+	"Unsupported":                  true,
+}
+
+func isSubnetCapacityError(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && subnetCapacityErrorCodes[awsErr.Code()]
+}
+
+// candidateSubnets - returns the subnets the master may be launched in,
+// in order of preference
+func (e *AwsEnvironment) candidateSubnets() []string {
+	var subnets []string
+	seen := make(map[string]bool)
+	for _, el := range append([]string{e.SubnetID}, e.SubnetIDs...) {
+		if len(el) > 0 && !seen[el] {
+			seen[el] = true
+			subnets = append(subnets, el)
+		}
+	}
+	return subnets
+}
+
+// workerSubnets - returns the subnets the workers may be launched in;
+// workers are launched in the subnet of the master unless cross-zone
+// workers are allowed, in which case the other subnets are tried next
+func (e *AwsEnvironment) workerSubnets() []string {
+	if len(e.LaunchSubnetID) == 0 {
+		return e.candidateSubnets()
+	}
+
+	subnets := []string{e.LaunchSubnetID}
+	if !e.AllowCrossZoneWorkers {
+		return subnets
+	}
+
+	for _, el := range e.candidateSubnets() {
+		if el != e.LaunchSubnetID {
+			subnets = append(subnets, el)
+		}
+	}
+	return subnets
+}
+
+// discoverySubnets - returns the subnets the nodes of the cluster may
+// have been launched in
+func (e *AwsEnvironment) discoverySubnets() []string {
+	if len(e.LaunchSubnetID) > 0 && !e.AllowCrossZoneWorkers {
+		return []string{e.LaunchSubnetID}
+	}
+	return e.candidateSubnets()
+}
+
+// launchInSubnets - launches the instances in the first of the subnets
+// with capacity for them; returns the subnet the instances were launched in
+func launchInSubnets(identifier string, subnets []string, input *ec2.RunInstancesInput,
+	launch func(*ec2.RunInstancesInput) (*ec2.Reservation, error)) (*ec2.Reservation, string, error) {
+
+	if len(subnets) == 0 {
+		return nil, "", errors.New("no subnet specified for " + identifier)
+	}
+
+	var res *ec2.Reservation
+	var err error
+	for idx, el := range subnets {
+		input.SubnetId = aws.String(el)
+		res, err = launch(input)
+
+		// instances launched before capacity ran out are kept in their subnet
+		if err == nil || !isSubnetCapacityError(err) ||
+			(res != nil && len(res.Instances) > 0) {
+			return res, el, err
+		}
+
+		if idx < len(subnets)-1 {
+			logger.GetInfo().Printf("insufficient capacity for %s in subnet %s; trying subnet %s",
+				identifier, el, subnets[idx+1])
+		}
+	}
+
+	return res, "", err
+}
@@ -2,10 +2,19 @@ package cloud
 
 import (
 	"allspark/util/serializer"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
 	"strconv"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 const (
@@ -195,3 +204,299 @@ func TestDestroyAndDestroySingleNodeAwsCluster(t *testing.T) {
 		t.Error("- got " + strconv.Itoa(int(actualNodeCount)) + " spark nodes.")
 	}
 }
+
+func TestAwsNodeGroups(t *testing.T) {
+	var spec AwsEnvironment
+	err := serializer.DeserializePath(awsClusterTemplatePath, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := spec.masterGroup()
+	if master.InstanceType != spec.InstanceType || master.DiskGB != spec.EBSVolumeSize ||
+		master.Nodes != 1 {
+		t.Errorf("expected master to default to the worker shape: %+v", master)
+	}
+
+	spec.MasterInstanceType = "m5.large"
+	spec.MasterEBSVolumeSize = 50
+	master = spec.masterGroup()
+	if master.InstanceType != "m5.large" || master.DiskGB != 50 {
+		t.Errorf("expected master shape to be overridden: %+v", master)
+	}
+
+	resources := spec.GetResources()
+	if len(resources.Groups) != 2 || resources.Nodes != spec.WorkerNodes+1 ||
+		resources.Groups[1].InstanceType != spec.InstanceType {
+		t.Errorf("unexpected resources: %+v", resources)
+	}
+}
+
+func TestAwsPoolNodes(t *testing.T) {
+	spec := AwsEnvironment{ClusterID: "c1", InstanceType: "m5.large", WorkerNodes: 2}
+	if err := spec.SetPoolNodes(DefaultWorkerPool, 5); err != nil || spec.WorkerNodes != 5 {
+		t.Errorf("expected the default pool to resize WorkerNodes: %v, %v", spec.WorkerNodes, err)
+	}
+
+	spec.WorkerPools = []AwsWorkerPool{
+		{WorkerPool: WorkerPool{Name: "spot", Nodes: 4}, Spot: true},
+		{WorkerPool: WorkerPool{Name: "highmem", Nodes: 1}, InstanceType: "r5.xlarge"},
+	}
+
+	if err := spec.SetPoolNodes("highmem", 3); err != nil ||
+		spec.WorkerPools[1].Nodes != 3 || spec.WorkerPools[0].Nodes != 4 {
+		t.Errorf("unexpected pools after resize: %+v, %v", spec.WorkerPools, err)
+	}
+
+	if spec.SetPoolNodes("gpu", 1) == nil {
+		t.Error("expected unknown pools to be rejected")
+	}
+
+	now := time.Now()
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), LaunchTime: aws.Time(now.Add(-time.Hour))},
+		{InstanceId: aws.String("i-2"), LaunchTime: aws.Time(now)},
+		{InstanceId: aws.String("i-3"), LaunchTime: aws.Time(now.Add(-time.Minute))},
+	}
+
+	newest := newestInstances(instances, 2)
+	if len(newest) != 2 || *newest[0].InstanceId != "i-2" || *newest[1].InstanceId != "i-3" {
+		t.Errorf("expected the most recently launched instances: %v", newest)
+	}
+}
+
+func TestAwsSubnets(t *testing.T) {
+	spec := AwsEnvironment{SubnetID: "subnet-a", SubnetIDs: []string{"subnet-b", "subnet-a", "subnet-c"}}
+	if subnets := spec.candidateSubnets(); len(subnets) != 3 || subnets[0] != "subnet-a" ||
+		subnets[2] != "subnet-c" {
+		t.Errorf("unexpected candidate subnets: %v", subnets)
+	}
+
+	spec.LaunchSubnetID = "subnet-b"
+	if subnets := spec.workerSubnets(); len(subnets) != 1 || subnets[0] != "subnet-b" {
+		t.Errorf("expected workers in the master subnet: %v", subnets)
+	}
+
+	spec.AllowCrossZoneWorkers = true
+	if subnets := spec.workerSubnets(); len(subnets) != 3 || subnets[0] != "subnet-b" {
+		t.Errorf("unexpected cross-zone worker subnets: %v", subnets)
+	}
+
+	var attempts []string
+	launch := func(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+		attempts = append(attempts, aws.StringValue(input.SubnetId))
+		if len(attempts) < 2 {
+			return nil, awserr.New("InsufficientInstanceCapacity", "", nil)
+		}
+		return &ec2.Reservation{Instances: []*ec2.Instance{{}}}, nil
+	}
+
+	_, subnet, err := launchInSubnets("test", spec.candidateSubnets(), &ec2.RunInstancesInput{}, launch)
+	if err != nil || subnet != "subnet-b" || len(attempts) != 2 {
+		t.Errorf("expected launch to fail over to subnet-b: %v, %v, %v", subnet, attempts, err)
+	}
+
+	attempts = nil
+	_, _, err = launchInSubnets("test", []string{"subnet-a"}, &ec2.RunInstancesInput{}, launch)
+	if err == nil {
+		t.Error("expected launch to fail without further subnets")
+	}
+}
+
+func TestTemplateHashIgnoresLaunchSubnet(t *testing.T) {
+	template, err := ReadTemplateConfiguration(awsClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec AwsEnvironment
+	err = json.Unmarshal(template, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec.LaunchSubnetID = spec.SubnetID
+	launched, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec.LaunchSubnetID = ""
+	unlaunched, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := TemplateHash(launched)
+	second, _ := TemplateHash(unlaunched)
+	if first != second {
+		t.Error("expected template hash to be independent of the launch subnet")
+	}
+}
+
+func TestAwsLaunchOptions(t *testing.T) {
+	spec := AwsEnvironment{
+		KmsKeyID:    "alias/allspark",
+		RootVolume:  &AwsVolume{VolumeType: "gp3", Iops: 4000, Throughput: 250},
+		DataVolumes: []AwsVolume{{DeviceName: "/dev/sdf", SizeGB: 500, VolumeType: "st1"}},
+		Tags:        map[string]string{"CostCenter": "1234", "Name": "ignored"},
+	}
+
+	err := ValidateLaunchOptions(&AwsLaunchTemplate{ID: "lt-0123"}, spec.RootVolume, spec.DataVolumes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	invalid := []error{
+		ValidateLaunchOptions(&AwsLaunchTemplate{}, nil, nil),
+		ValidateLaunchOptions(nil, &AwsVolume{Throughput: 250}, nil),
+		ValidateLaunchOptions(nil, nil, []AwsVolume{{DeviceName: "/dev/sdf"}}),
+		ValidateLaunchOptions(nil, nil, []AwsVolume{{DeviceName: "/dev/sdf", SizeGB: 1, Iops: 100}}),
+	}
+	for idx, el := range invalid {
+		if el == nil {
+			t.Errorf("expected validation %v to fail", idx)
+		}
+	}
+
+	mappings := spec.blockDeviceMappings(100)
+	if len(mappings) != 2 || aws.StringValue(mappings[0].DeviceName) != defaultRootDeviceName ||
+		aws.Int64Value(mappings[0].Ebs.VolumeSize) != 100 ||
+		aws.StringValue(mappings[1].Ebs.KmsKeyId) != "alias/allspark" {
+		t.Errorf("unexpected block device mappings: %v", mappings)
+	}
+
+	tags := spec.tagSpecifications("c1-master", map[string]string{"tier": "batch"})
+	if len(tags) != 2 || len(tags[0].Tags) != 3 || len(tags[1].Tags) != 2 ||
+		aws.StringValue(tags[0].Tags[0].Value) != "c1-master" {
+		t.Errorf("unexpected tag specifications: %v", tags)
+	}
+
+	if spec.GetResources().Groups[0].DiskGB != spec.EBSVolumeSize+500 {
+		t.Error("expected data volumes to be included in the disk size")
+	}
+
+	if aws.Int64Value(mappings[0].Ebs.Throughput) != 250 || mappings[1].Ebs.Throughput != nil {
+		t.Errorf("unexpected volume throughput: %v", mappings)
+	}
+
+	data := launchTemplateData(&ec2.RunInstancesInput{BlockDeviceMappings: mappings})
+	if aws.Int64Value(data.BlockDeviceMappings[0].Ebs.Throughput) != 250 {
+		t.Errorf("unexpected launch template volumes: %v", data.BlockDeviceMappings)
+	}
+}
+
+func TestAutoScalingOptions(t *testing.T) {
+	spec := AwsEnvironment{
+		ClusterID:    "c1",
+		InstanceType: "r5.xlarge",
+		WorkerPools: []AwsWorkerPool{
+			{WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: 2}, InstanceType: "r5.xlarge"},
+			{WorkerPool: WorkerPool{Name: "spot", Nodes: 4}, InstanceType: "r5.2xlarge", Spot: true},
+			{WorkerPool: WorkerPool{Name: "empty"}, InstanceType: "r5.large"},
+		},
+	}
+
+	if names := spec.workerGroupNames(); len(names) != 0 {
+		t.Errorf("expected no worker groups without auto scaling: %v", names)
+	}
+
+	spec.AutoScaling = &AutoScalingOptions{
+		InstanceTypes:        []string{"r5.2xlarge", "r5a.2xlarge"},
+		OnDemandBaseCapacity: 1,
+	}
+	if names := spec.workerGroupNames(); len(names) != 3 || names[0] != "c1-worker" ||
+		names[1] != "c1-spot-worker" || names[2] != "c1-empty-worker" {
+		t.Errorf("unexpected worker groups: %v", names)
+	}
+
+	if types := spec.groupInstanceTypes(spec.WorkerPools[1]); len(types) != 2 ||
+		types[0] != "r5.2xlarge" || types[1] != "r5a.2xlarge" {
+		t.Errorf("unexpected instance types: %v", types)
+	}
+
+	onDemand := spec.instancesDistribution(spec.WorkerPools[0])
+	if aws.Int64Value(onDemand.OnDemandPercentageAboveBaseCapacity) != 100 ||
+		onDemand.SpotAllocationStrategy != nil {
+		t.Errorf("expected an on-demand distribution: %v", onDemand)
+	}
+
+	spot := spec.instancesDistribution(spec.WorkerPools[1])
+	if aws.Int64Value(spot.OnDemandPercentageAboveBaseCapacity) != 0 ||
+		aws.Int64Value(spot.OnDemandBaseCapacity) != 1 ||
+		aws.StringValue(spot.SpotAllocationStrategy) != AutoScalingSpotCapacityOptimized {
+		t.Errorf("expected a spot distribution: %v", spot)
+	}
+
+	if ValidateAutoScalingOptions(spec.AutoScaling) != nil || ValidateAutoScalingOptions(nil) != nil {
+		t.Error("expected auto scaling options to be valid")
+	}
+	if ValidateAutoScalingOptions(&AutoScalingOptions{SpotAllocationStrategy: "cheapest"}) == nil ||
+		ValidateAutoScalingOptions(&AutoScalingOptions{OnDemandBaseCapacity: -1}) == nil {
+		t.Error("expected invalid auto scaling options to fail validation")
+	}
+}
+
+func TestAwsNodeTemplate(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		action := r.PostForm.Get("Action")
+		actions = append(actions, action)
+
+		switch action {
+		case "CreateLaunchTemplate":
+			w.Write([]byte("<CreateLaunchTemplateResponse><launchTemplate>" +
+				"<launchTemplateId>lt-cluster</launchTemplateId></launchTemplate></CreateLaunchTemplateResponse>"))
+		case "CreateLaunchTemplateVersion":
+			if r.PostForm.Get("LaunchTemplateId") != "lt-0123" || r.PostForm.Get("SourceVersion") != "3" ||
+				r.PostForm.Get("VersionDescription") != "c1-worker" ||
+				len(r.PostForm.Get("LaunchTemplateData.UserData")) == 0 {
+				t.Errorf("unexpected launch template version: %v", r.PostForm)
+			}
+			w.Write([]byte("<CreateLaunchTemplateVersionResponse><launchTemplateVersion>" +
+				"<launchTemplateId>lt-0123</launchTemplateId><versionNumber>7</versionNumber>" +
+				"</launchTemplateVersion></CreateLaunchTemplateVersionResponse>"))
+		case "DeleteLaunchTemplateVersions":
+			if r.PostForm.Get("LaunchTemplateId") != "lt-0123" ||
+				r.PostForm.Get("LaunchTemplateVersion.1") != "7" {
+				t.Errorf("unexpected launch template version deletion: %v", r.PostForm)
+			}
+			w.Write([]byte("<DeleteLaunchTemplateVersionsResponse></DeleteLaunchTemplateVersionsResponse>"))
+		default:
+			t.Errorf("unexpected action %v", action)
+		}
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	cli := ec2.New(sess)
+
+	spec := AwsEnvironment{LaunchTemplate: &AwsLaunchTemplate{ID: "lt-0123", Version: "3"}}
+	input := &ec2.RunInstancesInput{UserData: aws.String("TUFTVEVSX0lQPTEwLjAuMC40")}
+	spec.applyLaunchOptions(input)
+
+	templateID, version, err := createNodeTemplate(cli, "c1-worker", input)
+	if err != nil || aws.StringValue(templateID) != "lt-0123" || aws.StringValue(version) != "7" {
+		t.Errorf("expected a version of the launch template: %v %v %v",
+			aws.StringValue(templateID), aws.StringValue(version), err)
+	}
+	deleteNodeTemplate(cli, input, templateID, version)
+
+	input.LaunchTemplate = nil
+	templateID, version, err = createNodeTemplate(cli, "c1-worker", input)
+	if err != nil || aws.StringValue(templateID) != "lt-cluster" || aws.StringValue(version) != "$Latest" {
+		t.Errorf("expected a launch template of the nodes: %v %v %v",
+			aws.StringValue(templateID), aws.StringValue(version), err)
+	}
+
+	if len(actions) != 3 {
+		t.Errorf("unexpected requests: %v", actions)
+	}
+}
@@ -8,80 +8,217 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/2019-03-01/storage/mgmt/storage"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/Azure/go-autorest/autorest/to"
 )
 
-// AzureEnvironment interface
+// AzureEnvironment interface; VMSize and DiskSizeGB describe the worker
+// nodes and, unless overridden, the master node and worker pools. Nodes
+// boot from ImageID or GalleryImage if set, or else from the VHD blob.
+// ConfigTags opts nodes which cannot receive custom data into reading
+// their configuration from VM tags
 type AzureEnvironment struct {
-	ClusterID           string
-	SubscriptionID      string
-	Region              string
-	ClientID            string
-	ClientSecret        string
-	Tenant              string
-	ResourceGroup       string
-	VMNet               string
-	VMSubnet            string
-	VMSize              compute.VirtualMachineSizeTypes
-	DiskSizeGB          int32
-	ImageStorageAccount string
-	DataStorageAccount  string
-	ImageContainer      string
-	ImageBlob           string
-	WorkerNodes         int64
-	EnvParams           []string
+	ClusterID              string
+	SubscriptionID         string
+	Region                 string
+	ClientID               string
+	ClientSecret           string
+	Tenant                 string
+	ResourceGroup          string
+	VMNet                  string
+	VMSubnet               string
+	VMSize                 compute.VirtualMachineSizeTypes
+	DiskSizeGB             int32
+	MasterVMSize           compute.VirtualMachineSizeTypes `json:",omitempty"`
+	MasterDiskSizeGB       int32                           `json:",omitempty"`
+	ImageStorageAccount    string
+	DataStorageAccount     string
+	ImageContainer         string
+	ImageBlob              string
+	WorkerNodes            int64
+	EnvParams              []string
+	RetryPolicy            *RetryPolicy
+	WorkerPools            []AzureWorkerPool  `json:",omitempty"`
+	ScaleSet               *AzureScaleSet     `json:",omitempty"`
+	ParallelLaunches       int                `json:",omitempty"`
+	TolerateWorkerFailures bool               `json:",omitempty"`
+	ImageID                string             `json:",omitempty"`
+	GalleryImage           *AzureGalleryImage `json:",omitempty"`
+	AdminUsername          string             `json:",omitempty"`
+	SSHPublicKey           string             `json:",omitempty"`
+	ManagedIdentities      []string           `json:",omitempty"`
+	StorageIdentity        string             `json:",omitempty"`
+	ConfigTags             bool               `json:",omitempty"`
+
+	image *azureImage
+}
+
+// number of virtual machines provisioned at a time unless overridden
+// by ParallelLaunches
+const defaultParallelLaunches = 8
+
+// AzureWorkerPool - a worker pool of an Azure cluster
+type AzureWorkerPool struct {
+	WorkerPool
+	VMSize     compute.VirtualMachineSizeTypes `json:",omitempty"`
+	DiskSizeGB int32                           `json:",omitempty"`
+}
+
+// azureNodeShape describes the size of a virtual machine
+type azureNodeShape struct {
+	VMSize     compute.VirtualMachineSizeTypes
+	DiskSizeGB int32
+}
+
+// azureLaunch describes a virtual machine, or the scale set of a worker
+// pool, to be provisioned
+type azureLaunch struct {
+	name   string
+	shape  azureNodeShape
+	tags   map[string]*string
+	config []string
+	nodes  int64
+	pool   AzureWorkerPool
+}
+
+func (e *AzureEnvironment) masterShape() azureNodeShape {
+	shape := e.workerShape()
+	if len(e.MasterVMSize) > 0 {
+		shape.VMSize = e.MasterVMSize
+	}
+	if e.MasterDiskSizeGB > 0 {
+		shape.DiskSizeGB = e.MasterDiskSizeGB
+	}
+	return shape
+}
+
+func (e *AzureEnvironment) workerShape() azureNodeShape {
+	return azureNodeShape{VMSize: e.VMSize, DiskSizeGB: e.DiskSizeGB}
+}
+
+// workerPools - returns the worker pools of the cluster with the shapes
+// of the template applied; templates without pools have a default pool
+func (e *AzureEnvironment) workerPools() []AzureWorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []AzureWorkerPool{{
+			WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: e.WorkerNodes},
+			VMSize:     e.VMSize,
+			DiskSizeGB: e.DiskSizeGB,
+		}}
+	}
+
+	pools := make([]AzureWorkerPool, len(e.WorkerPools))
+	for idx, el := range e.WorkerPools {
+		if len(el.VMSize) == 0 {
+			el.VMSize = e.VMSize
+		}
+		if el.DiskSizeGB == 0 {
+			el.DiskSizeGB = e.DiskSizeGB
+		}
+		pools[idx] = el
+	}
+	return pools
+}
+
+// workerCount - returns the number of worker nodes across all pools
+func (e *AzureEnvironment) workerCount() int64 {
+	var count int64
+	for _, el := range e.workerPools() {
+		count += el.Nodes
+	}
+	return count
+}
+
+// resourceManagerURI - the Azure Resource Manager endpoint clusters are
+// managed through
+var resourceManagerURI = compute.DefaultBaseURI
+
+// servicePrincipalAuthorizer - returns the credentials of a service principal
+var servicePrincipalAuthorizer = func(clientID string, secret string,
+	tenant string) (autorest.Authorizer, error) {
+	return auth.NewClientCredentialsConfig(clientID, secret, tenant).Authorizer()
+}
+
+// getAuthorizer - returns the credentials of the service principal of the
+// template; the client secret may be a Key Vault reference
+func (e *AzureEnvironment) getAuthorizer() (autorest.Authorizer, error) {
+	secret, err := resolveSecret(e.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	return servicePrincipalAuthorizer(e.ClientID, secret, e.Tenant)
 }
 
 func (e *AzureEnvironment) getStorageClient() (storage.AccountsClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := storage.NewAccountsClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := storage.NewAccountsClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
 
 func (e *AzureEnvironment) getNicClient() (network.InterfacesClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := network.NewInterfacesClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := network.NewInterfacesClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
 
 func (e *AzureEnvironment) getPublicIPClient() (network.PublicIPAddressesClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := network.NewPublicIPAddressesClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := network.NewPublicIPAddressesClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
 
 func (e *AzureEnvironment) getVMClient() (compute.VirtualMachinesClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := compute.NewVirtualMachinesClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := compute.NewVirtualMachinesClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
 
+// describeMemoryGB - returns the memory of a VM size in the region
+func (e *AzureEnvironment) describeMemoryGB(vmSize string) (float64, error) {
+	client := compute.NewVirtualMachineSizesClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
+	if err != nil {
+		return 0, err
+	}
+	client.Authorizer = authorizer
+
+	result, err := client.List(context.Background(), e.Region)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.Value != nil {
+		for _, el := range *result.Value {
+			if el.Name != nil && strings.EqualFold(*el.Name, vmSize) && el.MemoryInMB != nil {
+				return float64(*el.MemoryInMB) / 1024, nil
+			}
+		}
+	}
+
+	return 0, errors.New("VM size " + vmSize + " not found in " + e.Region)
+}
+
 func (e *AzureEnvironment) getSubnetClient() (network.SubnetsClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := network.NewSubnetsClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := network.NewSubnetsClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
 
 func (e *AzureEnvironment) getDiskClient() (compute.DisksClient, error) {
-	authConfig := auth.NewClientCredentialsConfig(e.ClientID, e.ClientSecret, e.Tenant)
-	client := compute.NewDisksClient(e.SubscriptionID)
-	authorizer, err := authConfig.Authorizer()
+	client := compute.NewDisksClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
 	client.Authorizer = authorizer
 	return client, err
 }
@@ -114,15 +251,18 @@ func (e *AzureEnvironment) getPrimaryStorageKey() (string, error) {
 	return *primaryKey, nil
 }
 
-func (e *AzureEnvironment) createNIC(name string) (string, error) {
+// createNIC - creates the network interface of a VM and returns its path
+// and private IP once provisioned
+func (e *AzureEnvironment) createNIC(name string) (string, string, error) {
 	cli, err := e.getNicClient()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
+	ctx := context.Background()
 
-	subnet, err := e.getSubnet(context.Background(), e.VMNet, e.VMSubnet)
+	subnet, err := e.getSubnet(ctx, e.VMNet, e.VMSubnet)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	nicPath := "/subscriptions/" + e.SubscriptionID +
@@ -145,8 +285,27 @@ func (e *AzureEnvironment) createNIC(name string) (string, error) {
 		},
 	}
 
-	_, err = cli.CreateOrUpdate(context.Background(), e.ResourceGroup, name, nicParams)
-	return nicPath, err
+	future, err := cli.CreateOrUpdate(ctx, e.ResourceGroup, name, nicParams)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = future.WaitForCompletionRef(ctx, cli.Client)
+	if err != nil {
+		return "", "", err
+	}
+
+	nic, err := future.Result(cli)
+	if err != nil {
+		return "", "", err
+	}
+
+	if nic.IPConfigurations == nil || len(*nic.IPConfigurations) == 0 ||
+		(*nic.IPConfigurations)[0].PrivateIPAddress == nil {
+		return "", "", errors.New("private IP not found for VM " + name)
+	}
+
+	return nicPath, *(*nic.IPConfigurations)[0].PrivateIPAddress, nil
 }
 
 func (e *AzureEnvironment) deleteNIC(name string) error {
@@ -179,7 +338,7 @@ func (e *AzureEnvironment) listNICs() ([]string, error) {
 	return items, err
 }
 
-func (e *AzureEnvironment) createDisk(name string) (string, error) {
+func (e *AzureEnvironment) createDisk(name string, diskSizeGB int32) (string, error) {
 	cli, err := e.getDiskClient()
 	if err != nil {
 		return "", err
@@ -198,7 +357,7 @@ func (e *AzureEnvironment) createDisk(name string) (string, error) {
 		Location: to.StringPtr(e.Region),
 		Name:     to.StringPtr(name),
 		DiskProperties: &compute.DiskProperties{
-			DiskSizeGB: to.Int32Ptr(e.DiskSizeGB + 1),
+			DiskSizeGB: to.Int32Ptr(diskSizeGB + 1),
 			CreationData: &compute.CreationData{
 				CreateOption:     compute.Import,
 				SourceURI:        to.StringPtr(e.getImageURI()),
@@ -207,7 +366,13 @@ func (e *AzureEnvironment) createDisk(name string) (string, error) {
 		},
 	}
 
-	_, err = cli.CreateOrUpdate(context.Background(), e.ResourceGroup, name, disk)
+	ctx := context.Background()
+	future, err := cli.CreateOrUpdate(ctx, e.ResourceGroup, name, disk)
+	if err != nil {
+		return "", err
+	}
+
+	err = future.WaitForCompletionRef(ctx, cli.Client)
 	return diskPath, err
 }
 
@@ -242,68 +407,30 @@ func (e *AzureEnvironment) listDisks() (*list.List, error) {
 	return items, err
 }
 
-func (e *AzureEnvironment) getPrivateIP(name string) (string, error) {
-	cli, err := e.getNicClient()
-	if err != nil {
-		return "", err
-	}
-
-	nics, err := cli.List(context.Background(), e.ResourceGroup)
-	if err != nil {
-		return "", err
-	}
-
-	for _, el := range nics.Values() {
-		if name == *el.Name {
-			return *(*el.IPConfigurations)[0].PrivateIPAddress, nil
-		}
-	}
-
-	return "", errors.New("private IP not found for VM " + name)
-}
-
-func (e *AzureEnvironment) createVM(name string, tags map[string]*string,
-	waitForCompletion bool) (string, error) {
+// createVM - creates a VM attached to the network interface and waits
+// for it to be provisioned; config is the NAME=VALUE environment of the node
+func (e *AzureEnvironment) createVM(name string, shape azureNodeShape,
+	tags map[string]*string, config []string, nic string) error {
 
 	cli, err := e.getVMClient()
 
 	if err != nil {
-		return "", err
+		return err
 	}
 	ctx := context.Background()
 
-	nic, err := e.createNIC(name)
-	if err != nil {
-		return "", err
-	}
-
-	privateIP, err := e.getPrivateIP(name)
+	tags, config, err = e.nodeConfig(tags, config)
 	if err != nil {
-		return "", err
-	}
-
-	disk, err := e.createDisk(name)
-	if err != nil {
-		return "", err
+		return err
 	}
 
 	vmParameters := compute.VirtualMachine{
 		Location: to.StringPtr(e.Region),
 		Tags:     tags,
+		Identity: e.vmIdentity(),
 		VirtualMachineProperties: &compute.VirtualMachineProperties{
 			HardwareProfile: &compute.HardwareProfile{
-				VMSize: e.VMSize,
-			},
-			StorageProfile: &compute.StorageProfile{
-				OsDisk: &compute.OSDisk{
-					Name:         to.StringPtr(name),
-					CreateOption: compute.DiskCreateOptionTypesAttach,
-					OsType:       compute.Linux,
-					ManagedDisk: &compute.ManagedDiskParameters{
-						StorageAccountType: compute.StorageAccountTypesStandardLRS,
-						ID:                 to.StringPtr(disk),
-					},
-				},
+				VMSize: shape.VMSize,
 			},
 			NetworkProfile: &compute.NetworkProfile{
 				NetworkInterfaces: &[]compute.NetworkInterfaceReference{
@@ -318,124 +445,377 @@ func (e *AzureEnvironment) createVM(name string, tags map[string]*string,
 		},
 	}
 
+	if e.image != nil {
+		vmParameters.StorageProfile = imageStorageProfile(name, e.image, shape.DiskSizeGB)
+		if e.image.Generalized {
+			vmParameters.OsProfile = e.osProfile(name, config)
+		}
+	} else {
+		disk, err := e.createDisk(name, shape.DiskSizeGB)
+		if err != nil {
+			return err
+		}
+
+		vmParameters.StorageProfile = &compute.StorageProfile{
+			OsDisk: &compute.OSDisk{
+				Name:         to.StringPtr(name),
+				CreateOption: compute.DiskCreateOptionTypesAttach,
+				OsType:       compute.Linux,
+				ManagedDisk: &compute.ManagedDiskParameters{
+					StorageAccountType: compute.StorageAccountTypesStandardLRS,
+					ID:                 to.StringPtr(disk),
+				},
+			},
+		}
+	}
+
 	future, err := cli.CreateOrUpdate(ctx, e.ResourceGroup, name, vmParameters)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if waitForCompletion {
-		err = future.WaitForCompletionRef(ctx, cli.Client)
-		if err != nil {
-			return "", err
-		}
+	return future.WaitForCompletionRef(ctx, cli.Client)
+}
+
+// launchVM - creates the network interface, disk and VM of a node
+func (e *AzureEnvironment) launchVM(launch azureLaunch) error {
+	nic, _, err := e.createNIC(launch.name)
+	if err != nil {
+		return err
+	}
+	return e.createVM(launch.name, launch.shape, launch.tags, launch.config, nic)
+}
+
+// provisionConcurrently - calls launch for each of count nodes, at most
+// limit at a time, and returns the error of each node
+func provisionConcurrently(count int, limit int, launch func(idx int) error) []error {
+	errs := make([]error, count)
+	if limit <= 0 {
+		limit = defaultParallelLaunches
+	}
 
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	for idx := 0; idx < count; idx++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[idx] = launch(idx)
+		}(idx)
 	}
 
-	return privateIP, nil
+	wg.Wait()
+	return errs
 }
 
-func (e *AzureEnvironment) deleteVM(name string) {
+// deleteVM - deletes a VM along with its network interface and disk
+func (e *AzureEnvironment) deleteVM(name string) error {
 	cli, err := e.getVMClient()
 	if err != nil {
-		logger.GetError().Println(err)
+		return err
 	}
 
 	future, err := cli.Delete(context.Background(), e.ResourceGroup, name)
 	if err != nil {
-		logger.GetError().Println(err)
+		return err
 	}
 
 	err = future.WaitForCompletionRef(context.Background(), cli.Client)
 	if err != nil {
-		logger.GetError().Println(err)
+		return err
 	}
 
 	err = e.deleteNIC(name)
 	if err != nil {
-		logger.GetError().Println(err)
+		return err
+	}
+
+	return e.deleteDisk(name)
+}
+
+// deleteWorker - deletes the VM or scale set of a worker, logging failures
+func (e *AzureEnvironment) deleteWorker(name string) error {
+	var err error
+	if e.ScaleSet != nil {
+		err = e.deleteScaleSet(name)
+	} else {
+		err = e.deleteVM(name)
 	}
 
-	err = e.deleteDisk(name)
 	if err != nil {
-		logger.GetError().Println(err)
+		logger.GetError().Printf("failed to delete worker %s of cluster %v: %v", name, e.ClusterID, err)
 	}
+	return err
 }
 
-func (e *AzureEnvironment) launchMaster() (string, error) {
-	tags := make(map[string]*string)
+// launchMaster - launches the master attached to the network interface,
+// expecting the workers which have been launched
+func (e *AzureEnvironment) launchMaster(nic string, workers int64) error {
+	tags := map[string]*string{"CLUSTER_ID": to.StringPtr(e.ClusterID)}
 
-	tags["EXPECTED_WORKERS"] = to.StringPtr(strconv.FormatInt(e.WorkerNodes, 10))
-	tags["SPARK_WORKER_PORT"] = to.StringPtr(strconv.FormatInt(sparkWorkerPort, 10))
-	tags["CLUSTER_ID"] = to.StringPtr(e.ClusterID)
-	tags["ALLSPARK_CALLBACK"] = to.StringPtr(daemon.GetAllSparkConfig().CallbackURL)
+	config := []string{
+		"EXPECTED_WORKERS=" + strconv.FormatInt(workers, 10),
+		"SPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10),
+		"CLUSTER_ID=" + e.ClusterID,
+		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL,
+	}
 
-	if len(e.DataStorageAccount) > 0 {
-		tags["DATA_STORAGE_ACCOUNT"] = to.StringPtr(e.DataStorageAccount)
-		storageKey, err := e.getPrimaryStorageKey()
-		if err != nil {
-			return "", err
+	var vmSizes []string
+	for _, el := range e.workerPools() {
+		if el.Nodes > 0 {
+			vmSizes = append(vmSizes, string(el.VMSize))
 		}
-		tags["DATA_STORAGE_KEY"] = to.StringPtr(storageKey)
 	}
 
-	for _, el := range e.EnvParams {
-		buff := strings.SplitN(el, "=", 2)
-		tags[buff[0]] = to.StringPtr(buff[1])
+	executorMemory, err := getExecutorMemory(e.describeMemoryGB, vmSizes...)
+	if err != nil {
+		return err
+	}
+	if len(executorMemory) > 0 {
+		config = append(config, "EXECUTOR_MEMORY="+executorMemory)
+	}
+
+	storage, err := e.storageConfig()
+	if err != nil {
+		return err
 	}
+	config = append(config, storage...)
+	config = append(config, e.EnvParams...)
 
-	return e.createVM(e.ClusterID+"-master", tags, false)
+	return e.createVM(e.ClusterID+"-master", e.masterShape(), tags, config, nic)
 }
 
-func (e *AzureEnvironment) launchWorkers(masterIP string) error {
+// storageConfig - returns the configuration nodes reach the data storage
+// account with; nodes use the storage identity if set, or else the
+// primary key of the account
+func (e *AzureEnvironment) storageConfig() ([]string, error) {
+	if len(e.DataStorageAccount) == 0 {
+		return nil, nil
+	}
+
+	if len(e.StorageIdentity) > 0 {
+		return []string{"DATA_STORAGE_ACCOUNT=" + e.DataStorageAccount,
+			"DATA_STORAGE_IDENTITY=" + e.StorageIdentity}, nil
+	}
+
+	storageKey, err := e.getPrimaryStorageKey()
+	if err != nil {
+		return nil, err
+	}
+	return []string{"DATA_STORAGE_ACCOUNT=" + e.DataStorageAccount,
+		"DATA_STORAGE_KEY=" + storageKey}, nil
+}
+
+// workerLaunches - returns the VMs of the workers of a pool, or the scale
+// set of the pool if workers run in scale sets
+func (e *AzureEnvironment) workerLaunches(masterIP string, pool AzureWorkerPool) []azureLaunch {
 	tags := make(map[string]*string)
 
-	tags["MASTER_IP"] = to.StringPtr(masterIP)
-	tags["SPARK_WORKER_PORT"] = to.StringPtr(strconv.FormatInt(sparkWorkerPort, 10))
+	for key, value := range pool.Labels {
+		tags[key] = to.StringPtr(value)
+	}
 
-	for _, el := range e.EnvParams {
-		buff := strings.SplitN(el, "=", 2)
-		tags[buff[0]] = to.StringPtr(buff[1])
+	tags["CLUSTER_ID"] = to.StringPtr(e.ClusterID)
+
+	config := append([]string{
+		"MASTER_IP=" + masterIP,
+		"SPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10),
+		"CLUSTER_ID=" + e.ClusterID,
+		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL,
+	}, poolEnvParams(e.EnvParams, pool.WorkerPool)...)
+
+	shape := azureNodeShape{VMSize: pool.VMSize, DiskSizeGB: pool.DiskSizeGB}
+	var launches []azureLaunch
+	if e.ScaleSet != nil {
+		if pool.Nodes > 0 {
+			launches = append(launches, azureLaunch{
+				name:  poolIdentifier(e.ClusterID, pool.Name),
+				shape: shape,
+				tags:  tags,
+				nodes: pool.Nodes,
+				pool:  pool,
+			})
+		}
+		return launches
 	}
 
 	var i int64
-	for i = 0; i < e.WorkerNodes; i++ {
-		_, err := e.createVM(e.ClusterID+"-worker-"+strconv.FormatInt(i, 10), tags, false)
-		if err != nil {
-			return err
+	for i = 0; i < pool.Nodes; i++ {
+		launches = append(launches, azureLaunch{
+			name:   poolIdentifier(e.ClusterID, pool.Name) + "-" + strconv.FormatInt(i, 10),
+			shape:  shape,
+			tags:   tags,
+			config: config,
+			nodes:  1,
+			pool:   pool,
+		})
+	}
+
+	return launches
+}
+
+// launchWorkers - provisions the workers of all pools concurrently and
+// returns the number of workers launched. Workers which failed to launch
+// fail the cluster unless TolerateWorkerFailures is set, in which case they are
+// deleted and the cluster runs with the remaining workers
+func (e *AzureEnvironment) launchWorkers(masterIP string) (int64, error) {
+	var launches []azureLaunch
+	var expected int64
+	for _, el := range e.workerPools() {
+		launches = append(launches, e.workerLaunches(masterIP, el)...)
+		expected += el.Nodes
+	}
+
+	errs := provisionConcurrently(len(launches), e.ParallelLaunches, func(idx int) error {
+		if e.ScaleSet != nil {
+			return e.createScaleSet(launches[idx])
 		}
+		return e.launchVM(launches[idx])
+	})
+
+	var launched int64
+	var failures []string
+	for idx, err := range errs {
+		if err == nil {
+			launched += launches[idx].nodes
+			continue
+		}
+		logger.GetError().Printf("failed to launch worker %s of cluster %v: %v",
+			launches[idx].name, e.ClusterID, err)
+		failures = append(failures, launches[idx].name+": "+err.Error())
 	}
 
-	return nil
+	if len(failures) == 0 {
+		return launched, nil
+	}
+
+	if !e.TolerateWorkerFailures || launched == 0 {
+		return launched, errors.New("failed to launch " + strconv.Itoa(len(failures)) +
+			" workers; " + strings.Join(failures, "; "))
+	}
+
+	logger.GetInfo().Printf("cluster %v continues with %v of %v workers",
+		e.ClusterID, launched, expected)
+
+	// failed workers are deleted before the master is launched; workers
+	// which could not be deleted are removed when the cluster is destroyed
+	var failed []string
+	for idx, err := range errs {
+		if err != nil {
+			failed = append(failed, launches[idx].name)
+		}
+	}
+	provisionConcurrently(len(failed), e.ParallelLaunches, func(idx int) error {
+		return e.deleteWorker(failed[idx])
+	})
+	return launched, nil
 }
 
-// CreateCluster - creates spark clusters
+// CreateCluster - creates spark clusters; the network interface of the
+// master is created first so workers can be pointed at it, and the master
+// is launched once the workers have been provisioned. Nodes are created
+// from the managed or gallery image if set, or else from the imported VHD
 func (e *AzureEnvironment) CreateCluster() (string, error) {
-	masterIP, err := e.launchMaster()
+	if e.usesImage() {
+		image, err := e.resolveImage()
+		if err != nil {
+			return "", err
+		}
+		e.image = image
+	}
+
+	nic, masterIP, err := e.createNIC(e.ClusterID + "-master")
 	if err != nil {
 		return "", err
 	}
 
-	if e.WorkerNodes > 0 {
-		return "", e.launchWorkers(masterIP)
+	workers, err := e.launchWorkers(masterIP)
+	if err != nil {
+		return "", err
 	}
 
-	return "", err
+	return "", e.launchMaster(nic, workers)
 }
 
-// DestroyCluster - destroys spark clusters
+// DestroyCluster - destroys spark clusters; scale sets are deleted along
+// with their instances, and network interfaces and disks left behind by
+// workers which failed to launch are deleted as well
 func (e *AzureEnvironment) DestroyCluster() error {
-	vms, err := e.getClusterNodes()
+	vms, err := e.getVMs()
+	if err != nil {
+		return err
+	}
+
+	err = e.deleteOrphans(vms)
+	if err != nil {
+		return err
+	}
+
+	scaleSets, err := e.getScaleSets()
 	if err != nil {
 		return err
 	}
 
 	for _, el := range vms {
-		go e.deleteVM(el)
+		go func(name string) {
+			if err := e.deleteVM(name); err != nil {
+				logger.GetError().Printf("failed to delete vm %s of cluster %v: %v", name, e.ClusterID, err)
+			}
+		}(el)
+	}
+
+	for _, el := range scaleSets {
+		go func(name string) {
+			if err := e.deleteScaleSet(name); err != nil {
+				logger.GetError().Printf("failed to delete scale set %s of cluster %v: %v",
+					name, e.ClusterID, err)
+			}
+		}(el)
 	}
 
 	return nil
 }
 
+// deleteOrphans - deletes the network interfaces and disks of the cluster
+// which do not belong to any of its VMs
+func (e *AzureEnvironment) deleteOrphans(vms []string) error {
+	nics, err := e.getNics()
+	if err != nil {
+		return err
+	}
+
+	disks, err := e.getDisks()
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool)
+	for _, el := range vms {
+		owned[el] = true
+	}
+
+	for _, el := range nics {
+		if !owned[el] {
+			logger.GetInfo().Printf("deleting network interface %s of cluster %v", el, e.ClusterID)
+			if err := e.deleteNIC(el); err != nil {
+				logger.GetError().Println(err)
+			}
+		}
+	}
+
+	for _, el := range disks {
+		if !owned[el] {
+			logger.GetInfo().Printf("deleting disk %s of cluster %v", el, e.ClusterID)
+			if err := e.deleteDisk(el); err != nil {
+				logger.GetError().Println(err)
+			}
+		}
+	}
+	return nil
+}
+
 // DestructionConfirmed - returns true if the cluster has been terminated; false otherwise
 func (e *AzureEnvironment) DestructionConfirmed() bool {
 	vms, err := e.getClusterNodes()
@@ -459,7 +839,14 @@ func (e *AzureEnvironment) DestructionConfirmed() bool {
 		return false
 	}
 
-	return (len(disks) == 0) && (len(nics) == 0) && (len(vms) == 0)
+	scaleSets, err := e.getScaleSets()
+	if err != nil {
+		logger.GetError().Println(err)
+		logger.GetError().Printf("unable to confirm destruction of cluster %v; failed to retrieve scale sets", e.ClusterID)
+		return false
+	}
+
+	return (len(disks) == 0) && (len(nics) == 0) && (len(vms) == 0) && (len(scaleSets) == 0)
 }
 
 func (e *AzureEnvironment) getNics() ([]string, error) {
@@ -506,7 +893,46 @@ func (e *AzureEnvironment) getDisks() ([]string, error) {
 	return items, err
 }
 
+// GetResources - returns the compute resources requested by the cluster
+func (e *AzureEnvironment) GetResources() ClusterResources {
+	master := e.masterShape()
+	groups := []NodeGroup{
+		{InstanceType: string(master.VMSize), Nodes: 1, DiskGB: int64(master.DiskSizeGB)},
+	}
+
+	for _, el := range e.workerPools() {
+		groups = append(groups, NodeGroup{InstanceType: string(el.VMSize), Nodes: el.Nodes,
+			DiskGB: int64(el.DiskSizeGB), Pool: el.Name})
+	}
+
+	return newClusterResources(groups...)
+}
+
+// getClusterNodes - returns the VMs of the cluster along with the
+// instances of its scale sets
 func (e *AzureEnvironment) getClusterNodes() ([]string, error) {
+	items, err := e.getVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	scaleSets, err := e.getScaleSets()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, el := range scaleSets {
+		instances, err := e.getScaleSetInstances(el)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, instances...)
+	}
+
+	return items, nil
+}
+
+func (e *AzureEnvironment) getVMs() ([]string, error) {
 	cli, err := e.getVMClient()
 	if err != nil {
 		return nil, err
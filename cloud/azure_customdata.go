@@ -0,0 +1,84 @@
+package cloud
+
+import (
+	b64 "encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// limits of the tags of a VM
+const (
+	maxAzureTags           = 50
+	maxAzureTagValueLength = 256
+)
+
+// acceptsCustomData - returns true if the nodes are provisioned from a
+// generalized image; Azure only accepts custom data for such nodes
+func (e *AzureEnvironment) acceptsCustomData() bool {
+	return e.image != nil && e.image.Generalized
+}
+
+// customData - returns the node configuration as base64-encoded custom
+// data, one NAME='VALUE' line per parameter; nodes source the lines, so
+// values are single-quoted
+func customData(config []string) string {
+	var buffer strings.Builder
+	for _, el := range config {
+		buff := strings.SplitN(el, "=", 2)
+		buffer.WriteString(buff[0] + "=" + shellQuote(buff[1]) + "\n")
+	}
+	return b64.StdEncoding.EncodeToString([]byte(buffer.String()))
+}
+
+// configTags - returns the tags along with the node configuration, for
+// nodes which cannot receive custom data; Key Vault references are never
+// written to tags
+func configTags(tags map[string]*string, config []string) (map[string]*string, error) {
+	result := make(map[string]*string)
+	for key, value := range tags {
+		result[key] = value
+	}
+
+	for _, el := range config {
+		buff := strings.SplitN(el, "=", 2)
+		if isKeyVaultReference(buff[1]) {
+			return nil, errors.New(buff[0] + " is a Key Vault reference, which can only be " +
+				"delivered as custom data; launch the cluster from a generalized image")
+		}
+		if len(buff[1]) > maxAzureTagValueLength {
+			return nil, errors.New(buff[0] + " exceeds the " + strconv.Itoa(maxAzureTagValueLength) +
+				" characters of a tag value; launch the cluster from a generalized image")
+		}
+		result[buff[0]] = to.StringPtr(buff[1])
+	}
+
+	if len(result) > maxAzureTags {
+		return nil, errors.New("node configuration exceeds the " + strconv.Itoa(maxAzureTags) +
+			" tags of a VM; launch the cluster from a generalized image")
+	}
+	return result, nil
+}
+
+// nodeConfig - returns the tags and configuration of a node; nodes which
+// cannot receive custom data carry their configuration as tags if the
+// template opts in with ConfigTags, while the Key Vault references of the
+// custom data of other nodes are resolved
+func (e *AzureEnvironment) nodeConfig(tags map[string]*string,
+	config []string) (map[string]*string, []string, error) {
+
+	if !e.acceptsCustomData() {
+		if !e.ConfigTags {
+			return nil, nil, errors.New("nodes booted from a VHD or a specialized image " +
+				"cannot receive custom data; launch the cluster from a generalized image " +
+				"or set ConfigTags to deliver the configuration as VM tags")
+		}
+		tags, err := configTags(tags, config)
+		return tags, config, err
+	}
+
+	config, err := resolveConfig(config)
+	return tags, config, err
+}
@@ -0,0 +1,69 @@
+package cloud
+
+import (
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+)
+
+// ValidateManagedIdentities - verifies the storage identity is one of the
+// managed identities attached to the nodes
+func ValidateManagedIdentities(identities []string, storageIdentity string) error {
+	if len(storageIdentity) == 0 {
+		return nil
+	}
+
+	for _, el := range identities {
+		if el == storageIdentity {
+			return nil
+		}
+	}
+
+	return errors.New("storage identity " + storageIdentity + " must be one of the managed identities")
+}
+
+// ValidateNodeConfig - verifies nodes booted from an imported VHD, which
+// cannot receive custom data, opt into reading their configuration from
+// VM tags
+func ValidateNodeConfig(usesImage bool, configTags bool) error {
+	if !usesImage && !configTags {
+		return errors.New("nodes booted from a VHD cannot receive custom data; " +
+			"set ConfigTags to deliver their configuration as VM tags or launch " +
+			"the cluster from an image")
+	}
+	return nil
+}
+
+// vmIdentity - returns the user-assigned identities of the VMs
+func (e *AzureEnvironment) vmIdentity() *compute.VirtualMachineIdentity {
+	if len(e.ManagedIdentities) == 0 {
+		return nil
+	}
+
+	identities := make(map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue)
+	for _, el := range e.ManagedIdentities {
+		identities[el] = &compute.VirtualMachineIdentityUserAssignedIdentitiesValue{}
+	}
+
+	return &compute.VirtualMachineIdentity{
+		Type:                   compute.ResourceIdentityTypeUserAssigned,
+		UserAssignedIdentities: identities,
+	}
+}
+
+// scaleSetIdentity - returns the user-assigned identities of the scale sets
+func (e *AzureEnvironment) scaleSetIdentity() *compute.VirtualMachineScaleSetIdentity {
+	if len(e.ManagedIdentities) == 0 {
+		return nil
+	}
+
+	identities := make(map[string]*compute.VirtualMachineScaleSetIdentityUserAssignedIdentitiesValue)
+	for _, el := range e.ManagedIdentities {
+		identities[el] = &compute.VirtualMachineScaleSetIdentityUserAssignedIdentitiesValue{}
+	}
+
+	return &compute.VirtualMachineScaleSetIdentity{
+		Type:                   compute.ResourceIdentityTypeUserAssigned,
+		UserAssignedIdentities: identities,
+	}
+}
@@ -0,0 +1,240 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	latestImageVersion   = "latest"
+	defaultAdminUsername = "allspark"
+)
+
+// AzureGalleryImage identifies an image definition of a Shared Image Gallery;
+// an empty Version, or latest, selects the most recent version of the
+// image which is not excluded from latest
+type AzureGalleryImage struct {
+	ResourceGroup string `json:",omitempty"`
+	Gallery       string
+	Definition    string
+	Version       string `json:",omitempty"`
+}
+
+// azureImage is the resolved image the nodes of a cluster are created from
+type azureImage struct {
+	ID          string
+	Generalized bool
+}
+
+// ValidateAzureImage - verifies at most one image source is set and that
+// managed images, which are always generalized, have an SSH public key;
+// gallery images only require one if their definition is generalized,
+// which is verified when the image is resolved
+func ValidateAzureImage(imageID string, gallery *AzureGalleryImage, sshPublicKey string) error {
+	if len(imageID) > 0 && gallery != nil {
+		return errors.New("specify either an image ID or a gallery image")
+	}
+
+	if gallery != nil && (len(gallery.Gallery) == 0 || len(gallery.Definition) == 0) {
+		return errors.New("gallery image requires a gallery and a definition")
+	}
+
+	if len(imageID) > 0 && len(sshPublicKey) == 0 {
+		return errors.New("generalized images require an SSH public key")
+	}
+
+	return nil
+}
+
+// usesImage - returns true if nodes are created from a managed or gallery
+// image rather than from an imported VHD
+func (e *AzureEnvironment) usesImage() bool {
+	return len(e.ImageID) > 0 || e.GalleryImage != nil
+}
+
+func (e *AzureEnvironment) getGalleryImagesClient() (compute.GalleryImagesClient, error) {
+	client := compute.NewGalleryImagesClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
+	client.Authorizer = authorizer
+	return client, err
+}
+
+func (e *AzureEnvironment) getGalleryImageVersionsClient() (compute.GalleryImageVersionsClient, error) {
+	client := compute.NewGalleryImageVersionsClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
+	client.Authorizer = authorizer
+	return client, err
+}
+
+// compareImageVersions - compares Major.Minor.Patch gallery image versions
+func compareImageVersions(a string, b string) int {
+	left := strings.Split(a, ".")
+	right := strings.Split(b, ".")
+	for idx := 0; idx < len(left) || idx < len(right); idx++ {
+		var x, y int64
+		if idx < len(left) {
+			x, _ = strconv.ParseInt(left[idx], 10, 64)
+		}
+		if idx < len(right) {
+			y, _ = strconv.ParseInt(right[idx], 10, 64)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// latestGalleryImageVersion - returns the most recent provisioned version
+// which is not excluded from latest
+func latestGalleryImageVersion(versions []compute.GalleryImageVersion) (compute.GalleryImageVersion, error) {
+	var latest *compute.GalleryImageVersion
+	for idx, el := range versions {
+		if el.Name == nil || el.GalleryImageVersionProperties == nil ||
+			el.ProvisioningState != compute.ProvisioningState3Succeeded {
+			continue
+		}
+
+		profile := el.PublishingProfile
+		if profile != nil && to.Bool(profile.ExcludeFromLatest) {
+			continue
+		}
+
+		if latest == nil || compareImageVersions(*el.Name, *latest.Name) > 0 {
+			latest = &versions[idx]
+		}
+	}
+
+	if latest == nil {
+		return compute.GalleryImageVersion{}, errors.New("gallery image has no versions; " +
+			"unable to resolve the latest version")
+	}
+	return *latest, nil
+}
+
+// resolveGalleryImage - returns the image version of the gallery image
+func (e *AzureEnvironment) resolveGalleryImage() (*azureImage, error) {
+	ctx := context.Background()
+	gallery := e.GalleryImage
+	resourceGroup := gallery.ResourceGroup
+	if len(resourceGroup) == 0 {
+		resourceGroup = e.ResourceGroup
+	}
+
+	imagesClient, err := e.getGalleryImagesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := imagesClient.Get(ctx, resourceGroup, gallery.Gallery, gallery.Definition)
+	if err != nil {
+		return nil, err
+	}
+
+	generalized := definition.GalleryImageProperties != nil &&
+		definition.OsState == compute.Generalized
+
+	if len(gallery.Version) > 0 && gallery.Version != latestImageVersion {
+		return &azureImage{
+			ID:          *definition.ID + "/versions/" + gallery.Version,
+			Generalized: generalized,
+		}, nil
+	}
+
+	versionsClient, err := e.getGalleryImageVersionsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := versionsClient.ListByGalleryImageComplete(ctx, resourceGroup,
+		gallery.Gallery, gallery.Definition)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []compute.GalleryImageVersion
+	for iter.NotDone() {
+		versions = append(versions, iter.Value())
+		err = iter.NextWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	version, err := latestGalleryImageVersion(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureImage{ID: *version.ID, Generalized: generalized}, nil
+}
+
+// resolveImage - returns the image the nodes are created from; managed
+// images are always generalized
+func (e *AzureEnvironment) resolveImage() (*azureImage, error) {
+	if len(e.ImageID) > 0 {
+		return &azureImage{ID: e.ImageID, Generalized: true}, nil
+	}
+
+	image, err := e.resolveGalleryImage()
+	if err != nil {
+		return nil, err
+	}
+
+	if image.Generalized && len(e.SSHPublicKey) == 0 {
+		return nil, errors.New("generalized images require an SSH public key")
+	}
+	return image, nil
+}
+
+// imageStorageProfile - returns the storage profile of a VM whose OS disk
+// is created from the image
+func imageStorageProfile(name string, image *azureImage, diskSizeGB int32) *compute.StorageProfile {
+	return &compute.StorageProfile{
+		ImageReference: &compute.ImageReference{ID: to.StringPtr(image.ID)},
+		OsDisk: &compute.OSDisk{
+			Name:         to.StringPtr(name),
+			CreateOption: compute.DiskCreateOptionTypesFromImage,
+			OsType:       compute.Linux,
+			DiskSizeGB:   to.Int32Ptr(diskSizeGB),
+			ManagedDisk: &compute.ManagedDiskParameters{
+				StorageAccountType: compute.StorageAccountTypesStandardLRS,
+			},
+		},
+	}
+}
+
+// osProfile - returns the OS profile of a VM created from a generalized
+// image, which only accepts SSH authentication and receives its
+// configuration as custom data
+func (e *AzureEnvironment) osProfile(name string, config []string) *compute.OSProfile {
+	username := e.AdminUsername
+	if len(username) == 0 {
+		username = defaultAdminUsername
+	}
+
+	return &compute.OSProfile{
+		ComputerName:  to.StringPtr(name),
+		AdminUsername: to.StringPtr(username),
+		CustomData:    to.StringPtr(customData(config)),
+		LinuxConfiguration: &compute.LinuxConfiguration{
+			DisablePasswordAuthentication: to.BoolPtr(true),
+			SSH: &compute.SSHConfiguration{
+				PublicKeys: &[]compute.SSHPublicKey{
+					{
+						Path:    to.StringPtr("/home/" + username + "/.ssh/authorized_keys"),
+						KeyData: to.StringPtr(e.SSHPublicKey),
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,156 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+const (
+	keyVaultResource = "https://vault.azure.net"
+	secretCacheTTL   = 5 * time.Minute
+)
+
+// matches Key Vault references of the form
+// @Microsoft.KeyVault(SecretUri=https://<vault>.vault.azure.net/secrets/<name>[/<version>])
+var keyVaultReference = regexp.MustCompile(`^@Microsoft\.KeyVault\(SecretUri=([^)]+)\)$`)
+
+// keyVaultAuthorizer - returns the credentials the daemon reads Key Vault
+// secrets with, taken from its environment or managed identity
+var keyVaultAuthorizer = func() (autorest.Authorizer, error) {
+	return auth.NewAuthorizerFromEnvironmentWithResource(keyVaultResource)
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// resolved secrets by secret URI; secrets are re-read once expired so
+// rotated secrets are picked up
+var secretCache = struct {
+	sync.Mutex
+	values map[string]cachedSecret
+}{values: make(map[string]cachedSecret)}
+
+// isKeyVaultReference - returns true if the value references a Key Vault secret
+func isKeyVaultReference(value string) bool {
+	return keyVaultReference.MatchString(value)
+}
+
+// parseSecretURI - returns the vault URL, name and version of a secret URI;
+// the version is empty for the current version of the secret
+func parseSecretURI(secretURI string) (string, string, string, error) {
+	parsed, err := url.Parse(secretURI)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	path := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parsed.Host) == 0 || len(path) < 2 || len(path) > 3 || path[0] != "secrets" {
+		return "", "", "", errors.New("invalid Key Vault secret URI " + secretURI)
+	}
+
+	version := ""
+	if len(path) == 3 {
+		version = path[2]
+	}
+	return parsed.Scheme + "://" + parsed.Host, path[1], version, nil
+}
+
+// ValidateSecretReference - verifies the value is either a plain value or
+// a well-formed Key Vault reference
+func ValidateSecretReference(value string) error {
+	if !strings.HasPrefix(value, "@Microsoft.KeyVault") {
+		return nil
+	}
+
+	match := keyVaultReference.FindStringSubmatch(value)
+	if match == nil {
+		return errors.New("invalid Key Vault reference " + value)
+	}
+
+	_, _, _, err := parseSecretURI(match[1])
+	return err
+}
+
+// ValidateEnvParamReferences - verifies the Key Vault references of the
+// values of NAME=VALUE parameters
+func ValidateEnvParamReferences(params []string) error {
+	for _, el := range params {
+		buff := strings.SplitN(el, "=", 2)
+		if len(buff) == 2 {
+			err := ValidateSecretReference(buff[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecret - returns the value of a Key Vault reference, or the value
+// itself if it is not a reference
+func resolveSecret(value string) (string, error) {
+	match := keyVaultReference.FindStringSubmatch(value)
+	if match == nil {
+		return value, nil
+	}
+	secretURI := match[1]
+
+	secretCache.Lock()
+	cached, ok := secretCache.values[secretURI]
+	secretCache.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	vaultURL, name, version, err := parseSecretURI(secretURI)
+	if err != nil {
+		return "", err
+	}
+
+	authorizer, err := keyVaultAuthorizer()
+	if err != nil {
+		return "", err
+	}
+
+	client := keyvault.New()
+	client.Authorizer = authorizer
+	secret, err := client.GetSecret(context.Background(), vaultURL, name, version)
+	if err != nil {
+		return "", err
+	}
+	if secret.Value == nil {
+		return "", errors.New("Key Vault secret " + secretURI + " has no value")
+	}
+
+	secretCache.Lock()
+	secretCache.values[secretURI] = cachedSecret{value: *secret.Value, expires: time.Now().Add(secretCacheTTL)}
+	secretCache.Unlock()
+
+	return *secret.Value, nil
+}
+
+// resolveConfig - returns the NAME=VALUE configuration with its Key Vault
+// references resolved
+func resolveConfig(config []string) ([]string, error) {
+	result := make([]string, len(config))
+	for idx, el := range config {
+		buff := strings.SplitN(el, "=", 2)
+		value, err := resolveSecret(buff[1])
+		if err != nil {
+			return nil, err
+		}
+		result[idx] = buff[0] + "=" + value
+	}
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// workerPool - returns the worker pool of the cluster with the shapes of
+// the template applied
+func (e *AzureEnvironment) workerPool(name string) (AzureWorkerPool, error) {
+	for _, el := range e.workerPools() {
+		if el.Name == name {
+			return el, nil
+		}
+	}
+	return AzureWorkerPool{}, errors.New("worker pool " + name + " not found")
+}
+
+// SetPoolNodes - records the node count of a worker pool in the template
+func (e *AzureEnvironment) SetPoolNodes(name string, nodes int64) error {
+	if _, err := e.workerPool(name); err != nil {
+		return err
+	}
+
+	if len(e.WorkerPools) == 0 {
+		e.WorkerNodes = nodes
+		return nil
+	}
+
+	for idx := range e.WorkerPools {
+		if e.WorkerPools[idx].Name == name {
+			e.WorkerPools[idx].Nodes = nodes
+		}
+	}
+	return nil
+}
+
+// getNICAddress - returns the private IP of the network interface of a VM
+func (e *AzureEnvironment) getNICAddress(name string) (string, error) {
+	cli, err := e.getNicClient()
+	if err != nil {
+		return "", err
+	}
+
+	nic, err := cli.Get(context.Background(), e.ResourceGroup, name, "")
+	if err != nil {
+		return "", err
+	}
+
+	if nic.IPConfigurations == nil || len(*nic.IPConfigurations) == 0 ||
+		(*nic.IPConfigurations)[0].PrivateIPAddress == nil {
+		return "", errors.New("private IP not found for VM " + name)
+	}
+	return *(*nic.IPConfigurations)[0].PrivateIPAddress, nil
+}
+
+// poolVMIndices - returns the indices of the worker VMs of a pool, named
+// <pool identifier>-<index>, in ascending order
+func poolVMIndices(prefix string, vms []string) []int64 {
+	var indices []int64
+	for _, el := range vms {
+		if !strings.HasPrefix(el, prefix+"-") {
+			continue
+		}
+		idx, err := strconv.ParseInt(strings.TrimPrefix(el, prefix+"-"), 10, 64)
+		if err == nil {
+			indices = append(indices, idx)
+		}
+	}
+
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// freePoolVMIndices - returns the count lowest indices not in use
+func freePoolVMIndices(indices []int64, count int) []int64 {
+	used := make(map[int64]bool)
+	for _, el := range indices {
+		used[el] = true
+	}
+
+	var free []int64
+	for idx := int64(0); len(free) < count; idx++ {
+		if !used[idx] {
+			free = append(free, idx)
+		}
+	}
+	return free
+}
+
+// ResizePool - launches or deletes worker VMs of a pool to reach the
+// specified number of nodes; the VMs with the highest indices are
+// deleted first. Pools of scale sets are resized by setting the capacity
+// of their scale set
+func (e *AzureEnvironment) ResizePool(pool string, nodes int64) ([]string, error) {
+	workerPool, err := e.workerPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.ScaleSet != nil {
+		removed, err := e.resizeScaleSet(workerPool, nodes)
+		if err != nil {
+			return removed, err
+		}
+		e.SetPoolNodes(pool, nodes)
+		return removed, nil
+	}
+
+	vms, err := e.getVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := poolIdentifier(e.ClusterID, pool)
+	indices := poolVMIndices(prefix, vms)
+	current := int64(len(indices))
+
+	if nodes > current {
+		return nil, e.growPool(workerPool, indices, nodes)
+	}
+
+	var removed []string
+	if nodes < current {
+		logger.GetInfo().Printf("shrinking pool %v of cluster %v from %v to %v workers",
+			pool, e.ClusterID, current, nodes)
+		var names, addresses []string
+		for _, idx := range indices[nodes:] {
+			name := prefix + "-" + strconv.FormatInt(idx, 10)
+			address, _ := e.getNICAddress(name)
+			names = append(names, name)
+			addresses = append(addresses, address)
+		}
+
+		errs := provisionConcurrently(len(names), e.ParallelLaunches, func(idx int) error {
+			return e.deleteWorker(names[idx])
+		})
+
+		// workers which could not be deleted remain in the pool
+		var failures []string
+		for idx, err := range errs {
+			if err != nil {
+				failures = append(failures, names[idx]+": "+err.Error())
+			} else if len(addresses[idx]) > 0 {
+				removed = append(removed, addresses[idx])
+			}
+		}
+
+		if len(failures) > 0 {
+			e.SetPoolNodes(pool, nodes+int64(len(failures)))
+			return removed, errors.New("failed to delete " + strconv.Itoa(len(failures)) +
+				" workers; " + strings.Join(failures, "; "))
+		}
+	}
+
+	e.SetPoolNodes(pool, nodes)
+	return removed, nil
+}
+
+// growPool - launches the workers a pool lacks at the lowest free indices;
+// workers which fail to launch are deleted and fail the resize
+func (e *AzureEnvironment) growPool(pool AzureWorkerPool, indices []int64, nodes int64) error {
+	if e.usesImage() {
+		image, err := e.resolveImage()
+		if err != nil {
+			return err
+		}
+		e.image = image
+	}
+
+	masterIP, err := e.getNICAddress(e.ClusterID + "-master")
+	if err != nil {
+		return err
+	}
+
+	logger.GetInfo().Printf("growing pool %v of cluster %v from %v to %v workers",
+		pool.Name, e.ClusterID, len(indices), nodes)
+
+	prefix := poolIdentifier(e.ClusterID, pool.Name)
+	pool.Nodes = nodes - int64(len(indices))
+	launches := e.workerLaunches(masterIP, pool)
+	for idx, el := range freePoolVMIndices(indices, len(launches)) {
+		launches[idx].name = prefix + "-" + strconv.FormatInt(el, 10)
+	}
+
+	errs := provisionConcurrently(len(launches), e.ParallelLaunches, func(idx int) error {
+		return e.launchVM(launches[idx])
+	})
+
+	launched := int64(len(indices))
+	var failures []string
+	for idx, err := range errs {
+		if err == nil {
+			launched++
+			continue
+		}
+		failures = append(failures, launches[idx].name+": "+err.Error())
+		e.deleteWorker(launches[idx].name)
+	}
+
+	e.SetPoolNodes(pool.Name, launched)
+	if len(failures) > 0 {
+		return errors.New("failed to launch " + strconv.Itoa(len(failures)) +
+			" workers; " + strings.Join(failures, "; "))
+	}
+	return nil
+}
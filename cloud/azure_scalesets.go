@@ -0,0 +1,362 @@
+package cloud
+
+import (
+	"allspark/logger"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// AzureScaleSet describes the VM scale sets which run the workers of an
+// Azure cluster, one scale set per worker pool. Spot workers are evicted
+// according to EvictionPolicy and bid up to MaxPrice; a MaxPrice of zero
+// bids the on-demand price
+type AzureScaleSet struct {
+	Spot           bool    `json:",omitempty"`
+	MaxPrice       float64 `json:",omitempty"`
+	EvictionPolicy string  `json:",omitempty"`
+}
+
+// ValidateAzureScaleSet - verifies scale sets are created from an image and
+// their eviction policy is supported
+func ValidateAzureScaleSet(scaleSet *AzureScaleSet, usesImage bool) error {
+	if scaleSet == nil {
+		return nil
+	}
+
+	if !usesImage {
+		return errors.New("scale sets require an image ID or a gallery image")
+	}
+
+	if scaleSet.MaxPrice < 0 {
+		return errors.New("max price must not be negative")
+	}
+
+	switch compute.VirtualMachineEvictionPolicyTypes(scaleSet.EvictionPolicy) {
+	case "", compute.Delete, compute.Deallocate:
+		return nil
+	}
+
+	return errors.New("eviction policy must be " + string(compute.Delete) +
+		" or " + string(compute.Deallocate))
+}
+
+func (e *AzureEnvironment) getScaleSetClient() (compute.VirtualMachineScaleSetsClient, error) {
+	client := compute.NewVirtualMachineScaleSetsClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
+	client.Authorizer = authorizer
+	return client, err
+}
+
+func (e *AzureEnvironment) getScaleSetVMClient() (compute.VirtualMachineScaleSetVMsClient, error) {
+	client := compute.NewVirtualMachineScaleSetVMsClientWithBaseURI(resourceManagerURI, e.SubscriptionID)
+	authorizer, err := e.getAuthorizer()
+	client.Authorizer = authorizer
+	return client, err
+}
+
+// scaleSetParameters - returns the scale set of a worker pool
+func (e *AzureEnvironment) scaleSetParameters(launch azureLaunch,
+	subnetID string) (compute.VirtualMachineScaleSet, error) {
+
+	name, pool := launch.name, launch.pool
+	tags, config, err := e.nodeConfig(launch.tags, launch.config)
+	if err != nil {
+		return compute.VirtualMachineScaleSet{}, err
+	}
+
+	profile := &compute.VirtualMachineScaleSetVMProfile{
+		StorageProfile: &compute.VirtualMachineScaleSetStorageProfile{
+			ImageReference: &compute.ImageReference{ID: to.StringPtr(e.image.ID)},
+			OsDisk: &compute.VirtualMachineScaleSetOSDisk{
+				CreateOption: compute.DiskCreateOptionTypesFromImage,
+				OsType:       compute.Linux,
+				DiskSizeGB:   to.Int32Ptr(pool.DiskSizeGB),
+				ManagedDisk: &compute.VirtualMachineScaleSetManagedDiskParameters{
+					StorageAccountType: compute.StorageAccountTypesStandardLRS,
+				},
+			},
+		},
+		NetworkProfile: &compute.VirtualMachineScaleSetNetworkProfile{
+			NetworkInterfaceConfigurations: &[]compute.VirtualMachineScaleSetNetworkConfiguration{
+				{
+					Name: to.StringPtr(name),
+					VirtualMachineScaleSetNetworkConfigurationProperties: &compute.VirtualMachineScaleSetNetworkConfigurationProperties{
+						Primary: to.BoolPtr(true),
+						IPConfigurations: &[]compute.VirtualMachineScaleSetIPConfiguration{
+							{
+								Name: to.StringPtr(name),
+								VirtualMachineScaleSetIPConfigurationProperties: &compute.VirtualMachineScaleSetIPConfigurationProperties{
+									Subnet: &compute.APIEntityReference{ID: to.StringPtr(subnetID)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if e.image.Generalized {
+		vmProfile := e.osProfile(name, config)
+		profile.OsProfile = &compute.VirtualMachineScaleSetOSProfile{
+			ComputerNamePrefix: vmProfile.ComputerName,
+			AdminUsername:      vmProfile.AdminUsername,
+			CustomData:         vmProfile.CustomData,
+			LinuxConfiguration: vmProfile.LinuxConfiguration,
+		}
+	}
+
+	if e.ScaleSet.Spot {
+		maxPrice := e.ScaleSet.MaxPrice
+		if maxPrice == 0 {
+			maxPrice = -1
+		}
+
+		evictionPolicy := compute.VirtualMachineEvictionPolicyTypes(e.ScaleSet.EvictionPolicy)
+		if len(evictionPolicy) == 0 {
+			evictionPolicy = compute.Delete
+		}
+
+		profile.Priority = compute.Spot
+		profile.EvictionPolicy = evictionPolicy
+		profile.BillingProfile = &compute.BillingProfile{MaxPrice: to.Float64Ptr(maxPrice)}
+	}
+
+	return compute.VirtualMachineScaleSet{
+		Location: to.StringPtr(e.Region),
+		Tags:     tags,
+		Identity: e.scaleSetIdentity(),
+		Sku: &compute.Sku{
+			Name:     to.StringPtr(string(pool.VMSize)),
+			Tier:     to.StringPtr("Standard"),
+			Capacity: to.Int64Ptr(pool.Nodes),
+		},
+		VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+			UpgradePolicy:         &compute.UpgradePolicy{Mode: compute.Manual},
+			Overprovision:         to.BoolPtr(false),
+			VirtualMachineProfile: profile,
+		},
+	}, nil
+}
+
+// createScaleSet - creates the scale set of a worker pool and waits for
+// its instances to be provisioned
+func (e *AzureEnvironment) createScaleSet(launch azureLaunch) error {
+
+	cli, err := e.getScaleSetClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	subnet, err := e.getSubnet(ctx, e.VMNet, e.VMSubnet)
+	if err != nil {
+		return err
+	}
+
+	parameters, err := e.scaleSetParameters(launch, *subnet.ID)
+	if err != nil {
+		return err
+	}
+
+	future, err := cli.CreateOrUpdate(ctx, e.ResourceGroup, launch.name, parameters)
+	if err != nil {
+		return err
+	}
+
+	err = future.WaitForCompletionRef(ctx, cli.Client)
+	if err != nil {
+		return err
+	}
+
+	logger.GetInfo().Printf("created scale set %s with %v workers", launch.name, launch.nodes)
+	return nil
+}
+
+func (e *AzureEnvironment) deleteScaleSet(name string) error {
+	cli, err := e.getScaleSetClient()
+	if err != nil {
+		return err
+	}
+
+	future, err := cli.Delete(context.Background(), e.ResourceGroup, name)
+	if err != nil {
+		return err
+	}
+
+	return future.WaitForCompletionRef(context.Background(), cli.Client)
+}
+
+// getScaleSets - returns the names of the scale sets of the cluster
+func (e *AzureEnvironment) getScaleSets() ([]string, error) {
+	cli, err := e.getScaleSetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cli.List(context.Background(), e.ResourceGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0)
+
+	for _, el := range result.Values() {
+		if el.Name != nil && strings.Contains(*el.Name, e.ClusterID) {
+			items = append(items, *el.Name)
+		}
+	}
+
+	return items, err
+}
+
+// getScaleSetInstances - returns the names of the instances of the scale set
+func (e *AzureEnvironment) getScaleSetInstances(name string) ([]string, error) {
+	cli, err := e.getScaleSetVMClient()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cli.List(context.Background(), e.ResourceGroup, name, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0)
+
+	for _, el := range result.Values() {
+		if el.Name != nil {
+			items = append(items, *el.Name)
+		}
+	}
+
+	return items, err
+}
+
+// getScaleSetAddresses - returns the private IPs of the instances of the
+// scale set
+func (e *AzureEnvironment) getScaleSetAddresses(name string) ([]string, error) {
+	cli, err := e.getNicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	iter, err := cli.ListVirtualMachineScaleSetNetworkInterfacesComplete(ctx, e.ResourceGroup, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for iter.NotDone() {
+		nic := iter.Value()
+		if nic.InterfacePropertiesFormat != nil && nic.IPConfigurations != nil {
+			for _, el := range *nic.IPConfigurations {
+				if el.InterfaceIPConfigurationPropertiesFormat != nil && el.PrivateIPAddress != nil {
+					addresses = append(addresses, *el.PrivateIPAddress)
+				}
+			}
+		}
+
+		err = iter.NextWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
+
+// resizeScaleSet - sets the capacity of the scale set of a worker pool and
+// returns the private IPs of the instances removed by scaling in; pools
+// launched without nodes get their scale set created
+func (e *AzureEnvironment) resizeScaleSet(pool AzureWorkerPool, nodes int64) ([]string, error) {
+	name := poolIdentifier(e.ClusterID, pool.Name)
+	scaleSets, err := e.getScaleSets()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, el := range scaleSets {
+		exists = exists || el == name
+	}
+
+	if !exists {
+		if nodes > 0 {
+			return nil, e.createPoolScaleSet(pool, nodes)
+		}
+		return nil, nil
+	}
+
+	before, err := e.getScaleSetAddresses(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := e.getScaleSetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.GetInfo().Printf("setting capacity of scale set %s to %v workers", name, nodes)
+	ctx := context.Background()
+	future, err := cli.Update(ctx, e.ResourceGroup, name, compute.VirtualMachineScaleSetUpdate{
+		Sku: &compute.Sku{
+			Name:     to.StringPtr(string(pool.VMSize)),
+			Tier:     to.StringPtr("Standard"),
+			Capacity: to.Int64Ptr(nodes),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = future.WaitForCompletionRef(ctx, cli.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := e.getScaleSetAddresses(name)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]bool)
+	for _, el := range after {
+		remaining[el] = true
+	}
+
+	var removed []string
+	for _, el := range before {
+		if !remaining[el] {
+			removed = append(removed, el)
+		}
+	}
+	return removed, nil
+}
+
+// createPoolScaleSet - creates the scale set of a pool of a running cluster
+func (e *AzureEnvironment) createPoolScaleSet(pool AzureWorkerPool, nodes int64) error {
+	image, err := e.resolveImage()
+	if err != nil {
+		return err
+	}
+	e.image = image
+
+	masterIP, err := e.getNICAddress(e.ClusterID + "-master")
+	if err != nil {
+		return err
+	}
+
+	pool.Nodes = nodes
+	launch := e.workerLaunches(masterIP, pool)[0]
+	err = e.createScaleSet(launch)
+	if err != nil {
+		e.deleteWorker(launch.name)
+	}
+	return err
+}
@@ -2,9 +2,20 @@ package cloud
 
 import (
 	"allspark/util/serializer"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
 )
 
 const (
@@ -33,8 +44,8 @@ func TestCreateListDeleteDisks(t *testing.T) {
 	client2 := getClient(t, azureClusterTemplatePath).(*AzureEnvironment)
 	client2.ClusterID = "azure-cluster-2"
 
-	client1.createDisk(client1.ClusterID)
-	client2.createDisk(client2.ClusterID)
+	client1.createDisk(client1.ClusterID, client1.DiskSizeGB)
+	client2.createDisk(client2.ClusterID, client2.DiskSizeGB)
 
 	items, err := client1.getDisks()
 	if err != nil {
@@ -270,3 +281,437 @@ func TestCreateAndDestroySingleNodeAzureCluster(t *testing.T) {
 		t.Error("- got " + strconv.Itoa(int(actualNodeCount)) + " spark nodes.")
 	}
 }
+
+func TestProvisionConcurrently(t *testing.T) {
+	var running, peak int32
+	errs := provisionConcurrently(10, 3, func(idx int) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			prior := atomic.LoadInt32(&peak)
+			if current <= prior || atomic.CompareAndSwapInt32(&peak, prior, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if idx%4 == 0 {
+			return errors.New("launch failed")
+		}
+		return nil
+	})
+
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent launches, got %v", peak)
+	}
+
+	var failed int
+	for idx, el := range errs {
+		if (el != nil) != (idx%4 == 0) {
+			t.Errorf("unexpected error of launch %v: %v", idx, el)
+		}
+		if el != nil {
+			failed++
+		}
+	}
+	if len(errs) != 10 || failed != 3 {
+		t.Errorf("expected 3 of 10 launches to fail, got %v of %v", failed, len(errs))
+	}
+}
+
+func TestAzureWorkerLaunches(t *testing.T) {
+	spec := AzureEnvironment{ClusterID: "c1", VMSize: "Standard_D4s_v3", DiskSizeGB: 64}
+	spec.WorkerPools = []AzureWorkerPool{
+		{WorkerPool: WorkerPool{Name: "gpu", Nodes: 2}, VMSize: "Standard_NC6"},
+	}
+
+	launches := spec.workerLaunches("10.0.0.4", spec.workerPools()[0])
+	if len(launches) != 2 || launches[1].name != "c1-gpu-worker-1" ||
+		launches[0].shape.VMSize != "Standard_NC6" || launches[0].shape.DiskSizeGB != 64 ||
+		launches[0].config[0] != "MASTER_IP=10.0.0.4" ||
+		launches[0].config[len(launches[0].config)-1] != "WORKER_POOL=gpu" {
+		t.Errorf("unexpected worker launches: %+v", launches)
+	}
+
+	_, _, err := spec.nodeConfig(launches[0].tags, launches[0].config)
+	if err == nil {
+		t.Error("expected nodes without custom data to require ConfigTags")
+	}
+
+	spec.ConfigTags = true
+	tags, _, err := spec.nodeConfig(launches[0].tags, launches[0].config)
+	if err != nil || *tags["MASTER_IP"] != "10.0.0.4" || *tags["CLUSTER_ID"] != "c1" {
+		t.Errorf("expected nodes without custom data to carry their configuration as tags: %v", tags)
+	}
+}
+
+func TestAzurePoolVMIndices(t *testing.T) {
+	vms := []string{"c1-master", "c1-worker-2", "c1-worker-0", "c1-gpu-worker-0", "c1-worker-5"}
+
+	indices := poolVMIndices("c1-worker", vms)
+	if len(indices) != 3 || indices[0] != 0 || indices[1] != 2 || indices[2] != 5 {
+		t.Errorf("unexpected pool VM indices: %v", indices)
+	}
+
+	free := freePoolVMIndices(indices, 3)
+	if len(free) != 3 || free[0] != 1 || free[1] != 3 || free[2] != 4 {
+		t.Errorf("unexpected free VM indices: %v", free)
+	}
+
+	spec := AzureEnvironment{ClusterID: "c1", WorkerPools: []AzureWorkerPool{
+		{WorkerPool: WorkerPool{Name: "gpu", Nodes: 2}, VMSize: "Standard_NC6"},
+	}}
+	if err := spec.SetPoolNodes("gpu", 4); err != nil || spec.WorkerPools[0].Nodes != 4 {
+		t.Errorf("unexpected pools after resize: %+v, %v", spec.WorkerPools, err)
+	}
+}
+
+func TestAzureImages(t *testing.T) {
+	newVersion := func(name string, state compute.ProvisioningState3, exclude bool) compute.GalleryImageVersion {
+		return compute.GalleryImageVersion{
+			Name: to.StringPtr(name),
+			ID:   to.StringPtr("/versions/" + name),
+			GalleryImageVersionProperties: &compute.GalleryImageVersionProperties{
+				ProvisioningState: state,
+				PublishingProfile: &compute.GalleryImageVersionPublishingProfile{
+					ExcludeFromLatest: to.BoolPtr(exclude),
+				},
+			},
+		}
+	}
+
+	versions := []compute.GalleryImageVersion{
+		newVersion("1.2.0", compute.ProvisioningState3Succeeded, false),
+		newVersion("1.10.0", compute.ProvisioningState3Succeeded, false),
+		newVersion("2.0.0", compute.ProvisioningState3Succeeded, true),
+		newVersion("1.11.0", compute.ProvisioningState3Creating, false),
+	}
+
+	latest, err := latestGalleryImageVersion(versions)
+	if err != nil || *latest.Name != "1.10.0" {
+		t.Errorf("expected version 1.10.0 to be the latest, got %v", latest.Name)
+	}
+
+	_, err = latestGalleryImageVersion(versions[2:])
+	if err == nil {
+		t.Error("expected no version to be resolved")
+	}
+
+	gallery := &AzureGalleryImage{Gallery: "allspark", Definition: "spark"}
+	valid := []error{
+		ValidateAzureImage("", nil, ""),
+		ValidateAzureImage("/images/spark", nil, "ssh-rsa AAAA"),
+		ValidateAzureImage("", gallery, "ssh-rsa AAAA"),
+		ValidateAzureImage("", gallery, ""),
+	}
+	for idx, el := range valid {
+		if el != nil {
+			t.Errorf("expected validation %v to pass: %v", idx, el)
+		}
+	}
+
+	invalid := []error{
+		ValidateAzureImage("/images/spark", gallery, "ssh-rsa AAAA"),
+		ValidateAzureImage("", &AzureGalleryImage{Gallery: "allspark"}, "ssh-rsa AAAA"),
+		ValidateAzureImage("/images/spark", nil, ""),
+	}
+	for idx, el := range invalid {
+		if el == nil {
+			t.Errorf("expected validation %v to fail", idx)
+		}
+	}
+
+	profile := imageStorageProfile("c1-master", &azureImage{ID: "/images/spark"}, 64)
+	if *profile.ImageReference.ID != "/images/spark" || *profile.OsDisk.DiskSizeGB != 64 ||
+		profile.OsDisk.CreateOption != compute.DiskCreateOptionTypesFromImage {
+		t.Errorf("unexpected storage profile: %+v", profile)
+	}
+}
+
+func TestAzureScaleSets(t *testing.T) {
+	spec := AzureEnvironment{
+		ClusterID:    "c1",
+		Region:       "westus2",
+		VMSize:       "Standard_D4s_v3",
+		DiskSizeGB:   64,
+		WorkerNodes:  3,
+		SSHPublicKey: "ssh-rsa AAAA",
+		ScaleSet:     &AzureScaleSet{Spot: true},
+		image:        &azureImage{ID: "/images/spark", Generalized: true},
+	}
+
+	launches := spec.workerLaunches("10.0.0.4", spec.workerPools()[0])
+	if len(launches) != 1 || launches[0].name != "c1-worker" || launches[0].nodes != 3 {
+		t.Errorf("expected a single scale set launch: %+v", launches)
+	}
+
+	scaleSet, err := spec.scaleSetParameters(launches[0], "/subnets/spark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := scaleSet.VirtualMachineProfile
+	if *scaleSet.Sku.Capacity != 3 || *scaleSet.Sku.Name != "Standard_D4s_v3" ||
+		scaleSet.Tags["MASTER_IP"] != nil || *profile.StorageProfile.ImageReference.ID != "/images/spark" ||
+		*profile.OsProfile.CustomData != customData(launches[0].config) {
+		t.Errorf("unexpected scale set: %+v", scaleSet)
+	}
+
+	if profile.Priority != compute.Spot || profile.EvictionPolicy != compute.Delete ||
+		*profile.BillingProfile.MaxPrice != -1 || profile.OsProfile == nil {
+		t.Errorf("unexpected spot profile: %+v", profile)
+	}
+
+	if ValidateAzureScaleSet(spec.ScaleSet, true) != nil || ValidateAzureScaleSet(nil, false) != nil {
+		t.Error("expected scale set options to be valid")
+	}
+	if ValidateAzureScaleSet(spec.ScaleSet, false) == nil ||
+		ValidateAzureScaleSet(&AzureScaleSet{EvictionPolicy: "Stop"}, true) == nil {
+		t.Error("expected invalid scale set options to fail validation")
+	}
+}
+
+// fakeScaleSetAPI - an in-memory Resource Manager holding the capacity of
+// a single scale set whose instances have consecutive private IPs
+type fakeScaleSetAPI struct {
+	name     string
+	capacity int64
+	sku      compute.Sku
+}
+
+func (f *fakeScaleSetAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scaleSets := "/subscriptions/s1/resourceGroups/spark/providers/Microsoft.Compute/virtualMachineScaleSets"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == scaleSets:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value": []map[string]string{{"name": f.name}},
+		})
+	case r.Method == http.MethodGet && strings.EqualFold(r.URL.Path, scaleSets+"/"+f.name+"/networkInterfaces"):
+		var nics []map[string]interface{}
+		for i := int64(0); i < f.capacity; i++ {
+			nics = append(nics, map[string]interface{}{
+				"properties": map[string]interface{}{
+					"ipConfigurations": []map[string]interface{}{
+						{"properties": map[string]string{"privateIPAddress": "10.0.0." + strconv.FormatInt(4+i, 10)}},
+					},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"value": nics})
+	case r.Method == http.MethodPatch && r.URL.Path == scaleSets+"/"+f.name:
+		var update compute.VirtualMachineScaleSetUpdate
+		json.NewDecoder(r.Body).Decode(&update)
+		f.sku = *update.Sku
+		f.capacity = to.Int64(update.Sku.Capacity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":       f.name,
+			"sku":        f.sku,
+			"properties": map[string]string{"provisioningState": "Succeeded"},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "NotFound"}}`))
+	}
+}
+
+func TestAzureScaleSetResize(t *testing.T) {
+	api := &fakeScaleSetAPI{name: "c1-worker", capacity: 3}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	priorURI, priorAuthorizer := resourceManagerURI, servicePrincipalAuthorizer
+	resourceManagerURI = server.URL
+	servicePrincipalAuthorizer = func(string, string, string) (autorest.Authorizer, error) {
+		return autorest.NullAuthorizer{}, nil
+	}
+	defer func() { resourceManagerURI, servicePrincipalAuthorizer = priorURI, priorAuthorizer }()
+
+	spec := AzureEnvironment{
+		ClusterID:      "c1",
+		SubscriptionID: "s1",
+		ResourceGroup:  "spark",
+		VMSize:         "Standard_D4s_v3",
+		WorkerNodes:    3,
+		ScaleSet:       &AzureScaleSet{},
+	}
+
+	removed, err := spec.ResizePool(DefaultWorkerPool, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if to.Int64(api.sku.Capacity) != 1 || to.String(api.sku.Name) != "Standard_D4s_v3" {
+		t.Errorf("unexpected scale set sku: %+v", api.sku)
+	}
+	if len(removed) != 2 || removed[0] != "10.0.0.5" || removed[1] != "10.0.0.6" {
+		t.Errorf("unexpected removed workers: %v", removed)
+	}
+	if spec.WorkerNodes != 1 {
+		t.Errorf("expected the pool to have 1 node, got %v", spec.WorkerNodes)
+	}
+
+	removed, err = spec.ResizePool(DefaultWorkerPool, 4)
+	if err != nil || len(removed) != 0 || api.capacity != 4 || spec.WorkerNodes != 4 {
+		t.Errorf("unexpected growth of the scale set to %v nodes, removed %v: %v",
+			api.capacity, removed, err)
+	}
+}
+
+func TestAzureCustomData(t *testing.T) {
+	config := []string{"CLUSTER_ID=c1", "SPARK_OPTS=--conf a=b", "DATA_STORAGE_KEY=secret"}
+
+	buffer, err := b64.StdEncoding.DecodeString(customData(config))
+	if err != nil || string(buffer) != "CLUSTER_ID='c1'\nSPARK_OPTS='--conf a=b'\nDATA_STORAGE_KEY='secret'\n" {
+		t.Errorf("unexpected custom data: %s", buffer)
+	}
+
+	// nodes source the custom data, so values must not be interpreted
+	buffer, err = b64.StdEncoding.DecodeString(customData([]string{`HOSTILE=a'"$(reboot)"; ` + "`id`"}))
+	if err != nil || string(buffer) != `HOSTILE='a'"'"'"$(reboot)"; `+"`id`'\n" {
+		t.Errorf("unexpected quoting of custom data: %s", buffer)
+	}
+
+	labels := map[string]*string{"tier": to.StringPtr("batch")}
+	tags, err := configTags(labels, config)
+	if err != nil || len(tags) != 4 || *tags["SPARK_OPTS"] != "--conf a=b" || len(labels) != 1 {
+		t.Errorf("unexpected configuration tags: %v", tags)
+	}
+
+	_, err = configTags(labels, []string{"SPARK_OPTS=" + strings.Repeat("a", maxAzureTagValueLength+1)})
+	if err == nil {
+		t.Error("expected values longer than a tag to be rejected")
+	}
+
+	spec := AzureEnvironment{image: &azureImage{ID: "/images/spark", Generalized: true}}
+	tags, resolved, err := spec.nodeConfig(labels, config)
+	if err != nil || len(tags) != 1 || len(resolved) != 3 {
+		t.Errorf("expected nodes receiving custom data to only carry their labels: %v", tags)
+	}
+
+	if ValidateEnvParams([]string{"SPARK_OPTS=--conf a=b", "EMPTY="}) != nil {
+		t.Error("expected environment parameters to be valid")
+	}
+	if ValidateEnvParams([]string{"SPARK_OPTS"}) == nil || ValidateEnvParams([]string{"1X=a"}) == nil {
+		t.Error("expected invalid environment parameters to fail validation")
+	}
+}
+
+func TestAzureSampleTemplates(t *testing.T) {
+	for _, path := range []string{azureClusterTemplatePath, azureSingleNodeTemplatePath} {
+		var spec AzureEnvironment
+		err := serializer.DeserializePath(path, &spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ValidateManagedIdentities(spec.ManagedIdentities, spec.StorageIdentity)
+		if err == nil {
+			err = ValidateNodeConfig(spec.usesImage(), spec.ConfigTags)
+		}
+		if err != nil {
+			t.Errorf("expected %v to be valid: %v", path, err)
+		}
+
+		// sample nodes boot from a VHD and receive their configuration as tags
+		storage, err := spec.storageConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = configTags(nil, append(storage, spec.EnvParams...))
+		if err != nil {
+			t.Errorf("expected the configuration of %v to be delivered as tags: %v", path, err)
+		}
+	}
+
+	if ValidateNodeConfig(false, false) == nil {
+		t.Error("expected VHD nodes without ConfigTags to fail validation")
+	}
+	if ValidateNodeConfig(true, false) != nil || ValidateNodeConfig(false, true) != nil {
+		t.Error("expected the node configuration to be valid")
+	}
+}
+
+func TestAzureKeyVault(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/secrets/client-secret/":
+			w.Write([]byte(`{"value": "s3cr3t", "id": "client-secret"}`))
+		case "/secrets/storage-token/v2":
+			w.Write([]byte(`{"value": "token-v2", "id": "storage-token"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "SecretNotFound"}}`))
+		}
+	}))
+	defer server.Close()
+
+	prior := keyVaultAuthorizer
+	keyVaultAuthorizer = func() (autorest.Authorizer, error) {
+		return autorest.NullAuthorizer{}, nil
+	}
+	defer func() { keyVaultAuthorizer = prior }()
+
+	reference := "@Microsoft.KeyVault(SecretUri=" + server.URL + "/secrets/client-secret)"
+	for i := 0; i < 2; i++ {
+		value, err := resolveSecret(reference)
+		if err != nil || value != "s3cr3t" {
+			t.Errorf("unexpected secret %v: %v", value, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the resolved secret to be cached, got %v requests", requests)
+	}
+
+	config, err := resolveConfig([]string{"CLUSTER_ID=c1",
+		"STORAGE_TOKEN=@Microsoft.KeyVault(SecretUri=" + server.URL + "/secrets/storage-token/v2)"})
+	if err != nil || config[0] != "CLUSTER_ID=c1" || config[1] != "STORAGE_TOKEN=token-v2" {
+		t.Errorf("unexpected configuration %v: %v", config, err)
+	}
+
+	_, err = resolveSecret("@Microsoft.KeyVault(SecretUri=" + server.URL + "/secrets/missing)")
+	if err == nil {
+		t.Error("expected missing secrets to fail")
+	}
+
+	if value, err := resolveSecret("plain"); err != nil || value != "plain" {
+		t.Errorf("expected plain values to be returned as is: %v", value)
+	}
+
+	if ValidateSecretReference(reference) != nil || ValidateSecretReference("plain") != nil {
+		t.Error("expected secret references to be valid")
+	}
+	if ValidateSecretReference("@Microsoft.KeyVault(SecretUri=https://vault/keys/a)") == nil ||
+		ValidateSecretReference("@Microsoft.KeyVault(https://vault/secrets/a)") == nil {
+		t.Error("expected invalid secret references to fail validation")
+	}
+
+	_, err = configTags(nil, []string{"STORAGE_TOKEN=" + reference})
+	if err == nil {
+		t.Error("expected Key Vault references to be rejected as tags")
+	}
+}
+
+func TestAzureManagedIdentities(t *testing.T) {
+	identity := "/subscriptions/s/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/spark"
+	spec := AzureEnvironment{ManagedIdentities: []string{identity}, StorageIdentity: identity}
+
+	buffer, err := json.Marshal(spec.vmIdentity())
+	if err != nil || string(buffer) != `{"type":"UserAssigned","userAssignedIdentities":{"`+identity+`":{}}}` {
+		t.Errorf("unexpected VM identity: %s", buffer)
+	}
+
+	if spec.scaleSetIdentity().Type != compute.ResourceIdentityTypeUserAssigned ||
+		(&AzureEnvironment{}).vmIdentity() != nil {
+		t.Error("unexpected scale set identity")
+	}
+
+	if ValidateManagedIdentities(spec.ManagedIdentities, spec.StorageIdentity) != nil ||
+		ValidateManagedIdentities(nil, "") != nil {
+		t.Error("expected managed identities to be valid")
+	}
+	if ValidateManagedIdentities(nil, identity) == nil {
+		t.Error("expected a storage identity which is not attached to fail validation")
+	}
+}
@@ -1,13 +1,20 @@
 package cloud
 
 import (
+	"allspark/daemon"
 	"allspark/logger"
 	"allspark/util/serializer"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -40,7 +47,9 @@ type SparkApp struct {
 	Duration       uint64 `json:"duration"`
 }
 
-// SparkClusterStatus describes the entire spark cluster state
+// SparkClusterStatus describes the entire spark cluster state; RestPort
+// is reported by masters whose REST submission gateway does not listen on
+// the default port
 type SparkClusterStatus struct {
 	URL           string        `json:"url"`
 	Workers       []SparkWorker `json:"workers"`
@@ -52,22 +61,129 @@ type SparkClusterStatus struct {
 	ActiveApps    []SparkApp    `json:"activeapps"`
 	CompletedApps []SparkApp    `json:"completedapps"`
 	Status        string        `json:"status"`
+	RestPort      int           `json:"restport,omitempty"`
 }
 
-// SparkStatusCheckIn - form body for the /checkin endpoint
+// SparkAppResult describes the outcome of a spark application; AppState
+// is the state reported by the spark master, while ExitCode and
+// DriverState are only known for drivers which report them
+type SparkAppResult struct {
+	AppID         string
+	Name          string
+	AppState      string `json:",omitempty"`
+	ExitCode      int
+	FailureReason string
+	DriverState   string `json:",omitempty"`
+	StartTime     int64
+	EndTime       int64
+}
+
+// AppStateFinished - state of spark applications which completed successfully
+const AppStateFinished = "FINISHED"
+
+// NodeInterruption describes a termination notice received by a
+// cluster node, e.g. the interruption of a spot instance
+type NodeInterruption struct {
+	InstanceID string
+	Action     string
+	Time       string
+}
+
+// WorkerRegistration describes the worker pool of a worker node; Host
+// is the address the node's spark worker reports to the master
+type WorkerRegistration struct {
+	Pool string
+	Host string
+}
+
+// SparkStatusCheckIn - form body for the /checkin endpoint; check-ins of
+// worker nodes only report their pool or interruptions and carry no
+// spark status
 type SparkStatusCheckIn struct {
 	Status        SparkClusterStatus
 	AppExitStatus string
+	AppResults    []SparkAppResult
 	ClusterID     string
+	Interruption  *NodeInterruption   `json:",omitempty"`
+	Worker        *WorkerRegistration `json:",omitempty"`
+}
+
+// Failed - returns true if the application exited unsuccessfully
+func (r SparkAppResult) Failed() bool {
+	return r.ExitCode != 0 || len(r.FailureReason) > 0 ||
+		(len(r.AppState) > 0 && r.AppState != AppStateFinished) ||
+		(IsDriverStateTerminal(r.DriverState) && r.DriverState != DriverStateFinished)
+}
+
+// RetryPolicy describes how a failed cluster is relaunched
+type RetryPolicy struct {
+	MaxAttempts      int
+	BackoffSeconds   int64
+	RetryableReasons []string
+}
+
+// Cluster failure reasons
+const (
+	FailureMissedCheckIn  = "missed_checkin"
+	FailurePendingTimeout = "pending_timeout"
+	FailureMaxRuntime     = "max_runtime"
+	FailureAppFailure     = "app_failure"
+	FailureLaunch         = "launch_failure"
+	FailureBudgetExceeded = "budget_exceeded"
+	FailureInterrupted    = "spot_interruption"
+)
+
+// limits of retry policies; MaxRetryBackoffSeconds also caps the doubled
+// backoff of later attempts
+const (
+	MaxRetryAttempts       = 10
+	MaxRetryBackoffSeconds = 24 * 60 * 60
+)
+
+// ValidateRetryPolicy - verifies the attempts and backoff of the policy are
+// within bounds and its reasons are known failure reasons
+func ValidateRetryPolicy(policy *RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.MaxAttempts < 1 || policy.MaxAttempts > MaxRetryAttempts {
+		return errors.New("retry policy MaxAttempts must be between 1 and " +
+			strconv.Itoa(MaxRetryAttempts))
+	}
+
+	if policy.BackoffSeconds < 0 || policy.BackoffSeconds > MaxRetryBackoffSeconds {
+		return errors.New("retry policy BackoffSeconds must be between 0 and " +
+			strconv.Itoa(MaxRetryBackoffSeconds))
+	}
+
+	for _, el := range policy.RetryableReasons {
+		switch el {
+		case FailureMissedCheckIn, FailurePendingTimeout, FailureMaxRuntime,
+			FailureAppFailure, FailureLaunch, FailureBudgetExceeded, FailureInterrupted:
+		default:
+			return errors.New("unknown retryable reason " + el)
+		}
+	}
+	return nil
 }
 
 // Supported cloud environments
 const (
-	Aws    = "aws"
-	Azure  = "azure"
-	Docker = "docker"
+	Aws        = "aws"
+	Azure      = "azure"
+	Docker     = "docker"
+	Kubernetes = "kubernetes"
+	Static     = "static"
+	Local      = "local"
 )
 
+// DockerInstanceType - instance type reported for docker nodes
+const DockerInstanceType = "docker"
+
+// hours per month used to convert monthly disk prices to hourly prices
+const hoursPerMonth = 730
+
 const (
 	masterIdentifier = "-master"
 	workerIdentifier = "-worker"
@@ -76,14 +192,218 @@ const (
 	aliveWorkers     = "Alive Workers:"
 )
 
+// DefaultWorkerPool - name of the worker pool of templates which do not
+// declare worker pools
+const DefaultWorkerPool = "default"
+
+// NodeGroup - nodes of a cluster sharing an instance type;
+// DiskGB is the disk size of each node and Pool the worker pool
+// of the nodes, which is empty for the master
+type NodeGroup struct {
+	InstanceType string
+	Nodes        int64
+	DiskGB       int64
+	Pool         string `json:",omitempty"`
+}
+
+// WorkerPool - a named group of worker nodes within a cluster; Labels
+// are applied to the pool's nodes as tags and EnvParams are passed to
+// the pool's nodes in addition to those of the template
+type WorkerPool struct {
+	Name      string
+	Nodes     int64
+	Labels    map[string]string `json:",omitempty"`
+	EnvParams []string          `json:",omitempty"`
+}
+
+var workerPoolName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?$`)
+
+// ClusterResources - compute resources requested by a cluster;
+// ShapeKnown is false if an instance type is missing from the
+// configured instance shapes, in which case VCPU and MemoryGB are zero
+type ClusterResources struct {
+	Nodes      int64
+	VCPU       float64
+	MemoryGB   float64
+	ShapeKnown bool
+	Groups     []NodeGroup
+}
+
 // CloudEnvironment base interface
 type CloudEnvironment interface {
 	CreateCluster() (string, error)
 	DestroyCluster() error
 	DestructionConfirmed() bool
+	GetResources() ClusterResources
 	getClusterNodes() ([]string, error)
 }
 
+// PoolResizer - implemented by cloud environments whose worker pools can
+// be resized while the cluster runs. SetPoolNodes only records the node
+// count of a pool in the template; ResizePool launches or removes workers,
+// records the resulting node count and returns the addresses of the
+// workers it removed, where known
+type PoolResizer interface {
+	SetPoolNodes(pool string, nodes int64) error
+	ResizePool(pool string, nodes int64) ([]string, error)
+}
+
+// newClusterResources - totals the resources of the node groups
+// using the configured instance shapes; empty groups are omitted
+func newClusterResources(groups ...NodeGroup) ClusterResources {
+	resources := ClusterResources{ShapeKnown: true}
+	shapes := daemon.GetAllSparkConfig().InstanceShapes
+	for _, el := range groups {
+		if el.Nodes <= 0 {
+			continue
+		}
+
+		resources.Groups = append(resources.Groups, el)
+		resources.Nodes += el.Nodes
+		shape, ok := shapes[el.InstanceType]
+		if !ok {
+			resources.ShapeKnown = false
+			continue
+		}
+		resources.VCPU += shape.VCPU * float64(el.Nodes)
+		resources.MemoryGB += shape.MemoryGB * float64(el.Nodes)
+	}
+
+	if !resources.ShapeKnown {
+		resources.VCPU = 0
+		resources.MemoryGB = 0
+	}
+
+	return resources
+}
+
+// ValidateWorkerPools - verifies the worker pools of a template; templates
+// declaring worker pools specify their worker nodes in the pools only
+func ValidateWorkerPools(pools []WorkerPool, workerNodes int64) error {
+	if len(pools) > 0 && workerNodes != 0 {
+		return errors.New("WorkerNodes must not be set when WorkerPools are declared")
+	}
+
+	names := make(map[string]bool)
+	for _, el := range pools {
+		if !workerPoolName.MatchString(el.Name) {
+			return errors.New("worker pool name " + el.Name + " must consist of up to 20 " +
+				"lower case alphanumeric characters or '-'")
+		}
+
+		if names[el.Name] {
+			return errors.New("worker pool " + el.Name + " is declared more than once")
+		}
+		names[el.Name] = true
+
+		if el.Nodes < 0 {
+			return errors.New("worker pool " + el.Name + " has a negative node count")
+		}
+	}
+
+	return nil
+}
+
+// poolIdentifier - returns the name prefix of the nodes of a worker pool;
+// nodes of the default pool keep the names of clusters without pools
+func poolIdentifier(clusterID string, pool string) string {
+	if pool == DefaultWorkerPool {
+		return clusterID + workerIdentifier
+	}
+	return clusterID + "-" + pool + workerIdentifier
+}
+
+// matches the names of environment variables
+var envParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnvParams - verifies each param is of the form NAME=VALUE
+func ValidateEnvParams(params []string) error {
+	for _, el := range params {
+		buff := strings.SplitN(el, "=", 2)
+		if len(buff) != 2 || !envParamName.MatchString(buff[0]) {
+			return errors.New("environment parameter " + el + " must be of the form NAME=VALUE")
+		}
+	}
+	return nil
+}
+
+// poolEnvParams - returns the environment of the nodes of a worker pool
+func poolEnvParams(envParams []string, pool WorkerPool) []string {
+	result := append([]string{}, envParams...)
+	result = append(result, pool.EnvParams...)
+	return append(result, "WORKER_POOL="+pool.Name)
+}
+
+// computeExecutorMemory - returns the spark executor memory in GB of
+// workers with the specified memory, reserving 1GB for the worker daemon
+func computeExecutorMemory(memoryGB int64) string {
+	return strconv.FormatInt(memoryGB-1, 10)
+}
+
+// getExecutorMemory - returns the spark executor memory of workers of the
+// instance types, sized to fit the smallest of them; the memory of
+// instance types missing from InstanceShapes is described by the cloud
+func getExecutorMemory(describeMemoryGB func(string) (float64, error),
+	instanceTypes ...string) (string, error) {
+
+	if len(instanceTypes) == 0 {
+		return "", nil
+	}
+
+	shapes := daemon.GetAllSparkConfig().InstanceShapes
+
+	var memoryGB float64
+	for idx, el := range instanceTypes {
+		shape, ok := shapes[el]
+		if !ok {
+			described, err := describeMemoryGB(el)
+			if err != nil {
+				return "", fmt.Errorf("unable to determine the memory of instance type %v: %w", el, err)
+			}
+			shape.MemoryGB = described
+		}
+		if idx == 0 || shape.MemoryGB < memoryGB {
+			memoryGB = shape.MemoryGB
+		}
+	}
+
+	return computeExecutorMemory(int64(memoryGB)), nil
+}
+
+// HourlyCost - returns the estimated hourly cost of the resources using
+// the configured pricing catalog; priced is false if an instance type
+// is missing from the catalog, in which case its nodes are not included
+func HourlyCost(resources ClusterResources) (float64, bool) {
+	pricing := daemon.GetAllSparkConfig().Pricing
+
+	cost, priced := 0.0, true
+	for _, el := range resources.Groups {
+		rate, ok := pricing.InstanceHourly[el.InstanceType]
+		if el.InstanceType == DockerInstanceType {
+			rate, ok = pricing.DockerNodeHourly, true
+		}
+		if el.InstanceType == KubernetesInstanceType {
+			rate, ok = pricing.KubernetesPodHourly, true
+		}
+		if el.InstanceType == StaticInstanceType {
+			rate, ok = pricing.StaticHostHourly, true
+		}
+		if el.InstanceType == LocalInstanceType {
+			rate, ok = 0, true
+		}
+
+		if !ok {
+			priced = false
+			continue
+		}
+
+		diskRate := float64(el.DiskGB) * pricing.DiskGBMonthly / hoursPerMonth
+		cost += (rate + diskRate) * float64(el.Nodes)
+	}
+
+	return cost, priced
+}
+
 func waitForCluster(sparkWebURL string, expectedWorkerCount int,
 	retryAttempts int) error {
 
@@ -131,7 +451,74 @@ func ReadTemplateConfiguration(path string) ([]byte, error) {
 	return ioutil.ReadAll(template)
 }
 
-// Create a cloud environment (e.g. AWS, Docker, Azure, etc..)
+// SetClusterID - returns a copy of the serialized cluster
+// configuration with the ClusterID replaced
+func SetClusterID(clusterConfiguration []byte, clusterID string) ([]byte, error) {
+	var template map[string]interface{}
+	err := json.Unmarshal(clusterConfiguration, &template)
+	if err != nil {
+		return nil, err
+	}
+
+	if template == nil {
+		return nil, errors.New("cluster configuration is empty")
+	}
+
+	template["ClusterID"] = clusterID
+	return json.Marshal(template)
+}
+
+// fields recorded in a cluster configuration when the cluster is created
+var launchFields = []string{"LaunchSubnetID"}
+
+// TemplateHash - returns a digest of a serialized cluster configuration
+// which is independent of its ClusterID and of where the cluster was
+// launched; clusters launched from the same template share the same digest
+func TemplateHash(clusterConfiguration []byte) (string, error) {
+	var template map[string]interface{}
+	err := json.Unmarshal(clusterConfiguration, &template)
+	if err != nil {
+		return "", err
+	}
+
+	if template == nil {
+		return "", errors.New("cluster configuration is empty")
+	}
+
+	template["ClusterID"] = ""
+	for _, el := range launchFields {
+		delete(template, el)
+	}
+
+	buffer, err := json.Marshal(template)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(buffer)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// GetClusterID - returns the ClusterID of a serialized cluster configuration
+func GetClusterID(clusterConfiguration []byte) (string, error) {
+	var template struct {
+		ClusterID string
+	}
+	err := json.Unmarshal(clusterConfiguration, &template)
+	return template.ClusterID, err
+}
+
+// GetRetryPolicy - returns the retry policy of a serialized cluster
+// configuration, or nil if none is specified
+func GetRetryPolicy(clusterConfiguration []byte) (*RetryPolicy, error) {
+	var template struct {
+		RetryPolicy *RetryPolicy
+	}
+	err := json.Unmarshal(clusterConfiguration, &template)
+	return template.RetryPolicy, err
+}
+
+// Create a cloud environment (e.g. AWS, Docker, Azure, Kubernetes, etc..)
 func Create(environment string, clusterConfiguration []byte) (CloudEnvironment, error) {
 	switch environment {
 	case Aws:
@@ -146,6 +533,18 @@ func Create(environment string, clusterConfiguration []byte) (CloudEnvironment,
 		var result DockerEnvironment
 		err := json.Unmarshal(clusterConfiguration, &result)
 		return &result, err
+	case Kubernetes:
+		var result KubernetesEnvironment
+		err := json.Unmarshal(clusterConfiguration, &result)
+		return &result, err
+	case Static:
+		var result StaticEnvironment
+		err := json.Unmarshal(clusterConfiguration, &result)
+		return &result, err
+	case Local:
+		var result LocalEnvironment
+		err := json.Unmarshal(clusterConfiguration, &result)
+		return &result, err
 	}
 
 	return nil, errors.New("invalid cloud-environment " + environment)
@@ -0,0 +1,267 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestSetClusterID(t *testing.T) {
+	template, err := ReadTemplateConfiguration(dockerClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := SetClusterID(template, "run-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterID, err := GetClusterID(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clusterID != "run-test" {
+		t.Error("cluster id mismatch")
+		t.Error("-expected: run-test")
+		t.Error("-actual: " + clusterID)
+	}
+
+	client, err := Create(Docker, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.(*DockerEnvironment).WorkerNodes != 3 {
+		t.Error("expected template parameters to be preserved")
+	}
+
+	_, err = SetClusterID([]byte("null"), "run-test")
+	if err == nil {
+		t.Error("expected non-nil error for empty configuration")
+	}
+}
+
+func TestGetResources(t *testing.T) {
+	template, err := ReadTemplateConfiguration(dockerClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Create(Docker, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := client.GetResources()
+	expected := ClusterResources{Nodes: 4, VCPU: 4, MemoryGB: 4, ShapeKnown: true}
+	if resources.Nodes != expected.Nodes || resources.VCPU != expected.VCPU ||
+		resources.MemoryGB != expected.MemoryGB || !resources.ShapeKnown {
+		t.Error("resources mismatch")
+		t.Errorf("-expected: %+v", expected)
+		t.Errorf("-actual: %+v", resources)
+	}
+
+	resources = newClusterResources(NodeGroup{InstanceType: "does-not-exist", Nodes: 3})
+	if resources.ShapeKnown || resources.Nodes != 3 || len(resources.Groups) != 1 {
+		t.Errorf("unexpected resources for unknown instance type: %+v", resources)
+	}
+}
+
+func TestHourlyCost(t *testing.T) {
+	daemon.Init("../daemon/allspark_config.json")
+
+	cost, priced := HourlyCost(ClusterResources{Groups: []NodeGroup{
+		{InstanceType: "m5.2xlarge", Nodes: 3, DiskGB: 73},
+		{InstanceType: DockerInstanceType, Nodes: 2},
+	}})
+	expected := 3*(0.384+0.01) + 2*0.01
+	if !priced || math.Abs(cost-expected) > 1e-9 {
+		t.Error("hourly cost mismatch")
+		t.Errorf("-expected: %v", expected)
+		t.Errorf("-actual: %v", cost)
+	}
+
+	cost, priced = HourlyCost(ClusterResources{Groups: []NodeGroup{
+		{InstanceType: "does-not-exist", Nodes: 3},
+		{InstanceType: "Standard_D2s_v3", Nodes: 1},
+	}})
+	if priced || math.Abs(cost-0.096) > 1e-9 {
+		t.Errorf("unexpected cost for unknown instance type: %v, %v", cost, priced)
+	}
+}
+
+func TestGetExecutorMemory(t *testing.T) {
+	daemon.Init("../daemon/allspark_config.json")
+
+	described := map[string]float64{"r5.large": 16}
+	describe := func(instanceType string) (float64, error) {
+		if memoryGB, ok := described[instanceType]; ok {
+			return memoryGB, nil
+		}
+		return 0, errors.New("instance type not found")
+	}
+
+	tests := []struct {
+		instanceTypes []string
+		expected      string
+		valid         bool
+	}{
+		{[]string{"m5.2xlarge"}, "31", true},
+		{[]string{"m5.2xlarge", "Standard_D2s_v3"}, "7", true},
+		{[]string{"r5.large"}, "15", true},
+		{[]string{"m5.2xlarge", "r5.large"}, "15", true},
+		{[]string{"m5.2xlarge", "does-not-exist"}, "", false},
+		{nil, "", true},
+	}
+
+	for _, el := range tests {
+		executorMemory, err := getExecutorMemory(describe, el.instanceTypes...)
+		if (err == nil) != el.valid || executorMemory != el.expected {
+			t.Errorf("unexpected executor memory for %v: %v, %v",
+				el.instanceTypes, executorMemory, err)
+		}
+	}
+}
+
+func TestTemplateHash(t *testing.T) {
+	template, err := ReadTemplateConfiguration(dockerClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := SetClusterID(template, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := SetClusterID(template, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstHash, err := TemplateHash(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondHash, err := TemplateHash(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firstHash != secondHash || len(firstHash) == 0 {
+		t.Error("expected template hash to be independent of the cluster id")
+	}
+}
+
+func TestSpotOptions(t *testing.T) {
+	valid := []*SpotOptions{
+		nil,
+		{},
+		{Strategy: SpotStrategyOneTime, MaxPrice: "0.20"},
+		{Strategy: SpotStrategyCapacityOptimized, FallbackToOnDemand: true},
+	}
+	for _, el := range valid {
+		if err := ValidateSpotOptions(el); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if ValidateSpotOptions(&SpotOptions{Strategy: "lowest-price"}) == nil {
+		t.Error("expected non-nil error for unsupported spot strategy")
+	}
+
+	if !isSpotCapacityError(awserr.New("InsufficientInstanceCapacity", "", nil)) {
+		t.Error("expected insufficient capacity to be a spot capacity error")
+	}
+
+	if isSpotCapacityError(awserr.New("InvalidSubnetID.NotFound", "", nil)) ||
+		isSpotCapacityError(errors.New("InsufficientInstanceCapacity")) {
+		t.Error("unexpected spot capacity error")
+	}
+}
+
+func TestValidateWorkerPools(t *testing.T) {
+	pools := []WorkerPool{{Name: "highmem", Nodes: 4}, {Name: "spot-2x", Nodes: 10}}
+	if err := ValidateWorkerPools(pools, 0); err != nil {
+		t.Error(err)
+	}
+
+	invalid := map[string][]WorkerPool{
+		"worker nodes": pools,
+		"name":         {{Name: "High_Mem", Nodes: 1}},
+		"duplicate":    {{Name: "a", Nodes: 1}, {Name: "a", Nodes: 2}},
+		"nodes":        {{Name: "a", Nodes: -1}},
+	}
+	for name, el := range invalid {
+		workerNodes := int64(0)
+		if name == "worker nodes" {
+			workerNodes = 2
+		}
+		if ValidateWorkerPools(el, workerNodes) == nil {
+			t.Errorf("expected %v validation to fail", name)
+		}
+	}
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	valid := []*RetryPolicy{
+		nil,
+		{MaxAttempts: 3, BackoffSeconds: 60, RetryableReasons: []string{FailureMissedCheckIn, FailureLaunch}},
+		{MaxAttempts: 1},
+	}
+	for _, el := range valid {
+		if err := ValidateRetryPolicy(el); err != nil {
+			t.Errorf("expected retry policy %+v to be valid: %v", el, err)
+		}
+	}
+
+	invalid := map[string]*RetryPolicy{
+		"no attempts":      {},
+		"too many":         {MaxAttempts: MaxRetryAttempts + 1},
+		"negative backoff": {MaxAttempts: 2, BackoffSeconds: -1},
+		"long backoff":     {MaxAttempts: 2, BackoffSeconds: MaxRetryBackoffSeconds + 1},
+		"unknown reason":   {MaxAttempts: 2, RetryableReasons: []string{"disk_full"}},
+	}
+	for name, el := range invalid {
+		if ValidateRetryPolicy(el) == nil {
+			t.Errorf("expected %v validation to fail", name)
+		}
+	}
+}
+
+func TestWorkerPoolGroups(t *testing.T) {
+	spec := AwsEnvironment{
+		InstanceType:  "m5.2xlarge",
+		EBSVolumeSize: 100,
+		WorkerPools: []AwsWorkerPool{
+			{WorkerPool: WorkerPool{Name: "ondemand", Nodes: 4}, InstanceType: "r5.4xlarge"},
+			{WorkerPool: WorkerPool{Name: "spot", Nodes: 10}, Spot: true},
+		},
+	}
+
+	resources := spec.GetResources()
+	if resources.Nodes != 15 || len(resources.Groups) != 3 {
+		t.Errorf("unexpected resources: %+v", resources)
+	}
+
+	spot := resources.Groups[2]
+	if spot.Pool != "spot" || spot.InstanceType != "m5.2xlarge" || spot.DiskGB != 100 {
+		t.Errorf("expected spot pool to default to the template shape: %+v", spot)
+	}
+
+	if spec.spotOptions(spec.workerPools()[0]) != nil ||
+		spec.spotOptions(spec.workerPools()[1]) == nil {
+		t.Error("expected only the spot pool to launch on spot")
+	}
+
+	if poolIdentifier("c1", DefaultWorkerPool) != "c1"+workerIdentifier ||
+		poolIdentifier("c1", "spot") != "c1-spot"+workerIdentifier {
+		t.Error("pool identifier mismatch")
+	}
+}
@@ -18,15 +18,28 @@ import (
 	"github.com/docker/docker/api/types/network"
 )
 
-// DockerEnvironment interface
+// DockerEnvironment interface; NanoCpus and MemBytes describe the
+// worker containers and, unless overridden, the master container and
+// worker pools
 type DockerEnvironment struct {
-	NanoCpus    int64
-	MemBytes    int64
-	ClusterID   string
-	WorkerNodes int
-	Image       string
-	Mounts      []mount.Mount
-	EnvParams   []string
+	NanoCpus       int64
+	MemBytes       int64
+	MasterNanoCpus int64 `json:",omitempty"`
+	MasterMemBytes int64 `json:",omitempty"`
+	ClusterID      string
+	WorkerNodes    int
+	Image          string
+	Mounts         []mount.Mount
+	EnvParams      []string
+	RetryPolicy    *RetryPolicy
+	WorkerPools    []DockerWorkerPool `json:",omitempty"`
+}
+
+// DockerWorkerPool - a worker pool of a docker cluster
+type DockerWorkerPool struct {
+	WorkerPool
+	NanoCpus int64 `json:",omitempty"`
+	MemBytes int64 `json:",omitempty"`
 }
 
 const (
@@ -41,24 +54,69 @@ func (e *DockerEnvironment) getDockerClient() *client.Client {
 	return cli
 }
 
-func (e *DockerEnvironment) computeExecutorMemory() string {
-	return strconv.FormatInt(e.MemBytes/1024/1024/1024-1, 10)
+// masterResources - returns the cpu and memory of the master container
+func (e *DockerEnvironment) masterResources() (int64, int64) {
+	nanoCpus, memBytes := e.NanoCpus, e.MemBytes
+	if e.MasterNanoCpus > 0 {
+		nanoCpus = e.MasterNanoCpus
+	}
+	if e.MasterMemBytes > 0 {
+		memBytes = e.MasterMemBytes
+	}
+	return nanoCpus, memBytes
+}
+
+// workerPools - returns the worker pools of the cluster with the
+// resources of the template applied; templates without pools have a
+// default pool
+func (e *DockerEnvironment) workerPools() []DockerWorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []DockerWorkerPool{{
+			WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: int64(e.WorkerNodes)},
+			NanoCpus:   e.NanoCpus,
+			MemBytes:   e.MemBytes,
+		}}
+	}
+
+	pools := make([]DockerWorkerPool, len(e.WorkerPools))
+	for idx, el := range e.WorkerPools {
+		if el.NanoCpus == 0 {
+			el.NanoCpus = e.NanoCpus
+		}
+		if el.MemBytes == 0 {
+			el.MemBytes = e.MemBytes
+		}
+		pools[idx] = el
+	}
+	return pools
 }
 
 // CreateCluster - creates a spark cluster in docker
 func (e *DockerEnvironment) CreateCluster() (string, error) {
-	expectedWorkers := "EXPECTED_WORKERS=" + strconv.Itoa(e.WorkerNodes)
+	pools := e.workerPools()
+
+	var workers, executorMemBytes int64
+	for _, el := range pools {
+		workers += el.Nodes
+		if el.Nodes > 0 && (executorMemBytes == 0 || el.MemBytes < executorMemBytes) {
+			executorMemBytes = el.MemBytes
+		}
+	}
+
+	expectedWorkers := "EXPECTED_WORKERS=" + strconv.FormatInt(workers, 10)
 
 	var envVariables []string
 	envVariables = []string{expectedWorkers,
 		"SPARK_WORKER_PORT=7078",
 		"CLUSTER_ID=" + e.ClusterID,
-		"EXECUTOR_MEMORY=" + e.computeExecutorMemory(),
+		"EXECUTOR_MEMORY=" + computeExecutorMemory(executorMemBytes/1024/1024/1024),
 		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL}
 
 	envVariables = append(envVariables, e.EnvParams...)
 
-	containerID, err := e.createSparkNode(e.ClusterID+masterIdentifier, envVariables)
+	masterNanoCpus, masterMemBytes := e.masterResources()
+	containerID, err := e.createSparkNode(e.ClusterID+masterIdentifier, envVariables,
+		nil, masterNanoCpus, masterMemBytes)
 	if err != nil {
 		logger.GetError().Println(err)
 	}
@@ -73,9 +131,12 @@ func (e *DockerEnvironment) CreateCluster() (string, error) {
 			"SPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10)},
 			envVariables...)
 
-		for i := 1; i <= e.WorkerNodes; i++ {
-			identifier := e.ClusterID + workerIdentifier + strconv.Itoa(i)
-			e.createSparkNode(identifier, envVariables)
+		for _, pool := range pools {
+			poolEnv := poolEnvParams(envVariables, pool.WorkerPool)
+			for i := int64(1); i <= pool.Nodes; i++ {
+				identifier := poolIdentifier(e.ClusterID, pool.Name) + strconv.FormatInt(i, 10)
+				e.createSparkNode(identifier, poolEnv, pool.Labels, pool.NanoCpus, pool.MemBytes)
+			}
 		}
 	} else {
 		return "", errors.New("master node has failed to come online")
@@ -120,6 +181,31 @@ func (e *DockerEnvironment) DestructionConfirmed() bool {
 	return len(clusterNodes) == 0
 }
 
+// GetResources - returns the compute resources requested by the cluster
+func (e *DockerEnvironment) GetResources() ClusterResources {
+	nanoCpus, memBytes := e.masterResources()
+	resources := ClusterResources{
+		Nodes:      1,
+		ShapeKnown: true,
+		Groups:     []NodeGroup{{InstanceType: DockerInstanceType, Nodes: 1}},
+	}
+
+	for _, el := range e.workerPools() {
+		if el.Nodes <= 0 {
+			continue
+		}
+		resources.Nodes += el.Nodes
+		nanoCpus += el.NanoCpus * el.Nodes
+		memBytes += el.MemBytes * el.Nodes
+		resources.Groups = append(resources.Groups, NodeGroup{InstanceType: DockerInstanceType,
+			Nodes: el.Nodes, Pool: el.Name})
+	}
+
+	resources.VCPU = float64(nanoCpus) / 1e9
+	resources.MemoryGB = float64(memBytes) / (1 << 30)
+	return resources
+}
+
 func (e *DockerEnvironment) getClusterNodes() ([]string, error) {
 	cli := e.getDockerClient()
 	defer cli.Close()
@@ -153,21 +239,22 @@ func (e *DockerEnvironment) getIPAddress(id string) (string, error) {
 	return resp.NetworkSettings.Networks[allsparkBridgedNetwork].IPAddress, nil
 }
 
-func (e *DockerEnvironment) createSparkNode(identifier string,
-	envParams []string) (string, error) {
+func (e *DockerEnvironment) createSparkNode(identifier string, envParams []string,
+	labels map[string]string, nanoCpus int64, memBytes int64) (string, error) {
 
 	cli := e.getDockerClient()
 	defer cli.Close()
 
 	resp, err := cli.ContainerCreate(context.Background(),
 		&container.Config{
-			Image: e.Image,
-			Env:   envParams,
+			Image:  e.Image,
+			Env:    envParams,
+			Labels: labels,
 		},
 		&container.HostConfig{
 			Resources: container.Resources{
-				NanoCPUs: e.NanoCpus,
-				Memory:   e.MemBytes,
+				NanoCPUs: nanoCpus,
+				Memory:   memBytes,
 			},
 			Mounts:      e.Mounts,
 			NetworkMode: allsparkBridgedNetwork,
@@ -132,3 +132,25 @@ func TestCreateAndDestroySingleNodeDockerCluster(t *testing.T) {
 		t.Error("- got " + strconv.Itoa(actualNodeCount) + " spark nodes.")
 	}
 }
+
+func TestDockerMasterResources(t *testing.T) {
+	var spec DockerEnvironment
+	err := serializer.DeserializePath(dockerClusterTemplatePath, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nanoCpus, memBytes := spec.masterResources()
+	if nanoCpus != spec.NanoCpus || memBytes != spec.MemBytes {
+		t.Error("expected master to default to the worker resources")
+	}
+
+	spec.MasterMemBytes = 2 * spec.MemBytes
+	resources := spec.GetResources()
+	expected := float64(spec.WorkerNodes + 2)
+	if resources.MemoryGB != expected {
+		t.Error("memory mismatch")
+		t.Errorf("-expected: %v", expected)
+		t.Errorf("-actual: %v", resources.MemoryGB)
+	}
+}
@@ -0,0 +1,658 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"allspark/logger"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesEnvironment interface; the master of a cluster runs as a pod of
+// Namespace, the workers of each pool as a stateful set, and workers reach
+// the master through a service.
+// Resources describe the worker pods and, unless overridden, the master pod
+// and worker pools. Without an APIServer the daemon connects to the
+// kubernetes cluster it runs in using its service account
+type KubernetesEnvironment struct {
+	ClusterID       string
+	APIServer       string `json:",omitempty"`
+	Token           string `json:",omitempty"`
+	CACertificate   string `json:",omitempty"`
+	Namespace       string
+	Image           string
+	ServiceAccount  string `json:",omitempty"`
+	Resources       KubernetesResources
+	MasterResources *KubernetesResources   `json:",omitempty"`
+	NodeSelector    map[string]string      `json:",omitempty"`
+	Tolerations     []KubernetesToleration `json:",omitempty"`
+	WorkerNodes     int64
+	EnvParams       []string
+	RetryPolicy     *RetryPolicy
+	WorkerPools     []KubernetesWorkerPool `json:",omitempty"`
+}
+
+// KubernetesResources - the CPU and memory requests and limits of a pod,
+// as kubernetes quantities (e.g. 500m, 2, 8Gi); limits are optional
+type KubernetesResources struct {
+	CPU         string
+	Memory      string
+	CPULimit    string `json:",omitempty"`
+	MemoryLimit string `json:",omitempty"`
+}
+
+// KubernetesToleration - a toleration of the pods of a cluster
+type KubernetesToleration struct {
+	Key               string `json:",omitempty"`
+	Operator          string `json:",omitempty"`
+	Value             string `json:",omitempty"`
+	Effect            string `json:",omitempty"`
+	TolerationSeconds *int64 `json:",omitempty"`
+}
+
+// KubernetesWorkerPool - a worker pool of a kubernetes cluster; its
+// NodeSelector is merged with the template's and its Tolerations are
+// added to the template's
+type KubernetesWorkerPool struct {
+	WorkerPool
+	Resources    *KubernetesResources   `json:",omitempty"`
+	NodeSelector map[string]string      `json:",omitempty"`
+	Tolerations  []KubernetesToleration `json:",omitempty"`
+}
+
+// KubernetesInstanceType - instance type reported for kubernetes pods
+const KubernetesInstanceType = "kubernetes"
+
+// labels of the pods and services of a cluster
+const (
+	kubernetesClusterLabel = "allspark/cluster-id"
+	kubernetesRoleLabel    = "allspark/role"
+	kubernetesPoolLabel    = "allspark/worker-pool"
+	masterRole             = "master"
+	workerRole             = "worker"
+)
+
+// longest name prefix of the objects of a cluster; leaves room for the
+// suffixes of services, whose names are limited to 63 characters
+const maxKubernetesNameLength = 40
+
+var (
+	kubernetesNamespace = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	invalidNameChars    = regexp.MustCompile(`[^a-z0-9-]+`)
+	quantity            = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)(m|k|Ki|M|Mi|G|Gi|T|Ti|P|Pi|E|Ei)?$`)
+)
+
+var quantitySuffixes = map[string]float64{
+	"": 1, "m": 1e-3,
+	"k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12, "P": 1e15, "E": 1e18,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40, "Pi": 1 << 50, "Ei": 1 << 60,
+}
+
+// parseQuantity - returns the value of a kubernetes quantity
+func parseQuantity(value string) (float64, error) {
+	match := quantity.FindStringSubmatch(value)
+	if match == nil {
+		return 0, errors.New("invalid kubernetes quantity " + value)
+	}
+
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return number * quantitySuffixes[match[2]], nil
+}
+
+// ValidateKubernetesResources - verifies the requests are set, all
+// quantities are well-formed and limits are not below requests
+func ValidateKubernetesResources(resources KubernetesResources) error {
+	requests := []string{resources.CPU, resources.Memory}
+	limits := []string{resources.CPULimit, resources.MemoryLimit}
+
+	for idx, el := range requests {
+		request, err := parseQuantity(el)
+		if err != nil {
+			return err
+		}
+		if request <= 0 {
+			return errors.New("resource requests must be positive")
+		}
+
+		if len(limits[idx]) == 0 {
+			continue
+		}
+		limit, err := parseQuantity(limits[idx])
+		if err != nil {
+			return err
+		}
+		if limit < request {
+			return errors.New("resource limit " + limits[idx] + " is below its request " + el)
+		}
+	}
+
+	return nil
+}
+
+// ValidateKubernetesNamespace - verifies the namespace is a valid name
+func ValidateKubernetesNamespace(namespace string) error {
+	if !kubernetesNamespace.MatchString(namespace) {
+		return errors.New("namespace " + namespace + " must consist of up to 63 " +
+			"lower case alphanumeric characters or '-'")
+	}
+	return nil
+}
+
+// ValidateKubernetesTolerations - verifies the operators and effects of
+// the tolerations
+func ValidateKubernetesTolerations(tolerations []KubernetesToleration) error {
+	for _, el := range tolerations {
+		switch el.Operator {
+		case "", "Equal":
+		case "Exists":
+			if len(el.Value) > 0 {
+				return errors.New("tolerations with operator Exists must not have a value")
+			}
+		default:
+			return errors.New("toleration operator must be Equal or Exists")
+		}
+
+		switch el.Effect {
+		case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return errors.New("toleration effect must be NoSchedule, PreferNoSchedule or NoExecute")
+		}
+
+		if len(el.Key) == 0 && el.Operator != "Exists" {
+			return errors.New("tolerations without a key must use operator Exists")
+		}
+	}
+	return nil
+}
+
+// kubernetesName - returns the name prefix of the objects of a cluster;
+// cluster IDs which are not valid object names are sanitized and suffixed
+// with their digest to keep names unique
+func kubernetesName(clusterID string) string {
+	name := "spark-" + strings.Trim(invalidNameChars.ReplaceAllString(
+		strings.ToLower(clusterID), "-"), "-")
+	if name == "spark-"+clusterID && len(name) <= maxKubernetesNameLength {
+		return name
+	}
+
+	digest := sha256.Sum256([]byte(clusterID))
+	suffix := "-" + hex.EncodeToString(digest[:4])
+	if len(name) > maxKubernetesNameLength-len(suffix) {
+		name = strings.TrimRight(name[:maxKubernetesNameLength-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+func (e *KubernetesEnvironment) getClient() (kubernetes.Interface, error) {
+	return kubernetesClientset(e.APIServer, e.Token, e.CACertificate)
+}
+
+// masterResources - returns the resources of the master pod
+func (e *KubernetesEnvironment) masterResources() KubernetesResources {
+	if e.MasterResources != nil {
+		return *e.MasterResources
+	}
+	return e.Resources
+}
+
+// workerPools - returns the worker pools of the cluster with the
+// resources of the template applied; templates without pools have a
+// default pool
+func (e *KubernetesEnvironment) workerPools() []KubernetesWorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []KubernetesWorkerPool{{
+			WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: e.WorkerNodes},
+			Resources:  &e.Resources,
+		}}
+	}
+
+	pools := make([]KubernetesWorkerPool, len(e.WorkerPools))
+	for idx, el := range e.WorkerPools {
+		if el.Resources == nil {
+			el.Resources = &e.Resources
+		}
+		pools[idx] = el
+	}
+	return pools
+}
+
+// selector - returns the label selector of the objects of the cluster
+func (e *KubernetesEnvironment) selector() string {
+	return kubernetesClusterLabel + "=" + kubernetesName(e.ClusterID)
+}
+
+// masterHost - returns the DNS name of the service of the master
+func (e *KubernetesEnvironment) masterHost() string {
+	return kubernetesName(e.ClusterID) + masterIdentifier + "." + e.Namespace + ".svc"
+}
+
+// service - returns a service of the cluster selecting the pods of a role
+func (e *KubernetesEnvironment) service(name string, role string,
+	ports map[string]int32) *corev1.Service {
+
+	clusterName := kubernetesName(e.ClusterID)
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{kubernetesClusterLabel: clusterName},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{kubernetesClusterLabel: clusterName, kubernetesRoleLabel: role},
+		},
+	}
+
+	for portName, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       portName,
+			Port:       port,
+			TargetPort: intstr.FromInt(int(port)),
+		})
+	}
+	sort.Slice(service.Spec.Ports, func(i, j int) bool {
+		return service.Spec.Ports[i].Port < service.Spec.Ports[j].Port
+	})
+	return service
+}
+
+// masterService - returns the service through which workers and the
+// daemon reach the master, including its REST submission gateway
+func (e *KubernetesEnvironment) masterService() *corev1.Service {
+	return e.service(kubernetesName(e.ClusterID)+masterIdentifier, masterRole,
+		map[string]int32{"spark": sparkMasterPort, "web": 8080, "rest": sparkRestPort})
+}
+
+// workerService - returns the headless service governing the stateful
+// sets of the workers
+func (e *KubernetesEnvironment) workerService() *corev1.Service {
+	service := e.service(kubernetesName(e.ClusterID)+"-workers", workerRole,
+		map[string]int32{"spark": sparkWorkerPort, "web": 8081})
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+	return service
+}
+
+// podTemplate - returns the labels and spec of a pod of the cluster
+func (e *KubernetesEnvironment) podTemplate(labels map[string]string, env []string,
+	resources KubernetesResources, nodeSelector map[string]string,
+	tolerations []KubernetesToleration, ports ...int32) (corev1.PodTemplateSpec, error) {
+
+	podLabels := map[string]string{kubernetesClusterLabel: kubernetesName(e.ClusterID)}
+	for key, value := range labels {
+		podLabels[key] = value
+	}
+
+	requests, err := resourceList(resources.CPU, resources.Memory)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	limits, err := resourceList(resources.CPULimit, resources.MemoryLimit)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	container := corev1.Container{
+		Name:      "spark",
+		Image:     e.Image,
+		Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits},
+	}
+
+	for _, el := range env {
+		buff := strings.SplitN(el, "=", 2)
+		container.Env = append(container.Env, corev1.EnvVar{Name: buff[0], Value: buff[1]})
+	}
+
+	for _, el := range ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: el})
+	}
+
+	spec := corev1.PodSpec{
+		ServiceAccountName: e.ServiceAccount,
+		NodeSelector:       nodeSelector,
+		Containers:         []corev1.Container{container},
+	}
+	for _, el := range tolerations {
+		spec.Tolerations = append(spec.Tolerations, corev1.Toleration{
+			Key:               el.Key,
+			Operator:          corev1.TolerationOperator(el.Operator),
+			Value:             el.Value,
+			Effect:            corev1.TaintEffect(el.Effect),
+			TolerationSeconds: el.TolerationSeconds,
+		})
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+		Spec:       spec,
+	}, nil
+}
+
+// resourceList - returns the CPU and memory quantities, either of which
+// may be empty; nil if both are
+func resourceList(cpu string, memory string) (corev1.ResourceList, error) {
+	var list corev1.ResourceList
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    cpu,
+		corev1.ResourceMemory: memory,
+	} {
+		if len(value) == 0 {
+			continue
+		}
+
+		parsed, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, errors.New("invalid kubernetes quantity " + value)
+		}
+		if list == nil {
+			list = make(corev1.ResourceList)
+		}
+		list[name] = parsed
+	}
+	return list, nil
+}
+
+// masterPod - returns the pod of the master; it is never restarted, a
+// failed master fails the cluster like a terminated instance would
+func (e *KubernetesEnvironment) masterPod(env []string) (*corev1.Pod, error) {
+	template, err := e.podTemplate(map[string]string{kubernetesRoleLabel: masterRole}, env,
+		e.masterResources(), e.NodeSelector, e.Tolerations, sparkMasterPort, 8080, sparkRestPort)
+	if err != nil {
+		return nil, err
+	}
+
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	template.ObjectMeta.Name = kubernetesName(e.ClusterID) + masterIdentifier
+	return &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}, nil
+}
+
+// workerStatefulSet - returns the stateful set running the workers of a
+// pool; workers which fail or are evicted are replaced
+func (e *KubernetesEnvironment) workerStatefulSet(env []string,
+	pool KubernetesWorkerPool) (*appsv1.StatefulSet, error) {
+
+	clusterName := kubernetesName(e.ClusterID)
+	selector := map[string]string{
+		kubernetesClusterLabel: clusterName,
+		kubernetesRoleLabel:    workerRole,
+		kubernetesPoolLabel:    pool.Name,
+	}
+
+	labels := map[string]string{kubernetesRoleLabel: workerRole, kubernetesPoolLabel: pool.Name}
+	for key, value := range pool.Labels {
+		labels[key] = value
+	}
+
+	nodeSelector := make(map[string]string)
+	for key, value := range e.NodeSelector {
+		nodeSelector[key] = value
+	}
+	for key, value := range pool.NodeSelector {
+		nodeSelector[key] = value
+	}
+
+	tolerations := append(append([]KubernetesToleration{}, e.Tolerations...), pool.Tolerations...)
+	template, err := e.podTemplate(labels, poolEnvParams(env, pool.WorkerPool), *pool.Resources,
+		nodeSelector, tolerations, sparkWorkerPort, 8081)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := int32(pool.Nodes)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   poolIdentifier(clusterName, pool.Name),
+			Labels: map[string]string{kubernetesClusterLabel: clusterName},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            &replicas,
+			Selector:            &metav1.LabelSelector{MatchLabels: selector},
+			ServiceName:         e.workerService().Name,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Template:            template,
+		},
+	}, nil
+}
+
+// workerMemory - returns the memory of the workers of a pool, which is
+// the memory limit if set, or else the memory request
+func workerMemory(resources KubernetesResources) (float64, error) {
+	if len(resources.MemoryLimit) > 0 {
+		return parseQuantity(resources.MemoryLimit)
+	}
+	return parseQuantity(resources.Memory)
+}
+
+// ValidateKubernetesWorkerResources - verifies the resources of workers
+// leave memory for spark executors once 1Gi is reserved for the worker
+func ValidateKubernetesWorkerResources(resources KubernetesResources) error {
+	err := ValidateKubernetesResources(resources)
+	if err != nil {
+		return err
+	}
+
+	memory, err := workerMemory(resources)
+	if err != nil {
+		return err
+	}
+	if memory < 2*(1<<30) {
+		return errors.New("workers require at least 2Gi of memory; " +
+			"1Gi is reserved for the spark worker")
+	}
+	return nil
+}
+
+// CreateCluster - creates a spark cluster in kubernetes
+func (e *KubernetesEnvironment) CreateCluster() (string, error) {
+	client, err := e.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	pools := e.workerPools()
+
+	var workers int64
+	var executorMemory float64
+	for _, el := range pools {
+		memory, err := workerMemory(*el.Resources)
+		if err != nil {
+			return "", err
+		}
+
+		workers += el.Nodes
+		if el.Nodes > 0 && (executorMemory == 0 || memory < executorMemory) {
+			executorMemory = memory
+		}
+	}
+
+	envVariables := []string{"EXPECTED_WORKERS=" + strconv.FormatInt(workers, 10),
+		"SPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10),
+		"CLUSTER_ID=" + e.ClusterID,
+		"EXECUTOR_MEMORY=" + computeExecutorMemory(int64(executorMemory)/(1<<30)),
+		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL}
+	envVariables = append(envVariables, e.EnvParams...)
+
+	ctx := context.Background()
+	services := client.CoreV1().Services(e.Namespace)
+	for _, el := range []*corev1.Service{e.masterService(), e.workerService()} {
+		_, err = services.Create(ctx, el, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	master, err := e.masterPod(envVariables)
+	if err != nil {
+		return "", err
+	}
+	_, err = client.CoreV1().Pods(e.Namespace).Create(ctx, master, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	envVariables = append([]string{"MASTER_IP=" + e.masterHost()}, envVariables...)
+	for _, pool := range pools {
+		statefulSet, err := e.workerStatefulSet(envVariables, pool)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = client.AppsV1().StatefulSets(e.Namespace).Create(ctx, statefulSet, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	logger.GetInfo().Printf("created kubernetes cluster %s with %v workers in namespace %s",
+		e.ClusterID, workers, e.Namespace)
+	return "http://" + e.masterHost() + ":8080", nil
+}
+
+// ignoreNotFound - returns nil if the error reports a missing object
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// DestroyCluster - destroys the spark cluster in kubernetes; the stateful
+// sets of the workers are deleted first, so that they do not replace the
+// deleted pods
+func (e *KubernetesEnvironment) DestroyCluster() error {
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	list := metav1.ListOptions{LabelSelector: e.selector()}
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	statefulSets := client.AppsV1().StatefulSets(e.Namespace)
+	sets, err := statefulSets.List(ctx, list)
+	if err != nil {
+		return err
+	}
+	for _, el := range sets.Items {
+		err = ignoreNotFound(statefulSets.Delete(ctx, el.Name, options))
+		if err != nil {
+			return err
+		}
+	}
+
+	pods := client.CoreV1().Pods(e.Namespace)
+	podList, err := pods.List(ctx, list)
+	if err != nil {
+		return err
+	}
+	for _, el := range podList.Items {
+		err = ignoreNotFound(pods.Delete(ctx, el.Name, options))
+		if err != nil {
+			return err
+		}
+	}
+
+	services := client.CoreV1().Services(e.Namespace)
+	serviceList, err := services.List(ctx, list)
+	if err != nil {
+		return err
+	}
+	for _, el := range serviceList.Items {
+		err = ignoreNotFound(services.Delete(ctx, el.Name, options))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DestructionConfirmed - returns true if the cluster has been terminated; false otherwise
+func (e *KubernetesEnvironment) DestructionConfirmed() bool {
+	client, err := e.getClient()
+	if err == nil {
+		ctx := context.Background()
+		list := metav1.ListOptions{LabelSelector: e.selector()}
+
+		var sets *appsv1.StatefulSetList
+		var pods *corev1.PodList
+		var services *corev1.ServiceList
+		sets, err = client.AppsV1().StatefulSets(e.Namespace).List(ctx, list)
+		if err == nil {
+			pods, err = client.CoreV1().Pods(e.Namespace).List(ctx, list)
+		}
+		if err == nil {
+			services, err = client.CoreV1().Services(e.Namespace).List(ctx, list)
+		}
+		if err == nil {
+			return len(sets.Items) == 0 && len(pods.Items) == 0 && len(services.Items) == 0
+		}
+	}
+
+	logger.GetError().Println(err)
+	logger.GetError().Printf("unable to confirm destruction of cluster %v", e.ClusterID)
+	return false
+}
+
+// GetResources - returns the compute resources requested by the cluster
+func (e *KubernetesEnvironment) GetResources() ClusterResources {
+	master := e.masterResources()
+	cpu, _ := parseQuantity(master.CPU)
+	memory, _ := parseQuantity(master.Memory)
+	resources := ClusterResources{
+		Nodes:      1,
+		ShapeKnown: true,
+		Groups:     []NodeGroup{{InstanceType: KubernetesInstanceType, Nodes: 1}},
+	}
+
+	for _, el := range e.workerPools() {
+		if el.Nodes <= 0 {
+			continue
+		}
+		poolCPU, _ := parseQuantity(el.Resources.CPU)
+		poolMemory, _ := parseQuantity(el.Resources.Memory)
+
+		resources.Nodes += el.Nodes
+		cpu += poolCPU * float64(el.Nodes)
+		memory += poolMemory * float64(el.Nodes)
+		resources.Groups = append(resources.Groups, NodeGroup{InstanceType: KubernetesInstanceType,
+			Nodes: el.Nodes, Pool: el.Name})
+	}
+
+	resources.VCPU = cpu
+	resources.MemoryGB = memory / (1 << 30)
+	return resources
+}
+
+func (e *KubernetesEnvironment) getClusterNodes() ([]string, error) {
+	client, err := e.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := client.CoreV1().Pods(e.Namespace).List(context.Background(),
+		metav1.ListOptions{LabelSelector: e.selector()})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(pods.Items))
+	for _, el := range pods.Items {
+		names = append(names, el.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,45 @@
+package cloud
+
+import (
+	"errors"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// timeout of the requests to the API server
+const kubernetesRequestTimeout = 30 * time.Second
+
+// kubernetesClientset - returns a clientset of the API server; without a
+// server the clientset connects to the cluster the daemon runs in using
+// the credentials of its service account, unless a token or CA
+// certificate is given
+var kubernetesClientset = func(server string, token string,
+	caCertificate string) (kubernetes.Interface, error) {
+
+	config := &rest.Config{Host: server, BearerToken: token}
+	if len(server) == 0 {
+		inCluster, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, errors.New("kubernetes API server is not set and the daemon " +
+				"is not running in a kubernetes cluster")
+		}
+		config = inCluster
+
+		if len(token) > 0 {
+			config.BearerToken = token
+			config.BearerTokenFile = ""
+		}
+		if len(caCertificate) > 0 {
+			config.TLSClientConfig = rest.TLSClientConfig{}
+		}
+	}
+
+	if len(caCertificate) > 0 {
+		config.TLSClientConfig.CAData = []byte(caCertificate)
+	}
+	config.Timeout = kubernetesRequestTimeout
+
+	return kubernetes.NewForConfig(config)
+}
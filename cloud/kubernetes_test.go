@@ -0,0 +1,326 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const kubernetesClusterTemplatePath = "../dist/sample_templates/kubernetes.json"
+
+// useFakeClientset - makes the environments use an in-memory clientset
+// until the returned function is called
+func useFakeClientset(clientset *fake.Clientset) func() {
+	previous := kubernetesClientset
+	kubernetesClientset = func(string, string, string) (kubernetes.Interface, error) {
+		return clientset, nil
+	}
+	return func() { kubernetesClientset = previous }
+}
+
+func containerEnv(spec corev1.PodSpec) map[string]string {
+	env := make(map[string]string)
+	for _, el := range spec.Containers[0].Env {
+		env[el.Name] = el.Value
+	}
+	return env
+}
+
+func TestKubernetesCluster(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	defer useFakeClientset(clientset)()
+
+	templateConfig, err := ReadTemplateConfiguration(kubernetesClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Create(Kubernetes, templateConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := client.(*KubernetesEnvironment)
+	e.WorkerPools = []KubernetesWorkerPool{{
+		WorkerPool:   WorkerPool{Name: "highmem", Nodes: 1, Labels: map[string]string{"tier": "batch"}},
+		Resources:    &KubernetesResources{CPU: "500m", Memory: "8Gi"},
+		NodeSelector: map[string]string{"disk": "ssd"},
+		Tolerations:  []KubernetesToleration{{Key: "spot", Operator: "Exists", Effect: "NoSchedule"}},
+	}, {
+		WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: 2},
+	}}
+	e.WorkerNodes = 0
+
+	webURL, err := e.CreateCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	name := kubernetesName(e.ClusterID)
+	masterHost := name + "-master.spark.svc"
+	if webURL != "http://"+masterHost+":8080" {
+		t.Errorf("unexpected web url %v", webURL)
+	}
+
+	services, err := clientset.CoreV1().Services("spark").List(ctx, metav1.ListOptions{})
+	if err != nil || len(services.Items) != 2 {
+		t.Fatalf("expected 2 services, got %v: %v", services, err)
+	}
+	workerService, err := clientset.CoreV1().Services("spark").Get(ctx, name+"-workers", metav1.GetOptions{})
+	if err != nil || workerService.Spec.ClusterIP != corev1.ClusterIPNone ||
+		workerService.Spec.Selector[kubernetesRoleLabel] != workerRole {
+		t.Errorf("unexpected worker service %+v: %v", workerService, err)
+	}
+
+	master, err := clientset.CoreV1().Pods("spark").Get(ctx, name+"-master", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := containerEnv(master.Spec)
+	if env["EXPECTED_WORKERS"] != "3" || env["CLUSTER_ID"] != e.ClusterID ||
+		env["EXECUTOR_MEMORY"] != "3" || env["PARAM"] != "VALUE" || len(env["MASTER_IP"]) > 0 {
+		t.Errorf("unexpected master environment %v", env)
+	}
+	if _, ok := env["ALLSPARK_CALLBACK"]; !ok {
+		t.Error("expected the master to receive the callback")
+	}
+	if master.Labels[kubernetesRoleLabel] != masterRole ||
+		master.Spec.RestartPolicy != corev1.RestartPolicyNever ||
+		master.Spec.NodeSelector["workload"] != "spark" ||
+		len(master.Spec.Tolerations) != 1 {
+		t.Errorf("unexpected master pod %+v", master)
+	}
+
+	sets := clientset.AppsV1().StatefulSets("spark")
+	worker, err := sets.Get(ctx, name+"-worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env = containerEnv(worker.Spec.Template.Spec)
+	if env["MASTER_IP"] != masterHost || env["WORKER_POOL"] != DefaultWorkerPool {
+		t.Errorf("unexpected worker environment %v", env)
+	}
+	resources := worker.Spec.Template.Spec.Containers[0].Resources
+	if *worker.Spec.Replicas != 2 || worker.Spec.ServiceName != name+"-workers" ||
+		resources.Requests.Cpu().String() != "1" || resources.Limits.Memory().String() != "4Gi" {
+		t.Errorf("unexpected worker stateful set %+v", worker.Spec)
+	}
+
+	highmem, err := sets.Get(ctx, name+"-highmem-worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := highmem.Spec.Template
+	env = containerEnv(template.Spec)
+	if env["MASTER_IP"] != masterHost || env["WORKER_POOL"] != "highmem" {
+		t.Errorf("unexpected pool worker environment %v", env)
+	}
+	if *highmem.Spec.Replicas != 1 ||
+		highmem.Spec.Selector.MatchLabels[kubernetesPoolLabel] != "highmem" ||
+		template.Labels["tier"] != "batch" ||
+		template.Labels[kubernetesPoolLabel] != "highmem" ||
+		template.Spec.NodeSelector["workload"] != "spark" ||
+		template.Spec.NodeSelector["disk"] != "ssd" ||
+		len(template.Spec.Tolerations) != 2 ||
+		template.Spec.Containers[0].Resources.Requests.Memory().String() != "8Gi" ||
+		template.Spec.Containers[0].Resources.Limits != nil {
+		t.Errorf("unexpected pool worker stateful set %+v", highmem.Spec)
+	}
+
+	resourcesRequested := e.GetResources()
+	if resourcesRequested.Nodes != 4 || resourcesRequested.VCPU != 3.5 ||
+		resourcesRequested.MemoryGB != 20 || len(resourcesRequested.Groups) != 3 {
+		t.Errorf("unexpected resources %+v", resourcesRequested)
+	}
+
+	if e.DestructionConfirmed() {
+		t.Error("expected destruction to be unconfirmed while objects exist")
+	}
+
+	err = e.DestroyCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.DestructionConfirmed() {
+		t.Error("expected destruction to be confirmed")
+	}
+
+	clientset.PrependReactor("create", "services",
+		func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("forbidden")
+		})
+	if _, err = e.CreateCluster(); err == nil {
+		t.Error("expected failed requests to fail the creation")
+	}
+}
+
+// serviceTransport - answers spark REST requests addressed to a port the
+// service of a host exposes and its pods listen on; other requests fail
+type serviceTransport struct {
+	clientset *fake.Clientset
+	namespace string
+	requests  []string
+}
+
+func (s *serviceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	name := strings.Split(r.URL.Hostname(), ".")[0]
+	service, err := s.clientset.CoreV1().Services(s.namespace).Get(r.Context(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := s.clientset.CoreV1().Pods(s.namespace).List(r.Context(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, port := range service.Spec.Ports {
+		if strconv.Itoa(int(port.Port)) != r.URL.Port() {
+			continue
+		}
+		for _, pod := range pods.Items {
+			for _, el := range pod.Spec.Containers[0].Ports {
+				if el.ContainerPort == port.TargetPort.IntVal {
+					s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+					body := `{"success": true, "submissionId": "driver-1", "driverState": "RUNNING"}`
+					return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header),
+						Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+				}
+			}
+		}
+	}
+	return nil, errors.New("connection refused: " + r.URL.Host)
+}
+
+func TestKubernetesJobSubmission(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	defer useFakeClientset(clientset)()
+
+	templateConfig, err := ReadTemplateConfiguration(kubernetesClusterTemplatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Create(Kubernetes, templateConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := client.(*KubernetesEnvironment)
+
+	_, err = e.CreateCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &serviceTransport{clientset: clientset, namespace: e.Namespace}
+	previous := sparkRestClient
+	sparkRestClient = &http.Client{Transport: transport}
+	defer func() { sparkRestClient = previous }()
+
+	// the master reports the address of its service at check-in
+	master, err := GetSparkMaster(SparkClusterStatus{URL: "spark://" + e.masterHost() + ":7077"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submissionID, err := SubmitSparkJob(master, SparkJobSpec{PythonFile: "local:///app.py"})
+	if err != nil || submissionID != "driver-1" {
+		t.Fatalf("expected the job to reach the REST gateway, got %v: %v", submissionID, err)
+	}
+	state, err := GetSparkJobState(master, submissionID)
+	if err != nil || state != DriverStateRunning {
+		t.Errorf("unexpected driver state %v: %v", state, err)
+	}
+	if len(transport.requests) != 2 || transport.requests[0] != "POST /v1/submissions/create" {
+		t.Errorf("unexpected requests %v", transport.requests)
+	}
+}
+
+func TestKubernetesWorkerResources(t *testing.T) {
+	for _, el := range []KubernetesResources{
+		{CPU: "1", Memory: "2Gi"},
+		{CPU: "1", Memory: "1Gi", MemoryLimit: "4Gi"},
+	} {
+		if err := ValidateKubernetesWorkerResources(el); err != nil {
+			t.Errorf("expected resources %+v to be valid: %v", el, err)
+		}
+	}
+
+	for _, el := range []KubernetesResources{
+		{CPU: "1", Memory: "512Mi"},
+		{CPU: "1", Memory: "1536Mi"},
+		{CPU: "1", Memory: "4Gi", MemoryLimit: "1Gi"},
+	} {
+		if ValidateKubernetesWorkerResources(el) == nil {
+			t.Errorf("expected resources %+v to be invalid", el)
+		}
+	}
+}
+
+func TestKubernetesTemplates(t *testing.T) {
+	if kubernetesName("nightly-etl") != "spark-nightly-etl" {
+		t.Errorf("unexpected name %v", kubernetesName("nightly-etl"))
+	}
+
+	for _, el := range []string{"Nightly_ETL", strings.Repeat("a", 60)} {
+		name := kubernetesName(el)
+		if len(name) > maxKubernetesNameLength || !kubernetesNamespace.MatchString(name) {
+			t.Errorf("invalid name %v for %v", name, el)
+		}
+	}
+	if kubernetesName("Nightly_ETL") == kubernetesName("nightly-etl") {
+		t.Error("expected sanitized names to be unique")
+	}
+
+	for value, expected := range map[string]float64{"500m": 0.5, "2": 2, "1Gi": 1 << 30, "1.5k": 1500} {
+		if actual, err := parseQuantity(value); err != nil || actual != expected {
+			t.Errorf("unexpected quantity %v for %v: %v", actual, value, err)
+		}
+	}
+
+	if ValidateKubernetesResources(KubernetesResources{CPU: "1", Memory: "1Gi", MemoryLimit: "2Gi"}) != nil {
+		t.Error("expected resources to be valid")
+	}
+	for _, el := range []KubernetesResources{
+		{CPU: "1"},
+		{CPU: "one", Memory: "1Gi"},
+		{CPU: "1", Memory: "2Gi", MemoryLimit: "1Gi"},
+		{CPU: "0", Memory: "1Gi"},
+	} {
+		if ValidateKubernetesResources(el) == nil {
+			t.Errorf("expected resources %+v to be invalid", el)
+		}
+	}
+
+	if ValidateKubernetesNamespace("spark") != nil || ValidateKubernetesNamespace("Spark") == nil {
+		t.Error("unexpected namespace validation")
+	}
+
+	if ValidateKubernetesTolerations([]KubernetesToleration{{Operator: "Exists"},
+		{Key: "dedicated", Value: "spark", Effect: "NoExecute"}}) != nil {
+		t.Error("expected tolerations to be valid")
+	}
+	for _, el := range []KubernetesToleration{
+		{Key: "a", Operator: "In"},
+		{Key: "a", Effect: "Evict"},
+		{Key: "a", Operator: "Exists", Value: "b"},
+		{Value: "b"},
+	} {
+		if ValidateKubernetesTolerations([]KubernetesToleration{el}) == nil {
+			t.Errorf("expected toleration %+v to be invalid", el)
+		}
+	}
+}
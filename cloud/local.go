@@ -0,0 +1,374 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"allspark/logger"
+	"allspark/util/netutil"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LocalEnvironment interface; the master and workers of a cluster run as
+// processes of the daemon host, each node in its own process group, so
+// clusters can be launched without docker or a cloud. Commands default to
+// the spark master and worker of SPARK_HOME and their arguments expand
+// ${NAME} from the environment of the node. MonitorCommand, if set, runs
+// alongside each node, e.g. run_monitor.py to check in with the daemon and
+// report the port of the master's REST submission gateway
+type LocalEnvironment struct {
+	ClusterID      string
+	WorkerNodes    int64
+	Cores          int64    `json:",omitempty"`
+	MemBytes       int64    `json:",omitempty"`
+	MasterCommand  []string `json:",omitempty"`
+	WorkerCommand  []string `json:",omitempty"`
+	MonitorCommand []string `json:",omitempty"`
+	WorkDir        string   `json:",omitempty"`
+	EnvParams      []string
+	RetryPolicy    *RetryPolicy
+	WorkerPools    []WorkerPool `json:",omitempty"`
+}
+
+// LocalInstanceType - instance type reported for local processes
+const LocalInstanceType = "local"
+
+const (
+	localHost         = "127.0.0.1"
+	localPIDExtension = ".pid"
+	// how long destroyed nodes may take to exit before they are killed
+	localTerminationGrace = 10 * time.Second
+)
+
+var (
+	defaultMasterCommand = []string{"${SPARK_HOME}/bin/spark-class",
+		"org.apache.spark.deploy.master.Master", "--host", localHost,
+		"--port", "${SPARK_MASTER_PORT}", "--webui-port", "${SPARK_MASTER_WEBUI_PORT}"}
+	defaultWorkerCommand = []string{"${SPARK_HOME}/bin/spark-class",
+		"org.apache.spark.deploy.worker.Worker", "--host", localHost,
+		"--port", "${SPARK_WORKER_PORT}", "--webui-port", "${SPARK_WORKER_WEBUI_PORT}",
+		"${MASTER_URL}"}
+)
+
+// localPorts - returns count free TCP ports of the loopback interface
+func localPorts(count int) ([]int, error) {
+	ports := make([]int, 0, count)
+	for len(ports) < count {
+		listener, err := net.Listen("tcp", net.JoinHostPort(localHost, "0"))
+		if err != nil {
+			return nil, err
+		}
+		defer listener.Close()
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// masterOptsEnvParams - returns the environment parameters with the
+// options enabling the REST submission gateway on the port prepended to
+// SPARK_MASTER_OPTS
+func masterOptsEnvParams(envParams []string, restPort string) []string {
+	opts := "-Dspark.master.rest.enabled=true -Dspark.master.rest.port=" + restPort
+
+	result := make([]string, 0, len(envParams)+1)
+	for _, el := range envParams {
+		if strings.HasPrefix(el, "SPARK_MASTER_OPTS=") {
+			opts += " " + strings.TrimPrefix(el, "SPARK_MASTER_OPTS=")
+			continue
+		}
+		result = append(result, el)
+	}
+	return append(result, "SPARK_MASTER_OPTS="+opts)
+}
+
+// expandCommand - returns the command with ${NAME} references replaced by
+// the values of the NAME=VALUE environment, or of the daemon's environment
+func expandCommand(command []string, env []string) []string {
+	values := make(map[string]string)
+	for _, el := range env {
+		buff := strings.SplitN(el, "=", 2)
+		values[buff[0]] = buff[1]
+	}
+
+	result := make([]string, len(command))
+	for idx, el := range command {
+		result[idx] = os.Expand(el, func(name string) string {
+			if value, ok := values[name]; ok {
+				return value
+			}
+			return os.Getenv(name)
+		})
+	}
+	return result
+}
+
+// workDir - returns the directory holding the pid files and logs of the
+// nodes of the cluster
+func (e *LocalEnvironment) workDir() string {
+	dir := e.WorkDir
+	if len(dir) == 0 {
+		dir = filepath.Join(os.TempDir(), "allspark")
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(e.ClusterID)
+	if name == "." || name == ".." {
+		name = "_" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// workerPools - returns the worker pools of the cluster; templates
+// without pools have a default pool
+func (e *LocalEnvironment) workerPools() []WorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []WorkerPool{{Name: DefaultWorkerPool, Nodes: e.WorkerNodes}}
+	}
+	return e.WorkerPools
+}
+
+// startProcess - starts the command; the process leads a new process group
+// or joins the process group pgid, and is reaped once it exits
+func startProcess(command []string, env []string, logPath string, pgid int) (int, error) {
+	if len(command) == 0 || len(command[0]) == 0 {
+		return 0, errors.New("local command is empty")
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+
+	err = cmd.Start()
+	if err != nil {
+		return 0, err
+	}
+	go cmd.Wait()
+
+	return cmd.Process.Pid, nil
+}
+
+// startNode - starts the command of a node, and its monitor, in a new
+// process group whose id is recorded in the pid file of the node
+func (e *LocalEnvironment) startNode(name string, command []string, env []string) error {
+	dir := e.workDir()
+	pgid, err := startProcess(expandCommand(command, env), env,
+		filepath.Join(dir, name+".log"), 0)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, name+localPIDExtension),
+		[]byte(strconv.Itoa(pgid)), 0644)
+	if err != nil {
+		return err
+	}
+
+	if len(e.MonitorCommand) > 0 {
+		_, err = startProcess(expandCommand(e.MonitorCommand, env), env,
+			filepath.Join(dir, name+"-monitor.log"), pgid)
+	}
+	return err
+}
+
+// CreateCluster - creates a spark cluster of local processes
+func (e *LocalEnvironment) CreateCluster() (string, error) {
+	err := os.MkdirAll(e.workDir(), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	pools := e.workerPools()
+	var workers int64
+	for _, el := range pools {
+		workers += el.Nodes
+	}
+
+	ports, err := localPorts(3 + 2*int(workers))
+	if err != nil {
+		return "", err
+	}
+	masterPort, webUIPort, restPort := strconv.Itoa(ports[0]), strconv.Itoa(ports[1]), strconv.Itoa(ports[2])
+
+	envVariables := []string{"EXPECTED_WORKERS=" + strconv.FormatInt(workers, 10),
+		"CLUSTER_ID=" + e.ClusterID,
+		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL,
+		"SPARK_LOCAL_IP=" + localHost,
+		"SPARK_MASTER_HOST=" + localHost,
+		"SPARK_MASTER_PORT=" + masterPort,
+		"SPARK_MASTER_WEBUI_PORT=" + webUIPort,
+		"SPARK_MASTER_REST_PORT=" + restPort,
+		"MASTER_URL=spark://" + localHost + ":" + masterPort}
+	if e.MemBytes > 0 {
+		envVariables = append(envVariables,
+			"EXECUTOR_MEMORY="+computeExecutorMemory(e.MemBytes/1024/1024/1024),
+			"SPARK_WORKER_MEMORY="+strconv.FormatInt(e.MemBytes/1024/1024, 10)+"m")
+	}
+	if e.Cores > 0 {
+		envVariables = append(envVariables, "SPARK_WORKER_CORES="+strconv.FormatInt(e.Cores, 10))
+	}
+	envVariables = append(envVariables, masterOptsEnvParams(e.EnvParams, restPort)...)
+
+	masterCommand := e.MasterCommand
+	if len(masterCommand) == 0 {
+		masterCommand = defaultMasterCommand
+	}
+	err = e.startNode(e.ClusterID+masterIdentifier, masterCommand, envVariables)
+	if err != nil {
+		return "", err
+	}
+
+	if !netutil.IsListeningOnPort(localHost, ports[0], 5*time.Second, 60) {
+		return "", errors.New("master node has failed to come online")
+	}
+
+	workerCommand := e.WorkerCommand
+	if len(workerCommand) == 0 {
+		workerCommand = defaultWorkerCommand
+	}
+
+	envVariables = append([]string{"MASTER_IP=" + localHost}, envVariables...)
+	next := 3
+	for _, pool := range pools {
+		for i := int64(1); i <= pool.Nodes; i++ {
+			workerEnv := append(poolEnvParams(envVariables, pool),
+				"SPARK_WORKER_PORT="+strconv.Itoa(ports[next]),
+				"SPARK_WORKER_WEBUI_PORT="+strconv.Itoa(ports[next+1]))
+			next += 2
+
+			identifier := poolIdentifier(e.ClusterID, pool.Name) + strconv.FormatInt(i, 10)
+			err = e.startNode(identifier, workerCommand, workerEnv)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	logger.GetInfo().Printf("created local cluster %s with %v workers in %s",
+		e.ClusterID, workers, e.workDir())
+	return "http://" + localHost + ":" + webUIPort, nil
+}
+
+// nodeProcessGroups - returns the process groups of the nodes of the
+// cluster by node name, as recorded in their pid files
+func (e *LocalEnvironment) nodeProcessGroups() (map[string]int, error) {
+	files, err := filepath.Glob(filepath.Join(e.workDir(), "*"+localPIDExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]int)
+	for _, el := range files {
+		buffer, err := ioutil.ReadFile(el)
+		if err != nil {
+			return nil, err
+		}
+
+		pgid, err := strconv.Atoi(strings.TrimSpace(string(buffer)))
+		if err != nil || pgid <= 0 {
+			return nil, errors.New("invalid pid file " + el)
+		}
+		groups[strings.TrimSuffix(filepath.Base(el), localPIDExtension)] = pgid
+	}
+	return groups, nil
+}
+
+// processGroupAlive - returns true if a process of the group is running
+func processGroupAlive(pgid int) bool {
+	return syscall.Kill(-pgid, 0) == nil
+}
+
+// DestroyCluster - terminates the process groups of the nodes, killing
+// those which do not exit within the grace period
+func (e *LocalEnvironment) DestroyCluster() error {
+	groups, err := e.nodeProcessGroups()
+	if err != nil {
+		return err
+	}
+
+	for _, el := range groups {
+		syscall.Kill(-el, syscall.SIGTERM)
+	}
+
+	deadline := time.Now().Add(localTerminationGrace)
+	for _, el := range groups {
+		for processGroupAlive(el) && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if processGroupAlive(el) {
+			syscall.Kill(-el, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+// DestructionConfirmed - returns true if the cluster has been terminated; false otherwise
+func (e *LocalEnvironment) DestructionConfirmed() bool {
+	nodes, err := e.getClusterNodes()
+	if err != nil {
+		logger.GetError().Println(err)
+		logger.GetError().Printf("unable to confirm destruction of cluster %v", e.ClusterID)
+		return false
+	}
+
+	if len(nodes) > 0 {
+		return false
+	}
+
+	err = os.RemoveAll(e.workDir())
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+	return true
+}
+
+// GetResources - returns the compute resources requested by the cluster
+func (e *LocalEnvironment) GetResources() ClusterResources {
+	resources := ClusterResources{
+		Nodes:      1,
+		ShapeKnown: true,
+		Groups:     []NodeGroup{{InstanceType: LocalInstanceType, Nodes: 1}},
+	}
+
+	for _, el := range e.workerPools() {
+		if el.Nodes <= 0 {
+			continue
+		}
+		resources.Nodes += el.Nodes
+		resources.VCPU += float64(e.Cores * el.Nodes)
+		resources.MemoryGB += float64(e.MemBytes*el.Nodes) / (1 << 30)
+		resources.Groups = append(resources.Groups, NodeGroup{InstanceType: LocalInstanceType,
+			Nodes: el.Nodes, Pool: el.Name})
+	}
+	return resources
+}
+
+// getClusterNodes - returns the names of the nodes whose processes are running
+func (e *LocalEnvironment) getClusterNodes() ([]string, error) {
+	groups, err := e.nodeProcessGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for name, pgid := range groups {
+		if processGroupAlive(pgid) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,175 @@
+package cloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLocalHelperProcess - stands in for the spark master, workers and
+// monitors of local clusters; it prints its environment and runs until
+// it is terminated, the master listening on its spark port
+func TestLocalHelperProcess(t *testing.T) {
+	if os.Getenv("ALLSPARK_TEST_HELPER") != "1" {
+		return
+	}
+
+	fmt.Println(strings.Join(os.Environ(), "\n"))
+	if os.Args[len(os.Args)-1] == "master" {
+		listener, err := net.Listen("tcp", net.JoinHostPort(localHost, os.Getenv("SPARK_MASTER_PORT")))
+		if err != nil {
+			os.Exit(1)
+		}
+		defer listener.Close()
+	}
+	time.Sleep(time.Hour)
+}
+
+func helperCommand(role string) []string {
+	return []string{os.Args[0], "-test.run=TestLocalHelperProcess", "--", role}
+}
+
+// waitForLog - returns the log of a node once it contains the text
+func waitForLog(t *testing.T, path string, text string) string {
+	var contents []byte
+	for i := 0; i < 100; i++ {
+		contents, _ = ioutil.ReadFile(path)
+		if strings.Contains(string(contents), text) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return string(contents)
+}
+
+func TestLocalCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	templateConfig, err := ReadTemplateConfiguration("../dist/sample_templates/local.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Create(Local, templateConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := client.(*LocalEnvironment)
+	e.WorkDir = dir
+	e.MasterCommand = helperCommand("master")
+	e.WorkerCommand = helperCommand("worker")
+	e.MonitorCommand = helperCommand("monitor")
+	e.EnvParams = append(e.EnvParams, "ALLSPARK_TEST_HELPER=1")
+	e.WorkerNodes = 0
+	e.WorkerPools = []WorkerPool{{Name: DefaultWorkerPool, Nodes: 1}, {Name: "highmem", Nodes: 1}}
+
+	webURL, err := e.CreateCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.DestroyCluster()
+
+	clusterDir := filepath.Join(dir, e.ClusterID)
+	master := waitForLog(t, filepath.Join(clusterDir, e.ClusterID+"-master.log"), "CLUSTER_ID")
+	if !strings.Contains(master, "EXPECTED_WORKERS=2") ||
+		!strings.Contains(master, "EXECUTOR_MEMORY=1") ||
+		!strings.Contains(master, "SPARK_WORKER_CORES=1") ||
+		!strings.Contains(master, "PARAM=VALUE") ||
+		strings.Contains(master, "MASTER_IP=") {
+		t.Errorf("unexpected master environment %v", master)
+	}
+	if !strings.Contains(master, "SPARK_MASTER_WEBUI_PORT="+strings.TrimPrefix(webURL, "http://127.0.0.1:")) {
+		t.Errorf("expected the web url %v to use the master web ui port", webURL)
+	}
+	if !strings.Contains(master, "SPARK_MASTER_REST_PORT=") ||
+		!strings.Contains(master, "SPARK_MASTER_OPTS=-Dspark.master.rest.enabled=true -Dspark.master.rest.port=") {
+		t.Errorf("expected the master to enable the REST submission gateway: %v", master)
+	}
+
+	worker := waitForLog(t, filepath.Join(clusterDir, e.ClusterID+"-highmem-worker1.log"), "CLUSTER_ID")
+	if !strings.Contains(worker, "MASTER_IP=127.0.0.1") ||
+		!strings.Contains(worker, "MASTER_URL=spark://127.0.0.1:") ||
+		!strings.Contains(worker, "WORKER_POOL=highmem") ||
+		!strings.Contains(worker, "SPARK_WORKER_WEBUI_PORT=") {
+		t.Errorf("unexpected worker environment %v", worker)
+	}
+
+	monitor := waitForLog(t, filepath.Join(clusterDir, e.ClusterID+"-worker1-monitor.log"), "CLUSTER_ID")
+	if !strings.Contains(monitor, "WORKER_POOL=default") {
+		t.Errorf("expected monitors to share the environment of their node: %v", monitor)
+	}
+
+	nodes, err := e.getClusterNodes()
+	if err != nil || len(nodes) != 3 {
+		t.Errorf("expected 3 running nodes, got %v: %v", nodes, err)
+	}
+
+	resources := e.GetResources()
+	if resources.Nodes != 3 || resources.VCPU != 2 || resources.MemoryGB != 4 {
+		t.Errorf("unexpected resources %+v", resources)
+	}
+	if cost, priced := HourlyCost(resources); cost != 0 || !priced {
+		t.Errorf("expected local clusters to be free, got %v", cost)
+	}
+
+	if e.DestructionConfirmed() {
+		t.Error("expected destruction to be unconfirmed while nodes run")
+	}
+
+	err = e.DestroyCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.DestructionConfirmed() {
+		t.Error("expected destruction to be confirmed once all process groups exited")
+	}
+	if _, err = os.Stat(clusterDir); !os.IsNotExist(err) {
+		t.Error("expected the work directory to be removed")
+	}
+}
+
+func TestLocalCommands(t *testing.T) {
+	command := expandCommand([]string{"${SPARK_HOME}/bin/spark-class", "--port", "${SPARK_MASTER_PORT}"},
+		[]string{"SPARK_HOME=/opt/spark", "SPARK_MASTER_PORT=41000"})
+	if strings.Join(command, " ") != "/opt/spark/bin/spark-class --port 41000" {
+		t.Errorf("unexpected command %v", command)
+	}
+
+	os.Setenv("ALLSPARK_TEST_HOME", "/opt/allspark")
+	defer os.Unsetenv("ALLSPARK_TEST_HOME")
+	if expandCommand([]string{"${ALLSPARK_TEST_HOME}/run"}, nil)[0] != "/opt/allspark/run" {
+		t.Error("expected the daemon environment to be expanded")
+	}
+
+	env := masterOptsEnvParams([]string{"PARAM=VALUE", "SPARK_MASTER_OPTS=-Dspark.deploy.spreadOut=false"}, "41002")
+	if len(env) != 2 || env[0] != "PARAM=VALUE" || env[1] != "SPARK_MASTER_OPTS=-Dspark.master.rest.enabled=true "+
+		"-Dspark.master.rest.port=41002 -Dspark.deploy.spreadOut=false" {
+		t.Errorf("unexpected master options %v", env)
+	}
+
+	e := LocalEnvironment{ClusterID: "../run-1", WorkDir: "/tmp/allspark"}
+	if e.workDir() != "/tmp/allspark/.._run-1" {
+		t.Errorf("unexpected work dir %v", e.workDir())
+	}
+
+	ports, err := localPorts(4)
+	if err != nil || len(ports) != 4 {
+		t.Fatalf("unexpected ports %v: %v", ports, err)
+	}
+	seen := make(map[int]bool)
+	for _, el := range ports {
+		if seen[el] {
+			t.Errorf("port %v allocated more than once", el)
+		}
+		seen[el] = true
+	}
+}
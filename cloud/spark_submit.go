@@ -0,0 +1,303 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"allspark/util/serializer"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SparkJobSpec - describes a spark application submitted to a cluster;
+// SparkVersion is reported to the submission gateway and defaults to the
+// daemon's SparkVersion
+type SparkJobSpec struct {
+	Name               string
+	SparkVersion       string
+	AppResource        string
+	MainClass          string
+	PythonFile         string
+	PyFiles            []string
+	Args               []string
+	Conf               map[string]string
+	EnvVariables       map[string]string
+	DriverMemory       string
+	DriverCores        int
+	ExecutorMemory     string
+	ExecutorCores      int
+	TotalExecutorCores int
+}
+
+// Spark driver states reported by the standalone REST submission gateway
+const (
+	DriverStateSubmitted   = "SUBMITTED"
+	DriverStateRunning     = "RUNNING"
+	DriverStateFinished    = "FINISHED"
+	DriverStateRelaunching = "RELAUNCHING"
+	DriverStateUnknown     = "UNKNOWN"
+	DriverStateKilled      = "KILLED"
+	DriverStateFailed      = "FAILED"
+	DriverStateError       = "ERROR"
+)
+
+const (
+	sparkRestPort       = 6066
+	defaultSparkVersion = "2.4.4"
+	pythonRunnerClass   = "org.apache.spark.deploy.PythonRunner"
+	defaultSparkJob     = "allspark-job"
+)
+
+type sparkSubmissionRequest struct {
+	Action               string            `json:"action"`
+	AppResource          string            `json:"appResource"`
+	MainClass            string            `json:"mainClass"`
+	AppArgs              []string          `json:"appArgs"`
+	ClientSparkVersion   string            `json:"clientSparkVersion"`
+	EnvironmentVariables map[string]string `json:"environmentVariables"`
+	SparkProperties      map[string]string `json:"sparkProperties"`
+}
+
+type sparkSubmissionResponse struct {
+	Action       string `json:"action"`
+	Message      string `json:"message"`
+	SubmissionID string `json:"submissionId"`
+	DriverState  string `json:"driverState"`
+	Success      bool   `json:"success"`
+}
+
+var sparkRestClient = &http.Client{Timeout: 5 * time.Second}
+
+// IsDriverStateTerminal - returns true if the driver will not change state again
+func IsDriverStateTerminal(state string) bool {
+	switch state {
+	case DriverStateFinished, DriverStateKilled,
+		DriverStateFailed, DriverStateError:
+		return true
+	}
+	return false
+}
+
+// IsDriverStateFailed - returns true if the driver has exited unsuccessfully
+func IsDriverStateFailed(state string) bool {
+	return state == DriverStateFailed || state == DriverStateError
+}
+
+// SparkMaster - the addresses of a spark master; Port is the port of the
+// master and RestPort the port of its REST submission gateway
+type SparkMaster struct {
+	Host     string
+	Port     int
+	RestPort int
+}
+
+// GetSparkMaster - returns the spark master reported by the cluster during
+// check-in; ports not reported default to the standalone defaults
+func GetSparkMaster(status SparkClusterStatus) (SparkMaster, error) {
+	masterURL, err := url.Parse(status.URL)
+	if err != nil {
+		return SparkMaster{}, err
+	}
+
+	if len(masterURL.Hostname()) == 0 {
+		return SparkMaster{}, errors.New("unable to resolve spark master from url " + status.URL)
+	}
+
+	master := SparkMaster{Host: masterURL.Hostname(), Port: sparkMasterPort, RestPort: sparkRestPort}
+	if len(masterURL.Port()) > 0 {
+		master.Port, err = strconv.Atoi(masterURL.Port())
+		if err != nil {
+			return SparkMaster{}, err
+		}
+	}
+	if status.RestPort > 0 {
+		master.RestPort = status.RestPort
+	}
+
+	return master, nil
+}
+
+// ValidateSparkJobSpec - verifies a job specification can be submitted
+func ValidateSparkJobSpec(spec SparkJobSpec) error {
+	if len(spec.PythonFile) > 0 {
+		return nil
+	}
+
+	if len(spec.AppResource) == 0 || len(spec.MainClass) == 0 {
+		return errors.New("invalid job specification; either PythonFile or " +
+			"AppResource and MainClass must be specified")
+	}
+
+	return nil
+}
+
+func getSparkRestURL(master SparkMaster) string {
+	return "http://" + net.JoinHostPort(master.Host,
+		strconv.Itoa(master.RestPort)) + "/v1/submissions"
+}
+
+// getSparkVersion - returns the spark version the job is submitted with;
+// defaults to the version installed by the allspark-compute image
+func getSparkVersion(spec SparkJobSpec) string {
+	if len(spec.SparkVersion) > 0 {
+		return spec.SparkVersion
+	}
+
+	if version := daemon.GetAllSparkConfig().SparkVersion; len(version) > 0 {
+		return version
+	}
+
+	return defaultSparkVersion
+}
+
+func newSparkSubmissionRequest(master SparkMaster,
+	spec SparkJobSpec) sparkSubmissionRequest {
+
+	name := spec.Name
+	if len(name) == 0 {
+		name = defaultSparkJob
+	}
+
+	properties := map[string]string{
+		"spark.app.name":          name,
+		"spark.master":            "spark://" + net.JoinHostPort(master.Host, strconv.Itoa(master.Port)),
+		"spark.submit.deployMode": "cluster",
+		"spark.driver.supervise":  "false",
+	}
+
+	request := sparkSubmissionRequest{
+		Action:               "CreateSubmissionRequest",
+		AppResource:          spec.AppResource,
+		MainClass:            spec.MainClass,
+		AppArgs:              spec.Args,
+		ClientSparkVersion:   getSparkVersion(spec),
+		EnvironmentVariables: spec.EnvVariables,
+		SparkProperties:      properties,
+	}
+
+	if len(spec.PythonFile) > 0 {
+		pyFiles := ""
+		for idx, el := range spec.PyFiles {
+			if idx > 0 {
+				pyFiles += ","
+			}
+			pyFiles += el
+		}
+
+		request.AppResource = spec.PythonFile
+		request.MainClass = pythonRunnerClass
+		request.AppArgs = append([]string{spec.PythonFile, pyFiles}, spec.Args...)
+		if len(pyFiles) > 0 {
+			properties["spark.submit.pyFiles"] = pyFiles
+		}
+	} else {
+		properties["spark.jars"] = spec.AppResource
+	}
+
+	if len(spec.DriverMemory) > 0 {
+		properties["spark.driver.memory"] = spec.DriverMemory
+	}
+	if spec.DriverCores > 0 {
+		properties["spark.driver.cores"] = strconv.Itoa(spec.DriverCores)
+	}
+	if len(spec.ExecutorMemory) > 0 {
+		properties["spark.executor.memory"] = spec.ExecutorMemory
+	}
+	if spec.ExecutorCores > 0 {
+		properties["spark.executor.cores"] = strconv.Itoa(spec.ExecutorCores)
+	}
+	if spec.TotalExecutorCores > 0 {
+		properties["spark.cores.max"] = strconv.Itoa(spec.TotalExecutorCores)
+	}
+
+	for key, value := range spec.Conf {
+		properties[key] = value
+	}
+
+	if request.AppArgs == nil {
+		request.AppArgs = []string{}
+	}
+	if request.EnvironmentVariables == nil {
+		request.EnvironmentVariables = map[string]string{}
+	}
+
+	return request
+}
+
+func callSparkRestAPI(method string, url string,
+	body []byte) (sparkSubmissionResponse, error) {
+
+	var response sparkSubmissionResponse
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+
+	resp, err := sparkRestClient.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+
+	err = serializer.Deserialize(contents, &response)
+	if err != nil {
+		return response, err
+	}
+
+	if !response.Success {
+		return response, errors.New("spark submission gateway returned an " +
+			"unsuccessful response: " + response.Message)
+	}
+
+	return response, nil
+}
+
+// SubmitSparkJob - submits a spark application to the standalone REST
+// submission gateway on the master; returns the submission ID
+func SubmitSparkJob(master SparkMaster, spec SparkJobSpec) (string, error) {
+	err := ValidateSparkJobSpec(spec)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := serializer.Serialize(newSparkSubmissionRequest(master, spec))
+	if err != nil {
+		return "", err
+	}
+
+	response, err := callSparkRestAPI("POST", getSparkRestURL(master)+"/create", body)
+	if err != nil {
+		return "", err
+	}
+
+	return response.SubmissionID, nil
+}
+
+// GetSparkJobState - returns the driver state of a submitted spark application
+func GetSparkJobState(master SparkMaster, submissionID string) (string, error) {
+	response, err := callSparkRestAPI("GET",
+		getSparkRestURL(master)+"/status/"+submissionID, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return response.DriverState, nil
+}
+
+// KillSparkJob - requests the termination of a submitted spark application
+func KillSparkJob(master SparkMaster, submissionID string) error {
+	_, err := callSparkRestAPI("POST",
+		getSparkRestURL(master)+"/kill/"+submissionID, nil)
+	return err
+}
@@ -0,0 +1,107 @@
+package cloud
+
+import (
+	"testing"
+)
+
+func TestGetSparkMaster(t *testing.T) {
+	master, err := GetSparkMaster(SparkClusterStatus{
+		URL: "spark://ip-172-30-0-100.us-west-2.compute.internal:7077",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if master.Host != "ip-172-30-0-100.us-west-2.compute.internal" {
+		t.Error("unexpected master host: " + master.Host)
+	}
+
+	if master.Port != 7077 || master.RestPort != 6066 ||
+		getSparkRestURL(master) != "http://ip-172-30-0-100.us-west-2.compute.internal:6066/v1/submissions" {
+		t.Errorf("unexpected default ports %+v", master)
+	}
+
+	master, err = GetSparkMaster(SparkClusterStatus{URL: "spark://127.0.0.1:41000", RestPort: 41002})
+	if err != nil || master.Port != 41000 || master.RestPort != 41002 {
+		t.Errorf("expected the reported ports to be used, got %+v: %v", master, err)
+	}
+
+	_, err = GetSparkMaster(SparkClusterStatus{})
+	if err == nil {
+		t.Error("expected non-nil error for empty master url")
+	}
+}
+
+func TestValidateSparkJobSpec(t *testing.T) {
+	if ValidateSparkJobSpec(SparkJobSpec{}) == nil {
+		t.Error("expected empty job specification to be invalid")
+	}
+
+	if ValidateSparkJobSpec(SparkJobSpec{AppResource: "s3://bucket/app.jar"}) == nil {
+		t.Error("expected job specification without main class to be invalid")
+	}
+
+	if ValidateSparkJobSpec(SparkJobSpec{AppResource: "s3://bucket/app.jar",
+		MainClass: "com.example.App"}) != nil {
+		t.Error("expected jar job specification to be valid")
+	}
+
+	if ValidateSparkJobSpec(SparkJobSpec{PythonFile: "s3://bucket/app.py"}) != nil {
+		t.Error("expected python job specification to be valid")
+	}
+}
+
+func TestNewSparkSubmissionRequest(t *testing.T) {
+	request := newSparkSubmissionRequest(SparkMaster{Host: "10.0.0.1", Port: 7077, RestPort: 6066}, SparkJobSpec{
+		AppResource:    "s3://bucket/app.jar",
+		MainClass:      "com.example.App",
+		Args:           []string{"--date", "2020-01-01"},
+		ExecutorMemory: "4g",
+		Conf:           map[string]string{"spark.executor.memory": "8g"},
+	})
+
+	if request.SparkProperties["spark.master"] != "spark://10.0.0.1:7077" {
+		t.Error("unexpected spark master: " + request.SparkProperties["spark.master"])
+	}
+
+	if request.SparkProperties["spark.jars"] != "s3://bucket/app.jar" {
+		t.Error("unexpected spark jars: " + request.SparkProperties["spark.jars"])
+	}
+
+	if request.SparkProperties["spark.executor.memory"] != "8g" {
+		t.Error("expected conf to override executor memory")
+	}
+
+	if len(request.AppArgs) != 2 {
+		t.Error("unexpected app args")
+	}
+
+	if request.ClientSparkVersion != defaultSparkVersion {
+		t.Error("unexpected client spark version: " + request.ClientSparkVersion)
+	}
+
+	request = newSparkSubmissionRequest(SparkMaster{Host: "10.0.0.1", Port: 41000}, SparkJobSpec{
+		PythonFile:   "s3://bucket/app.py",
+		PyFiles:      []string{"s3://bucket/a.py", "s3://bucket/b.py"},
+		Args:         []string{"--verbose"},
+		SparkVersion: "3.1.2",
+	})
+
+	if request.SparkProperties["spark.master"] != "spark://10.0.0.1:41000" {
+		t.Error("expected the master port to be used: " + request.SparkProperties["spark.master"])
+	}
+
+	if request.ClientSparkVersion != "3.1.2" {
+		t.Error("expected job spark version to be reported: " + request.ClientSparkVersion)
+	}
+
+	if request.MainClass != pythonRunnerClass {
+		t.Error("unexpected main class: " + request.MainClass)
+	}
+
+	if len(request.AppArgs) != 3 ||
+		request.AppArgs[0] != "s3://bucket/app.py" ||
+		request.AppArgs[1] != "s3://bucket/a.py,s3://bucket/b.py" {
+		t.Errorf("unexpected app args: %v", request.AppArgs)
+	}
+}
@@ -0,0 +1,229 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"allspark/logger"
+	"allspark/util/netutil"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StaticEnvironment interface; clusters lease hosts of the static inventory
+// of the daemon, one host per node, and run spark containers on them over
+// SSH. NanoCpus and MemBytes describe the worker containers and, unless
+// overridden, the master container and worker pools. HostGroup selects the
+// inventory hosts of the master and of pools without a group
+type StaticEnvironment struct {
+	NanoCpus       int64
+	MemBytes       int64
+	MasterNanoCpus int64 `json:",omitempty"`
+	MasterMemBytes int64 `json:",omitempty"`
+	ClusterID      string
+	HostGroup      string `json:",omitempty"`
+	WorkerNodes    int64
+	Image          string
+	Volumes        []string `json:",omitempty"`
+	EnvParams      []string
+	RetryPolicy    *RetryPolicy
+	WorkerPools    []StaticWorkerPool `json:",omitempty"`
+}
+
+// StaticWorkerPool - a worker pool of a static cluster
+type StaticWorkerPool struct {
+	WorkerPool
+	HostGroup string `json:",omitempty"`
+	NanoCpus  int64  `json:",omitempty"`
+	MemBytes  int64  `json:",omitempty"`
+}
+
+// StaticInstanceType - instance type reported for static hosts
+const StaticInstanceType = "static"
+
+// masterResources - returns the cpu and memory of the master container
+func (e *StaticEnvironment) masterResources() (int64, int64) {
+	nanoCpus, memBytes := e.NanoCpus, e.MemBytes
+	if e.MasterNanoCpus > 0 {
+		nanoCpus = e.MasterNanoCpus
+	}
+	if e.MasterMemBytes > 0 {
+		memBytes = e.MasterMemBytes
+	}
+	return nanoCpus, memBytes
+}
+
+// workerPools - returns the worker pools of the cluster with the
+// resources and host group of the template applied; templates without
+// pools have a default pool
+func (e *StaticEnvironment) workerPools() []StaticWorkerPool {
+	if len(e.WorkerPools) == 0 {
+		return []StaticWorkerPool{{
+			WorkerPool: WorkerPool{Name: DefaultWorkerPool, Nodes: e.WorkerNodes},
+			HostGroup:  e.HostGroup,
+			NanoCpus:   e.NanoCpus,
+			MemBytes:   e.MemBytes,
+		}}
+	}
+
+	pools := make([]StaticWorkerPool, len(e.WorkerPools))
+	for idx, el := range e.WorkerPools {
+		if len(el.HostGroup) == 0 {
+			el.HostGroup = e.HostGroup
+		}
+		if el.NanoCpus == 0 {
+			el.NanoCpus = e.NanoCpus
+		}
+		if el.MemBytes == 0 {
+			el.MemBytes = e.MemBytes
+		}
+		pools[idx] = el
+	}
+	return pools
+}
+
+// leaseClusterHosts - leases the hosts of the master and of each pool;
+// hosts leased before a failure remain leased until the cluster is
+// destroyed
+func (e *StaticEnvironment) leaseClusterHosts(pools []StaticWorkerPool) (daemon.StaticHost,
+	[][]daemon.StaticHost, error) {
+
+	master, err := leaseHosts(e.ClusterID, e.HostGroup, 1)
+	if err != nil {
+		return daemon.StaticHost{}, nil, err
+	}
+
+	workers := make([][]daemon.StaticHost, len(pools))
+	for idx, el := range pools {
+		workers[idx], err = leaseHosts(e.ClusterID, el.HostGroup, el.Nodes)
+		if err != nil {
+			return daemon.StaticHost{}, nil, err
+		}
+	}
+
+	return master[0], workers, nil
+}
+
+// CreateCluster - creates a spark cluster on hosts of the static inventory
+func (e *StaticEnvironment) CreateCluster() (string, error) {
+	pools := e.workerPools()
+
+	master, workers, err := e.leaseClusterHosts(pools)
+	if err != nil {
+		return "", err
+	}
+
+	var workerCount, executorMemBytes int64
+	for _, el := range pools {
+		workerCount += el.Nodes
+		if el.Nodes > 0 && (executorMemBytes == 0 || el.MemBytes < executorMemBytes) {
+			executorMemBytes = el.MemBytes
+		}
+	}
+
+	envVariables := []string{"EXPECTED_WORKERS=" + strconv.FormatInt(workerCount, 10),
+		"SPARK_WORKER_PORT=" + strconv.FormatInt(sparkWorkerPort, 10),
+		"CLUSTER_ID=" + e.ClusterID,
+		"EXECUTOR_MEMORY=" + computeExecutorMemory(executorMemBytes/1024/1024/1024),
+		"ALLSPARK_CALLBACK=" + daemon.GetAllSparkConfig().CallbackURL}
+	envVariables = append(envVariables, e.EnvParams...)
+
+	masterNanoCpus, masterMemBytes := e.masterResources()
+	_, err = runRemote(master, dockerRunCommand(e.ClusterID, e.ClusterID+masterIdentifier,
+		e.Image, envVariables, nil, e.Volumes, masterNanoCpus, masterMemBytes))
+	if err != nil {
+		return "", err
+	}
+
+	masterIP := hostName(master.Address)
+	if !netutil.IsListeningOnPort(masterIP, sparkMasterPort, 30*time.Second, 120) {
+		return "", errors.New("master node has failed to come online")
+	}
+
+	envVariables = append([]string{"MASTER_IP=" + masterIP}, envVariables...)
+	for idx, pool := range pools {
+		poolEnv := poolEnvParams(envVariables, pool.WorkerPool)
+		for i, host := range workers[idx] {
+			identifier := poolIdentifier(e.ClusterID, pool.Name) + strconv.Itoa(i+1)
+			_, err = runRemote(host, dockerRunCommand(e.ClusterID, identifier, e.Image,
+				poolEnv, pool.Labels, e.Volumes, pool.NanoCpus, pool.MemBytes))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	logger.GetInfo().Printf("created static cluster %s on %s with %v workers",
+		e.ClusterID, master.Address, workerCount)
+	return "http://" + masterIP + ":8080", nil
+}
+
+// DestroyCluster - removes the spark containers of the cluster and
+// returns its hosts to the inventory; every host is cleaned up, and hosts
+// which could not be cleaned up stay leased to the cluster
+func (e *StaticEnvironment) DestroyCluster() error {
+	hosts, err := leasedHosts(e.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, el := range hosts {
+		_, err = runRemote(inventoryHost(el), dockerRemoveClusterCommand(e.ClusterID))
+		if err == nil {
+			err = releaseHost(e.ClusterID, el)
+		}
+		if err != nil {
+			logger.GetError().Printf("unable to clean up host %v of cluster %v: %v", el, e.ClusterID, err)
+			failures = append(failures, el+": "+err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New("failed to clean up " + strconv.Itoa(len(failures)) +
+			" hosts; " + strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// DestructionConfirmed - returns true if the cluster has been terminated; false otherwise
+func (e *StaticEnvironment) DestructionConfirmed() bool {
+	hosts, err := leasedHosts(e.ClusterID)
+	if err != nil {
+		logger.GetError().Println(err)
+		logger.GetError().Printf("unable to confirm destruction of cluster %v", e.ClusterID)
+		return false
+	}
+
+	return len(hosts) == 0
+}
+
+// GetResources - returns the compute resources requested by the cluster
+func (e *StaticEnvironment) GetResources() ClusterResources {
+	nanoCpus, memBytes := e.masterResources()
+	resources := ClusterResources{
+		Nodes:      1,
+		ShapeKnown: true,
+		Groups:     []NodeGroup{{InstanceType: StaticInstanceType, Nodes: 1}},
+	}
+
+	for _, el := range e.workerPools() {
+		if el.Nodes <= 0 {
+			continue
+		}
+		resources.Nodes += el.Nodes
+		nanoCpus += el.NanoCpus * el.Nodes
+		memBytes += el.MemBytes * el.Nodes
+		resources.Groups = append(resources.Groups, NodeGroup{InstanceType: StaticInstanceType,
+			Nodes: el.Nodes, Pool: el.Name})
+	}
+
+	resources.VCPU = float64(nanoCpus) / 1e9
+	resources.MemoryGB = float64(memBytes) / (1 << 30)
+	return resources
+}
+
+// getClusterNodes - returns the addresses of the hosts leased to the cluster
+func (e *StaticEnvironment) getClusterNodes() ([]string, error) {
+	return leasedHosts(e.ClusterID)
+}
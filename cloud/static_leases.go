@@ -0,0 +1,93 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"allspark/datastore"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+// leases of the static inventory; maps host addresses to the cluster
+// holding them
+const staticLeases = "allspark_static_leases"
+
+// releases a lease only if it is still held by the cluster
+var releaseLease = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// inventoryHost - returns the inventory entry of the address; hosts removed
+// from the inventory while leased keep the inventory's user
+func inventoryHost(address string) daemon.StaticHost {
+	for _, el := range daemon.GetAllSparkConfig().StaticInventory.Hosts {
+		if el.Address == address {
+			return el
+		}
+	}
+	return daemon.StaticHost{Address: address}
+}
+
+// leaseHosts - leases count free hosts of the group to the cluster; hosts
+// are leased one at a time so two clusters never hold the same host
+func leaseHosts(clusterID string, group string, count int64) ([]daemon.StaticHost, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	var leased []daemon.StaticHost
+	for _, el := range daemon.GetAllSparkConfig().StaticInventory.Hosts {
+		if el.Group != group {
+			continue
+		}
+
+		ok, err := redisClient.HSetNX(staticLeases, el.Address, clusterID).Result()
+		if err != nil {
+			return leased, err
+		}
+		if ok {
+			leased = append(leased, el)
+			if int64(len(leased)) == count {
+				return leased, nil
+			}
+		}
+	}
+
+	return leased, errors.New("static inventory has " + strconv.Itoa(len(leased)) +
+		" free hosts in group '" + group + "'; " + strconv.FormatInt(count, 10) + " requested")
+}
+
+// leasedHosts - returns the addresses of the hosts leased to the cluster
+func leasedHosts(clusterID string) ([]string, error) {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	leases, err := redisClient.HGetAll(staticLeases).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for address, owner := range leases {
+		if owner == clusterID {
+			result = append(result, address)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// releaseHost - returns the host to the inventory
+func releaseHost(clusterID string, address string) error {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	return releaseLease.Run(redisClient, []string{staticLeases}, address, clusterID).Err()
+}
@@ -0,0 +1,145 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultSSHPort = "22"
+
+// sshAddress - returns host:port of the SSH server of the host
+func sshAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, defaultSSHPort)
+}
+
+// hostName - returns the host of the address, without its SSH port
+func hostName(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// sshConfig - returns the client configuration the daemon connects to
+// hosts of the inventory with
+func sshConfig(inventory daemon.StaticInventory, user string) (*ssh.ClientConfig, error) {
+	key, err := ioutil.ReadFile(inventory.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(inventory.KnownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(user) == 0 {
+		user = inventory.User
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// runRemote - runs the command on the host over SSH and returns its output
+func runRemote(host daemon.StaticHost, command string) (string, error) {
+	config, err := sshConfig(daemon.GetAllSparkConfig().StaticInventory, host.User)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := ssh.Dial("tcp", sshAddress(host.Address), config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	err = session.Run(command)
+	if err != nil {
+		return "", errors.New(host.Address + ": " + err.Error() + ": " +
+			strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// shellQuote - returns the value quoted for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
+
+// container label identifying the cluster of spark containers on static hosts
+const staticClusterLabel = "allspark.cluster-id"
+
+// dockerRunCommand - returns the command starting a spark container of the
+// cluster on the host network of a static host; a container of the same
+// name left over from a previous launch is replaced
+func dockerRunCommand(clusterID string, name string, image string, env []string,
+	labels map[string]string, volumes []string, nanoCpus int64, memBytes int64) string {
+
+	args := []string{"docker", "run", "-d", "--name", shellQuote(name), "--network", "host",
+		"--cpus", strconv.FormatFloat(float64(nanoCpus)/1e9, 'f', -1, 64),
+		"--memory", strconv.FormatInt(memBytes, 10),
+		"--label", shellQuote(staticClusterLabel + "=" + clusterID)}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, el := range keys {
+		args = append(args, "--label", shellQuote(el+"="+labels[el]))
+	}
+	for _, el := range env {
+		args = append(args, "-e", shellQuote(el))
+	}
+	for _, el := range volumes {
+		args = append(args, "-v", shellQuote(el))
+	}
+	args = append(args, shellQuote(image))
+
+	return dockerRemoveCommand(name) + "; " + strings.Join(args, " ")
+}
+
+// dockerRemoveCommand - returns the command removing a spark container
+func dockerRemoveCommand(name string) string {
+	return "docker rm -f " + shellQuote(name) + " >/dev/null 2>&1"
+}
+
+// dockerRemoveClusterCommand - returns the command removing the spark
+// containers of the cluster
+func dockerRemoveClusterCommand(clusterID string) string {
+	return "docker ps -aq --filter " + shellQuote("label="+staticClusterLabel+"="+clusterID) +
+		" | xargs -r docker rm -f"
+}
@@ -0,0 +1,372 @@
+package cloud
+
+import (
+	"allspark/daemon"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSSHServer - an SSH server recording the commands it is asked to run;
+// commands containing "fail" exit unsuccessfully
+type fakeSSHServer struct {
+	sync.Mutex
+	listener net.Listener
+	commands []string
+}
+
+func newFakeSSHServer(t *testing.T, clientKey ssh.PublicKey) (*fakeSSHServer, ssh.PublicKey) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "allspark" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSSHServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+
+	return server, hostSigner.PublicKey()
+}
+
+func (s *fakeSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		for req := range channelRequests {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+
+			command := string(req.Payload[4:])
+			s.Lock()
+			s.commands = append(s.commands, command)
+			s.Unlock()
+			req.Reply(true, nil)
+
+			status := make([]byte, 4)
+			if strings.Contains(command, "fail") {
+				channel.Stderr().Write([]byte("boom\n"))
+				binary.BigEndian.PutUint32(status, 1)
+			} else {
+				channel.Write([]byte("ok\n"))
+			}
+			channel.SendRequest("exit-status", false, status)
+			channel.Close()
+			break
+		}
+	}
+}
+
+// setStaticInventory - loads a daemon configuration whose inventory holds
+// the hosts, reachable with a generated client key
+func setStaticInventory(t *testing.T, dir string, hosts []daemon.StaticHost,
+	hostKeys map[string]ssh.PublicKey, clientKey *ecdsa.PrivateKey) {
+
+	der, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ecdsa")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var knownHosts strings.Builder
+	for address, key := range hostKeys {
+		knownHosts.WriteString(knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n")
+	}
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	err = ioutil.WriteFile(knownHostsPath, []byte(knownHosts.String()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := daemon.GetAllSparkConfig()
+	config.StaticInventory = daemon.StaticInventory{
+		User:           "allspark",
+		PrivateKeyPath: keyPath,
+		KnownHostsPath: knownHostsPath,
+		Hosts:          hosts,
+	}
+	buffer, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "allspark_config.json")
+	err = ioutil.WriteFile(configPath, buffer, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.Init(configPath)
+}
+
+func TestStaticRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer daemon.Init("../daemon/allspark_config.json")
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, hostKey := newFakeSSHServer(t, clientPublicKey)
+	defer server.listener.Close()
+	address := server.listener.Addr().String()
+
+	setStaticInventory(t, dir, []daemon.StaticHost{{Address: address}},
+		map[string]ssh.PublicKey{address: hostKey}, clientKey)
+
+	output, err := runRemote(inventoryHost(address), "docker ps")
+	if err != nil || output != "ok" {
+		t.Errorf("unexpected output %v: %v", output, err)
+	}
+	if len(server.commands) != 1 || server.commands[0] != "docker ps" {
+		t.Errorf("unexpected commands %v", server.commands)
+	}
+
+	_, err = runRemote(inventoryHost(address), "fail")
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected failed commands to report their error output: %v", err)
+	}
+
+	_, err = runRemote(daemon.StaticHost{Address: address, User: "root"}, "docker ps")
+	if err == nil {
+		t.Error("expected unauthorized users to be rejected")
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherHostKey, _ := ssh.NewPublicKey(&otherKey.PublicKey)
+	setStaticInventory(t, dir, []daemon.StaticHost{{Address: address}},
+		map[string]ssh.PublicKey{address: otherHostKey}, clientKey)
+	_, err = runRemote(inventoryHost(address), "docker ps")
+	if err == nil {
+		t.Error("expected unknown host keys to be rejected")
+	}
+}
+
+func TestStaticCommands(t *testing.T) {
+	if shellQuote("it's") != `'it'"'"'s'` {
+		t.Errorf("unexpected quoting %v", shellQuote("it's"))
+	}
+
+	if sshAddress("10.0.0.5") != "10.0.0.5:22" || sshAddress("10.0.0.5:2222") != "10.0.0.5:2222" {
+		t.Error("unexpected ssh address")
+	}
+	if hostName("10.0.0.5:2222") != "10.0.0.5" || hostName("spark-01") != "spark-01" {
+		t.Error("unexpected host name")
+	}
+
+	command := dockerRunCommand("c1", "c1-worker1", "spark:latest",
+		[]string{"MASTER_IP=10.0.0.5", "PARAM=a b;c"}, map[string]string{"tier": "batch"},
+		[]string{"/data:/data:ro"}, 1500000000, 1<<30)
+	for _, el := range []string{
+		"docker rm -f 'c1-worker1' >/dev/null 2>&1; docker run -d --name 'c1-worker1' --network host",
+		"--cpus 1.5 --memory 1073741824",
+		"--label 'allspark.cluster-id=c1' --label 'tier=batch'",
+		"-e 'MASTER_IP=10.0.0.5' -e 'PARAM=a b;c'",
+		"-v '/data:/data:ro' 'spark:latest'",
+	} {
+		if !strings.Contains(command, el) {
+			t.Errorf("expected %v in %v", el, command)
+		}
+	}
+
+	if dockerRemoveClusterCommand("c1") !=
+		"docker ps -aq --filter 'label=allspark.cluster-id=c1' | xargs -r docker rm -f" {
+		t.Errorf("unexpected remove command %v", dockerRemoveClusterCommand("c1"))
+	}
+
+	e := StaticEnvironment{NanoCpus: 2e9, MemBytes: 4 << 30, MasterMemBytes: 8 << 30,
+		WorkerNodes: 3, HostGroup: "spark"}
+	resources := e.GetResources()
+	if resources.Nodes != 4 || resources.VCPU != 8 || resources.MemoryGB != 20 ||
+		resources.Groups[0].InstanceType != StaticInstanceType {
+		t.Errorf("unexpected resources %+v", resources)
+	}
+
+	e.WorkerNodes = 0
+	e.WorkerPools = []StaticWorkerPool{{WorkerPool: WorkerPool{Name: "gpu", Nodes: 1}, HostGroup: "gpu"},
+		{WorkerPool: WorkerPool{Name: "cpu", Nodes: 2}}}
+	pools := e.workerPools()
+	if pools[0].HostGroup != "gpu" || pools[1].HostGroup != "spark" || pools[1].MemBytes != 4<<30 {
+		t.Errorf("unexpected worker pools %+v", pools)
+	}
+}
+
+func TestStaticLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer daemon.Init("../daemon/allspark_config.json")
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []daemon.StaticHost{
+		{Address: "static-test-1", Group: "static-test"},
+		{Address: "static-test-2", Group: "static-test"},
+		{Address: "static-test-3", Group: "static-test"},
+	}
+	setStaticInventory(t, dir, hosts, nil, clientKey)
+
+	first, err := leaseHosts("static-cluster-1", "static-test", 2)
+	if err != nil || len(first) != 2 {
+		t.Fatalf("expected 2 leased hosts, got %v: %v", first, err)
+	}
+	defer func() {
+		for _, el := range hosts {
+			releaseHost("static-cluster-1", el.Address)
+			releaseHost("static-cluster-2", el.Address)
+		}
+	}()
+
+	second, err := leaseHosts("static-cluster-2", "static-test", 2)
+	if err == nil || len(second) != 1 || second[0].Address != "static-test-3" {
+		t.Errorf("expected a single free host, got %v: %v", second, err)
+	}
+
+	leased, err := leasedHosts("static-cluster-1")
+	if err != nil || len(leased) != 2 || leased[0] != "static-test-1" || leased[1] != "static-test-2" {
+		t.Errorf("unexpected leases %v: %v", leased, err)
+	}
+
+	err = releaseHost("static-cluster-2", "static-test-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leased, _ = leasedHosts("static-cluster-1"); len(leased) != 2 {
+		t.Error("expected leases of other clusters to be kept")
+	}
+
+	for _, el := range leased {
+		releaseHost("static-cluster-1", el)
+	}
+	e := StaticEnvironment{ClusterID: "static-cluster-1"}
+	if !e.DestructionConfirmed() {
+		t.Error("expected destruction to be confirmed once all hosts are released")
+	}
+}
+
+func TestStaticDestroyCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allspark-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer daemon.Init("../daemon/allspark_config.json")
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, hostKey := newFakeSSHServer(t, clientPublicKey)
+	defer server.listener.Close()
+	address := server.listener.Addr().String()
+
+	// the second host is the same server under another name, which
+	// rejects its user
+	otherAddress := "localhost:" + strconv.Itoa(server.listener.Addr().(*net.TCPAddr).Port)
+	hosts := []daemon.StaticHost{
+		{Address: address, Group: "static-destroy"},
+		{Address: otherAddress, User: "root", Group: "static-destroy"},
+	}
+	setStaticInventory(t, dir, hosts, map[string]ssh.PublicKey{
+		address:      hostKey,
+		otherAddress: hostKey,
+	}, clientKey)
+
+	leased, err := leaseHosts("static-destroy-cluster", "static-destroy", 2)
+	if err != nil || len(leased) != 2 {
+		t.Fatalf("expected 2 leased hosts, got %v: %v", leased, err)
+	}
+	defer func() {
+		for _, el := range hosts {
+			releaseHost("static-destroy-cluster", el.Address)
+		}
+	}()
+
+	e := StaticEnvironment{ClusterID: "static-destroy-cluster"}
+	err = e.DestroyCluster()
+	if err == nil || !strings.Contains(err.Error(), otherAddress) {
+		t.Errorf("expected the failing host to be reported: %v", err)
+	}
+	if len(server.commands) != 1 || server.commands[0] != dockerRemoveClusterCommand(e.ClusterID) {
+		t.Errorf("expected the reachable host to be cleaned up, got %v", server.commands)
+	}
+
+	remaining, err := leasedHosts(e.ClusterID)
+	if err != nil || len(remaining) != 1 || remaining[0] != otherAddress {
+		t.Errorf("expected only the failing host to stay leased, got %v: %v", remaining, err)
+	}
+	if e.DestructionConfirmed() {
+		t.Error("expected destruction to be unconfirmed while a host is leased")
+	}
+}
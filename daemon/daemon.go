@@ -5,6 +5,68 @@ import (
 	"allspark/util/serializer"
 )
 
+// InstanceShape - compute resources of a cloud instance type
+type InstanceShape struct {
+	VCPU     float64
+	MemoryGB float64
+}
+
+// CapacityLimits - maximum resources of concurrently registered
+// clusters; zero values are unlimited
+type CapacityLimits struct {
+	MaxClusters int
+	MaxNodes    int64
+	MaxVCPU     float64
+	MaxMemoryGB float64
+}
+
+// AdmissionConfig - capacity limits applied when launching clusters;
+// caller limits keyed by "*" apply to callers without an explicit entry
+type AdmissionConfig struct {
+	Environments  map[string]CapacityLimits
+	Callers       map[string]CapacityLimits
+	QueueWhenFull bool
+}
+
+// Identity - an API client authenticated by bearer token; members may
+// manage clusters of their teams, admins may manage all clusters
+type Identity struct {
+	Name  string
+	Teams []string
+	Admin bool
+}
+
+// StaticHost - an on-premises host of the static inventory; Address is
+// host[:port] of its SSH server and User overrides the inventory's user
+type StaticHost struct {
+	Address string
+	User    string `json:",omitempty"`
+	Group   string `json:",omitempty"`
+}
+
+// StaticInventory - hosts leased to clusters of the static environment and
+// the SSH credentials spark containers are started with; host keys are
+// verified against the known hosts file
+type StaticInventory struct {
+	User           string
+	PrivateKeyPath string
+	KnownHostsPath string
+	Hosts          []StaticHost
+}
+
+// PricingCatalog - prices used to estimate the cost of clusters; instance
+// prices are hourly and keyed by AWS InstanceType or Azure VMSize, disk
+// prices are per GB per month and docker nodes, kubernetes pods and static
+// hosts use synthetic hourly rates
+type PricingCatalog struct {
+	Currency            string
+	InstanceHourly      map[string]float64
+	DiskGBMonthly       float64
+	DockerNodeHourly    float64
+	KubernetesPodHourly float64
+	StaticHostHourly    float64
+}
+
 // AllSparkConfig - allspark configuration parameters struct
 type AllSparkConfig struct {
 	RedisHost                    string
@@ -18,7 +80,18 @@ type AllSparkConfig struct {
 	AzureEnabled                 bool
 	AwsEnabled                   bool
 	DockerEnabled                bool
+	KubernetesEnabled            bool
+	StaticEnabled                bool
+	LocalEnabled                 bool
 	CallbackURL                  string
+	AppFailurePolicy             string
+	Admission                    AdmissionConfig
+	InstanceShapes               map[string]InstanceShape
+	Identities                   map[string]Identity
+	Pricing                      PricingCatalog
+	HistoryRetentionDays         int64
+	SparkVersion                 string
+	StaticInventory              StaticInventory
 }
 
 var config AllSparkConfig
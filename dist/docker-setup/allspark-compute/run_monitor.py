@@ -2,11 +2,14 @@
 
 import os
 import requests
+import subprocess
 import time
 import json
-from typing import Dict, Any
+from typing import Dict, Any, List, Optional
 
 APP_EXIT_STATUS_PATH = os.environ.get("APP_EXIT_STATUS_PATH", "/allspark/exit_status")
+INSTANCE_METADATA_URL = "http://169.254.169.254/latest/meta-data"
+INSTANCE_METADATA_TOKEN_URL = "http://169.254.169.254/latest/api/token"
 
 def get_app_exit_status() -> str:
     """
@@ -19,14 +22,82 @@ def get_app_exit_status() -> str:
         status = ""
     return status
 
+def get_app_results(cluster_status: Dict[str, Any]) -> List[Dict[str, Any]]:
+    """
+    Returns the outcome of each completed Spark application as reported by
+    the master; driver exit codes and states are not known to the master
+    :return: List[Dict[str, Any]]
+    """
+    results = []
+    for app in cluster_status.get("completedapps", []):
+        state = app.get("state", "")
+        start_time = app.get("starttime", 0)
+        results.append({
+            "AppID": app.get("id", ""),
+            "Name": app.get("name", ""),
+            "AppState": state,
+            "FailureReason": "" if state == "FINISHED" else f"application ended with state {state}",
+            "StartTime": start_time,
+            "EndTime": start_time + app.get("duration", 0),
+        })
+    return results
+
+def get_metadata_headers() -> Dict[str, str]:
+    """
+    Returns the headers of instance metadata requests, including a session
+    token on instances which require IMDSv2
+    :return: Dict[str, str]
+    """
+    try:
+        r = requests.put(url=INSTANCE_METADATA_TOKEN_URL,
+                         headers={"X-aws-ec2-metadata-token-ttl-seconds": "60"},
+                         timeout=2)
+        if r.status_code == 200:
+            return {"X-aws-ec2-metadata-token": r.text}
+    except:
+        ...
+    return {}
+
+def get_interruption() -> Optional[Dict[str, str]]:
+    """
+    Returns the spot termination notice issued to this instance, or None if
+    no notice was issued or instance metadata is unavailable; only spot
+    instances, launched with ALLSPARK_SPOT, query instance metadata
+    :return: Optional[Dict[str, str]]
+    """
+    if "ALLSPARK_SPOT" not in os.environ:
+        return None
+    try:
+        headers = get_metadata_headers()
+        r = requests.get(url=f"{INSTANCE_METADATA_URL}/spot/instance-action",
+                         headers=headers, timeout=2)
+        if r.status_code != 200:
+            return None
+        notice = r.json()
+        instance_id = requests.get(url=f"{INSTANCE_METADATA_URL}/instance-id",
+                                   headers=headers, timeout=2).text
+        return {
+            "InstanceID": instance_id,
+            "Action": notice.get("action", ""),
+            "Time": notice.get("time", ""),
+        }
+    except:
+        return None
+
 def get_cluster_status() -> Dict[str, Any]:
     """
-    Returns the Spark cluster status
+    Returns the Spark cluster status; SPARK_MASTER_REST_PORT is reported
+    as the port of the REST submission gateway
     :return: Dict[str, Any]
     """
-    spark_status_url = "http://localhost:8080/json/"
+    web_ui_port = os.environ.get("SPARK_MASTER_WEBUI_PORT", "8080")
+    spark_status_url = f"http://localhost:{web_ui_port}/json/"
     r = requests.get(url=spark_status_url)
-    return r.json()
+    status = r.json()
+    rest_port = os.environ.get("SPARK_MASTER_REST_PORT")
+    if rest_port:
+        status["restport"] = int(rest_port)
+    return status
 
 def get_local_status() -> Dict[str, Any]:
     """
@@ -91,6 +162,8 @@ def run_monitor(cluster_id: str, callback_url: str, cluster_mode):
                 "ClusterID": cluster_id,
                 "Status": status,
                 "AppExitStatus": get_app_exit_status(),
+                "AppResults": get_app_results(status),
+                "Interruption": get_interruption(),
             }
 
             requests.post(url=callback_url,
@@ -99,8 +172,66 @@ def run_monitor(cluster_id: str, callback_url: str, cluster_mode):
             ...
         time.sleep(10)
 
+def get_worker_registration(pool: str) -> Dict[str, str]:
+    """
+    Returns the worker pool of this node and the address its spark worker
+    reports to the master; SPARK_LOCAL_IP overrides the host address
+    :return: Dict[str, str]
+    """
+    host = os.environ.get("SPARK_LOCAL_IP")
+    if not host:
+        host = subprocess.check_output(["hostname", "-I"]).decode().split()[0]
+    return {"Pool": pool, "Host": host}
+
+def register_worker(cluster_id: str, callback_url: str, pool: str):
+    """
+    Reports the worker pool of a worker node, retrying until the daemon
+    is reachable
+    """
+    while True:
+        try:
+            requests.post(url=callback_url,
+                          data=json.dumps({
+                              "ClusterID": cluster_id,
+                              "Worker": get_worker_registration(pool),
+                          }))
+            return
+        except:
+            ...
+        time.sleep(5)
+
+def watch_interruptions(cluster_id: str, callback_url: str):
+    """
+    Reports the termination notice of a worker node; workers do not
+    report spark status
+    """
+    while True:
+        interruption = get_interruption()
+        if interruption is not None:
+            try:
+                requests.post(url=callback_url,
+                              data=json.dumps({
+                                  "ClusterID": cluster_id,
+                                  "Interruption": interruption,
+                              }))
+                return
+            except:
+                ...
+        time.sleep(5)
+
 if __name__ == "__main__":
     try:
+        if "MASTER_IP" in os.environ:
+            if "WORKER_POOL" in os.environ:
+                register_worker(os.environ["CLUSTER_ID"],
+                                os.environ["ALLSPARK_CALLBACK"],
+                                os.environ["WORKER_POOL"])
+            # interrupted workers of auto scaling groups are replaced
+            if "ALLSPARK_SPOT" in os.environ and \
+                    "ALLSPARK_REPLACES_WORKERS" not in os.environ:
+                watch_interruptions(os.environ["CLUSTER_ID"],
+                                    os.environ["ALLSPARK_CALLBACK"])
+            exit(0)
         cluster_mode = True if int(os.environ["EXPECTED_WORKERS"]) > 0 else False
         run_monitor(os.environ["CLUSTER_ID"],
                     os.environ["ALLSPARK_CALLBACK"],
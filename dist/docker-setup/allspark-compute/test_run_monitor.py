@@ -1,6 +1,8 @@
 import unittest
 import os
-from run_monitor import get_app_exit_status, get_cluster_status, APP_EXIT_STATUS_PATH
+from unittest import mock
+from run_monitor import get_app_exit_status, get_app_results, get_cluster_status, \
+    get_interruption, get_worker_registration, APP_EXIT_STATUS_PATH
 
 class TestRunMonitor(unittest.TestCase):
 
@@ -9,6 +11,16 @@ class TestRunMonitor(unittest.TestCase):
         assert "ALIVE" == cluster_status["status"]
         assert [] == cluster_status["completedapps"]
 
+    def test_get_cluster_status_rest_port(self):
+        response = mock.Mock()
+        response.json.return_value = {"status": "ALIVE"}
+        with mock.patch("run_monitor.requests.get", return_value=response) as get, \
+                mock.patch.dict(os.environ, {"SPARK_MASTER_WEBUI_PORT": "41001",
+                                             "SPARK_MASTER_REST_PORT": "41002"}):
+            cluster_status = get_cluster_status()
+        get.assert_called_with(url="http://localhost:41001/json/")
+        assert 41002 == cluster_status["restport"]
+
     def test_get_app_exit_status(self):
         def set_exit_failure():
             with open(APP_EXIT_STATUS_PATH, "w") as fh:
@@ -25,6 +37,66 @@ class TestRunMonitor(unittest.TestCase):
         exit_status = get_app_exit_status()
         assert "ERROR" == exit_status
 
+    def test_get_app_results(self):
+        status = {
+            "completedapps": [
+                {"id": "app-1", "name": "a", "state": "FINISHED", "starttime": 10, "duration": 5},
+                {"id": "app-2", "name": "b", "state": "FAILED", "starttime": 20, "duration": 5},
+            ]
+        }
+        results = get_app_results(status)
+        assert 2 == len(results)
+        assert "FINISHED" == results[0]["AppState"]
+        assert "" == results[0]["FailureReason"]
+        assert 15 == results[0]["EndTime"]
+        assert "FAILED" == results[1]["AppState"]
+        assert "ExitCode" not in results[1]
+        assert "DriverState" not in results[1]
+        assert [] == get_app_results({})
+
+    def test_get_interruption(self):
+        def metadata(url, headers, timeout):
+            assert "token" == headers["X-aws-ec2-metadata-token"]
+            response = mock.Mock()
+            if url.endswith("/spot/instance-action"):
+                response.status_code = 200
+                response.json.return_value = {"action": "terminate", "time": "2021-03-01T08:22:00Z"}
+            else:
+                response.status_code = 200
+                response.text = "i-0123456789abcdef0"
+            return response
+
+        token = mock.Mock(status_code=200, text="token")
+        with mock.patch("run_monitor.requests.get", side_effect=metadata), \
+                mock.patch("run_monitor.requests.put", return_value=token), \
+                mock.patch.dict(os.environ, {"ALLSPARK_SPOT": "true"}):
+            interruption = get_interruption()
+        assert "i-0123456789abcdef0" == interruption["InstanceID"]
+        assert "terminate" == interruption["Action"]
+
+        not_found = mock.Mock(status_code=404)
+        with mock.patch("run_monitor.requests.get", return_value=not_found), \
+                mock.patch("run_monitor.requests.put", return_value=not_found), \
+                mock.patch.dict(os.environ, {"ALLSPARK_SPOT": "true"}):
+            assert get_interruption() is None
+
+        # instances not launched on spot never query instance metadata
+        with mock.patch("run_monitor.requests.get") as get, \
+                mock.patch("run_monitor.requests.put") as put:
+            assert get_interruption() is None
+        get.assert_not_called()
+        put.assert_not_called()
+
+    def test_get_worker_registration(self):
+        with mock.patch("run_monitor.subprocess.check_output",
+                        return_value=b"172.18.0.3 10.0.0.7 \n"):
+            registration = get_worker_registration("highmem")
+        assert "highmem" == registration["Pool"]
+        assert "172.18.0.3" == registration["Host"]
+
+        with mock.patch.dict(os.environ, {"SPARK_LOCAL_IP": "127.0.0.1"}):
+            registration = get_worker_registration("default")
+        assert "127.0.0.1" == registration["Host"]
+
 if __name__ == '__main__':
     unittest.main()
-
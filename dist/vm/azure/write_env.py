@@ -6,7 +6,8 @@ def write_env(vm_tags: str):
     with open("/allspark/env.sh", "w") as fh:
         for variable in env_variables:
             k, v = variable.split(':', 1)
-            fh.write(f'{k}="{v}"\n')
+            v = v.replace("'", "'\"'\"'")
+            fh.write(f"{k}='{v}'\n")
 
 if __name__ == "__main__":
     write_env(sys.stdin.read().strip())
\ No newline at end of file
@@ -0,0 +1,398 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/daemon"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	admissionLock         = "ADMISSION_LOCK"
+	defaultCallerLimitKey = "*"
+)
+
+// ErrCapacityExceeded - returned when a cluster is rejected by admission control
+var ErrCapacityExceeded = errors.New("capacity exceeded")
+
+// capacityUsage describes the resources of registered clusters
+type capacityUsage struct {
+	Clusters int
+	Nodes    int64
+	VCPU     float64
+	MemoryGB float64
+}
+
+func (u *capacityUsage) add(resources cloud.ClusterResources) {
+	u.Clusters++
+	u.Nodes += resources.Nodes
+	u.VCPU += resources.VCPU
+	u.MemoryGB += resources.MemoryGB
+}
+
+func getCallerLimits(config daemon.AdmissionConfig, caller string) (daemon.CapacityLimits, bool) {
+	if limits, ok := config.Callers[caller]; ok {
+		return limits, true
+	}
+	limits, ok := config.Callers[defaultCallerLimitKey]
+	return limits, ok
+}
+
+// checkLimits - returns a description of the first limit that would be
+// exceeded by adding the requested resources to the current usage
+func checkLimits(scope string, limits daemon.CapacityLimits,
+	usage capacityUsage, requested cloud.ClusterResources) error {
+
+	if (limits.MaxVCPU > 0 || limits.MaxMemoryGB > 0) && !requested.ShapeKnown {
+		return fmt.Errorf("%w: instance type not found in InstanceShapes; "+
+			"unable to evaluate %s vCPU and memory limits", ErrCapacityExceeded, scope)
+	}
+
+	switch {
+	case limits.MaxClusters > 0 && usage.Clusters+1 > limits.MaxClusters:
+		return fmt.Errorf("%w: %s limit of %v concurrent clusters reached",
+			ErrCapacityExceeded, scope, limits.MaxClusters)
+	case limits.MaxNodes > 0 && usage.Nodes+requested.Nodes > limits.MaxNodes:
+		return fmt.Errorf("%w: %s limit of %v nodes; %v in use, %v requested",
+			ErrCapacityExceeded, scope, limits.MaxNodes, usage.Nodes, requested.Nodes)
+	case limits.MaxVCPU > 0 && usage.VCPU+requested.VCPU > limits.MaxVCPU:
+		return fmt.Errorf("%w: %s limit of %v vCPU; %v in use, %v requested",
+			ErrCapacityExceeded, scope, limits.MaxVCPU, usage.VCPU, requested.VCPU)
+	case limits.MaxMemoryGB > 0 && usage.MemoryGB+requested.MemoryGB > limits.MaxMemoryGB:
+		return fmt.Errorf("%w: %s limit of %vGB memory; %vGB in use, %vGB requested",
+			ErrCapacityExceeded, scope, limits.MaxMemoryGB, usage.MemoryGB, requested.MemoryGB)
+	}
+
+	return nil
+}
+
+// getCapacityUsage - returns the resources of all admitted clusters in
+// the environment, of the caller and of the team
+func getCapacityUsage(request ClusterRequest) (capacityUsage, capacityUsage, capacityUsage) {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	var environmentUsage, callerUsage, teamUsage capacityUsage
+	for _, buffer := range redisClient.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) != nil ||
+			status.Status == StatusQueued {
+			continue
+		}
+
+		client, err := cloud.Create(status.CloudEnvironment, status.Client)
+		if err != nil {
+			continue
+		}
+
+		resources := client.GetResources()
+		if status.CloudEnvironment == request.CloudEnvironment {
+			environmentUsage.add(resources)
+		}
+		if len(request.Caller) > 0 && status.Caller == request.Caller {
+			callerUsage.add(resources)
+		}
+		if len(request.Team) > 0 && status.Team == request.Team {
+			teamUsage.add(resources)
+		}
+	}
+
+	return environmentUsage, callerUsage, teamUsage
+}
+
+// checkAdmission - verifies the cluster fits within the configured
+// environment and caller limits and the concurrent cluster limit of
+// its team
+func checkAdmission(request ClusterRequest, client cloud.CloudEnvironment) error {
+	config := daemon.GetAllSparkConfig().Admission
+	environmentLimits, hasEnvironmentLimits := config.Environments[request.CloudEnvironment]
+	callerLimits, hasCallerLimits := getCallerLimits(config, request.Caller)
+
+	var teamQuota *TeamQuota
+	if len(request.Team) > 0 {
+		teamQuota = GetTeamQuota(request.Team)
+	}
+	hasTeamLimits := teamQuota != nil && teamQuota.MaxClusters > 0
+
+	if !hasEnvironmentLimits && !hasCallerLimits && !hasTeamLimits {
+		return nil
+	}
+
+	requested := client.GetResources()
+	environmentUsage, callerUsage, teamUsage := getCapacityUsage(request)
+
+	if hasEnvironmentLimits {
+		err := checkLimits(request.CloudEnvironment+" environment",
+			environmentLimits, environmentUsage, requested)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasCallerLimits {
+		err := checkLimits("caller "+request.Caller, callerLimits, callerUsage, requested)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasTeamLimits {
+		return checkLimits("team "+request.Team,
+			daemon.CapacityLimits{MaxClusters: teamQuota.MaxClusters}, teamUsage, requested)
+	}
+
+	return nil
+}
+
+// checkGrowth - verifies the resources added to a running cluster fit
+// within the budgets, team quota and environment and caller limits
+// applying to it; the cluster itself is already counted in the usage
+func checkGrowth(request ClusterRequest, added cloud.ClusterResources) error {
+	err := acquireAdmissionLock()
+	if err != nil {
+		return err
+	}
+	defer releaseAdmissionLock()
+
+	err = checkBudgets(request)
+	if err != nil {
+		return err
+	}
+
+	if len(request.Team) > 0 {
+		err = checkTeamQuota(request, GetTeamQuota(request.Team), added)
+		if err != nil {
+			return err
+		}
+	}
+
+	config := daemon.GetAllSparkConfig().Admission
+	environmentLimits, hasEnvironmentLimits := config.Environments[request.CloudEnvironment]
+	callerLimits, hasCallerLimits := getCallerLimits(config, request.Caller)
+	if !hasEnvironmentLimits && !hasCallerLimits {
+		return nil
+	}
+
+	environmentUsage, callerUsage, _ := getCapacityUsage(request)
+	if hasEnvironmentLimits {
+		environmentLimits.MaxClusters = 0
+		err = checkLimits(request.CloudEnvironment+" environment",
+			environmentLimits, environmentUsage, added)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasCallerLimits {
+		callerLimits.MaxClusters = 0
+		return checkLimits("caller "+request.Caller, callerLimits, callerUsage, added)
+	}
+
+	return nil
+}
+
+// canEverAdmit - returns false if the cluster exceeds the limits on its
+// own, in which case queueing it would never succeed
+func canEverAdmit(request ClusterRequest, client cloud.CloudEnvironment) error {
+	config := daemon.GetAllSparkConfig().Admission
+	requested := client.GetResources()
+
+	if limits, ok := config.Environments[request.CloudEnvironment]; ok {
+		err := checkLimits(request.CloudEnvironment+" environment",
+			limits, capacityUsage{}, requested)
+		if err != nil {
+			return err
+		}
+	}
+
+	if limits, ok := getCallerLimits(config, request.Caller); ok {
+		return checkLimits("caller "+request.Caller, limits, capacityUsage{}, requested)
+	}
+
+	return nil
+}
+
+func acquireAdmissionLock() error {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	id, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	value := id + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	maxAttempts := 10
+	for i := 0; i < maxAttempts; i++ {
+		if redisClient.SetNX(admissionLock, value, 30*time.Second).Val() {
+			return nil
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	return errors.New("failed to acquire admission lock")
+}
+
+func releaseAdmissionLock() {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	redisClient.Del(admissionLock)
+}
+
+// admitCluster - registers the cluster as pending if it fits within the
+// configured limits; otherwise the cluster is either rejected or
+// registered as queued. Clusters exceeding a budget or team quota are
+// always rejected.
+func admitCluster(request ClusterRequest, client cloud.CloudEnvironment) (string, error) {
+	err := acquireAdmissionLock()
+	if err != nil {
+		return StatusNotRegistered, err
+	}
+	defer releaseAdmissionLock()
+
+	err = checkBudgets(request)
+	if err != nil {
+		return StatusNotRegistered, err
+	}
+
+	if len(request.Team) > 0 {
+		err = checkTeamQuota(request, GetTeamQuota(request.Team), client.GetResources())
+		if err != nil {
+			return StatusNotRegistered, err
+		}
+	}
+
+	err = checkAdmission(request, client)
+	if err == nil {
+		return StatusPending, registerCluster(request, StatusPending)
+	}
+
+	if !daemon.GetAllSparkConfig().Admission.QueueWhenFull {
+		return StatusNotRegistered, err
+	}
+
+	if limitErr := canEverAdmit(request, client); limitErr != nil {
+		return StatusNotRegistered, limitErr
+	}
+
+	logger.GetInfo().Printf("queueing cluster %v: %v", request.ClusterID, err)
+	return StatusQueued, registerCluster(request, StatusQueued)
+}
+
+type queuedCluster struct {
+	ClusterID string
+	Status    SparkClusterStatusAtEpoch
+}
+
+func getQueuedClusters() []queuedCluster {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	queued := make([]queuedCluster, 0)
+	for clusterID, buffer := range redisClient.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) == nil &&
+			status.Status == StatusQueued {
+			queued = append(queued, queuedCluster{clusterID, status})
+		}
+	}
+
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].Status.Timestamp < queued[j].Status.Timestamp
+	})
+
+	return queued
+}
+
+// promoteQueuedCluster - moves a queued cluster to pending and creates it
+// if capacity is available, or cancels it if a budget applying to it has
+// been exceeded; returns true if the cluster was promoted
+func promoteQueuedCluster(clusterID string) bool {
+	err := acquireClusterLock(clusterID, "admission", 5)
+	if err != nil {
+		logger.GetError().Println(err)
+		return false
+	}
+	defer releaseClusterLock(clusterID)
+
+	status, err := getLastEpoch(clusterID)
+	if err != nil || status.Status != StatusQueued {
+		return false
+	}
+
+	client, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err != nil {
+		logger.GetError().Println(err)
+		return false
+	}
+
+	request := ClusterRequest{
+		ClusterID:        clusterID,
+		CloudEnvironment: status.CloudEnvironment,
+		Caller:           status.Caller,
+		Team:             status.Team,
+	}
+
+	// budgets may have been exceeded while the cluster was queued
+	err = checkBudgets(request)
+	if err != nil {
+		logger.GetError().Printf("canceling queued cluster %v: %v", clusterID, err)
+		status.Status = StatusCanceled
+		status.FailureReason = FailureBudgetExceeded
+		status.Timestamp = getTimestamp()
+		setStatus(clusterID, status, true)
+		return false
+	}
+
+	err = checkAdmission(request, client)
+	if err != nil {
+		logger.GetDebug().Printf("cluster %v remains queued: %v", clusterID, err)
+		return false
+	}
+
+	logger.GetInfo().Printf("admitting queued cluster %v", clusterID)
+	status.Status = StatusPending
+	status.Timestamp = getTimestamp()
+	status.LastCheckIn = getTimestamp()
+	status.RegisteredAt = getTimestamp()
+	setStatus(clusterID, status, true)
+
+	go func() {
+		_, err := client.CreateCluster()
+		recordClient(clusterID, client)
+		if err != nil {
+			logger.GetError().Println(err)
+			setCanceled(clusterID, FailureLaunch)
+		}
+	}()
+
+	return true
+}
+
+// processAdmissionQueue - promotes queued clusters, oldest first, as
+// capacity becomes available
+func processAdmissionQueue() {
+	queued := getQueuedClusters()
+	if len(queued) == 0 {
+		return
+	}
+
+	err := acquireAdmissionLock()
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+	defer releaseAdmissionLock()
+
+	for _, el := range queued {
+		promoteQueuedCluster(el.ClusterID)
+	}
+}
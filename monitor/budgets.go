@@ -0,0 +1,341 @@
+package monitor
+
+import (
+	"allspark/daemon"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget scopes
+const (
+	BudgetScopeTeam        = "team"
+	BudgetScopeEnvironment = "environment"
+)
+
+// Budget periods
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// Budget states
+const (
+	BudgetStateOK       = "OK"
+	BudgetStateWarning  = "WARNING"
+	BudgetStateExceeded = "EXCEEDED"
+)
+
+const (
+	budgetMap            = "BUDGET_MAP"
+	budgetNotifiedPrefix = "budget.notified."
+	budgetNotifiedExpiry = 32 * 24 * time.Hour
+)
+
+// ErrBudgetExceeded - returned when a cluster is rejected by a budget
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+var budgetWebhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// Budget - spend limits of a team or cloud environment per period;
+// crossing the soft limit sends a notification, crossing the hard limit
+// blocks new clusters and optionally cancels running clusters.
+// Zero limits are unlimited.
+type Budget struct {
+	Scope          string
+	Target         string
+	Period         string
+	SoftLimit      float64
+	HardLimit      float64
+	CancelClusters bool
+	WebhookURL     string `json:",omitempty"`
+}
+
+// BudgetStatus - spend of a budget in the current period
+type BudgetStatus struct {
+	Budget
+	PeriodStart string
+	Spend       float64
+	Currency    string
+	State       string
+}
+
+// BudgetID - returns the identifier of the budget of the scope and target
+func BudgetID(scope string, target string) string {
+	return scope + "/" + target
+}
+
+// ValidateBudget - verifies the budget parameters
+func ValidateBudget(budget Budget) error {
+	if budget.Scope != BudgetScopeTeam && budget.Scope != BudgetScopeEnvironment {
+		return errors.New("budget scope must be " + BudgetScopeTeam +
+			" or " + BudgetScopeEnvironment)
+	}
+
+	if len(budget.Target) == 0 {
+		return errors.New("budget target not specified")
+	}
+
+	if budget.Period != BudgetPeriodDaily && budget.Period != BudgetPeriodMonthly {
+		return errors.New("budget period must be " + BudgetPeriodDaily +
+			" or " + BudgetPeriodMonthly)
+	}
+
+	if budget.SoftLimit < 0 || budget.HardLimit < 0 {
+		return errors.New("budget limits must not be negative")
+	}
+
+	if budget.SoftLimit == 0 && budget.HardLimit == 0 {
+		return errors.New("budget requires a soft or hard limit")
+	}
+
+	if budget.HardLimit > 0 && budget.SoftLimit > budget.HardLimit {
+		return errors.New("budget soft limit exceeds hard limit")
+	}
+
+	return nil
+}
+
+// SetBudget - creates or replaces a budget
+func SetBudget(budget Budget) error {
+	err := ValidateBudget(budget)
+	if err != nil {
+		return err
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(budget)
+	if err != nil {
+		return err
+	}
+
+	logger.GetInfo().Printf("setting budget %v: %+v",
+		BudgetID(budget.Scope, budget.Target), budget)
+	return client.HSet(budgetMap, BudgetID(budget.Scope, budget.Target), string(buffer)).Err()
+}
+
+// GetBudget - returns the budget of the scope and target
+func GetBudget(scope string, target string) (Budget, error) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	var budget Budget
+	buffer, err := client.HGet(budgetMap, BudgetID(scope, target)).Result()
+	if err != nil {
+		return budget, errors.New("budget " + BudgetID(scope, target) + " not found")
+	}
+
+	err = serializer.Deserialize([]byte(buffer), &budget)
+	return budget, err
+}
+
+// GetBudgets - returns all budgets ordered by identifier
+func GetBudgets() []Budget {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	budgets := make([]Budget, 0)
+	for _, buffer := range client.HGetAll(budgetMap).Val() {
+		var budget Budget
+		if serializer.Deserialize([]byte(buffer), &budget) == nil {
+			budgets = append(budgets, budget)
+		}
+	}
+
+	sort.Slice(budgets, func(i, j int) bool {
+		return BudgetID(budgets[i].Scope, budgets[i].Target) <
+			BudgetID(budgets[j].Scope, budgets[j].Target)
+	})
+
+	return budgets
+}
+
+// DeleteBudget - removes the budget of the scope and target
+func DeleteBudget(scope string, target string) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	if client.HDel(budgetMap, BudgetID(scope, target)).Val() == 0 {
+		return errors.New("budget " + BudgetID(scope, target) + " not found")
+	}
+	return nil
+}
+
+// getPeriodStart - returns the first day (UTC) of the budget period
+// containing the specified time
+func getPeriodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == BudgetPeriodDaily {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// getBudgetSpend - returns the estimated cost accrued in the budget scope
+// from the start of the period until the specified time
+func getBudgetSpend(budget Budget, now time.Time) float64 {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	var spend float64
+	for day := getPeriodStart(budget.Period, now); !day.After(now); day = day.AddDate(0, 0, 1) {
+		dayName := day.Format(costDayFormat)
+		if budget.Scope == BudgetScopeEnvironment {
+			cost, _ := client.HGet(costEnvLedgerPrefix+dayName, budget.Target).Float64()
+			spend += cost
+			continue
+		}
+
+		prefix := budget.Target + costFieldSeparator
+		for field, value := range client.HGetAll(costLedgerPrefix + dayName).Val() {
+			if strings.HasPrefix(field, prefix) {
+				cost, _ := strconv.ParseFloat(value, 64)
+				spend += cost
+			}
+		}
+	}
+
+	return spend
+}
+
+// getBudgetState - returns the state of a budget with the specified spend
+func getBudgetState(budget Budget, spend float64) string {
+	switch {
+	case budget.HardLimit > 0 && spend >= budget.HardLimit:
+		return BudgetStateExceeded
+	case budget.SoftLimit > 0 && spend >= budget.SoftLimit:
+		return BudgetStateWarning
+	}
+	return BudgetStateOK
+}
+
+// GetBudgetStatus - returns the spend of the budget in the current period
+func GetBudgetStatus(budget Budget) BudgetStatus {
+	now := time.Now().UTC()
+	spend := getBudgetSpend(budget, now)
+
+	return BudgetStatus{
+		Budget:      budget,
+		PeriodStart: getPeriodStart(budget.Period, now).Format(costDayFormat),
+		Spend:       spend,
+		Currency:    daemon.GetAllSparkConfig().Pricing.Currency,
+		State:       getBudgetState(budget, spend),
+	}
+}
+
+// inBudgetScope - returns true if the budget applies to the cluster
+func inBudgetScope(budget Budget, team string, cloudEnvironment string) bool {
+	switch budget.Scope {
+	case BudgetScopeTeam:
+		return len(team) > 0 && budget.Target == team
+	case BudgetScopeEnvironment:
+		return budget.Target == cloudEnvironment
+	}
+	return false
+}
+
+// checkBudgets - verifies no hard limit of a budget applying to the
+// cluster has been reached
+func checkBudgets(request ClusterRequest) error {
+	for _, budget := range GetBudgets() {
+		if budget.HardLimit == 0 ||
+			!inBudgetScope(budget, request.Team, request.CloudEnvironment) {
+			continue
+		}
+
+		status := GetBudgetStatus(budget)
+		if status.State == BudgetStateExceeded {
+			return fmt.Errorf("%w: %v %v has spent %.2f of its %v %s budget of %v",
+				ErrBudgetExceeded, budget.Scope, budget.Target, status.Spend,
+				budget.Period, status.Currency, budget.HardLimit)
+		}
+	}
+
+	return nil
+}
+
+// notifyBudget - reports a budget once each time its state changes
+// within a period, via the log and the budget webhook
+func notifyBudget(status BudgetStatus) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	key := budgetNotifiedPrefix + BudgetID(status.Scope, status.Target) + "." + status.PeriodStart
+	previous := client.Get(key).Val()
+	if previous == status.State || (len(previous) == 0 && status.State == BudgetStateOK) {
+		return
+	}
+	client.Set(key, status.State, budgetNotifiedExpiry)
+
+	message := fmt.Sprintf("budget %v is %v: %.2f %s spent since %v (soft limit %v, hard limit %v)",
+		BudgetID(status.Scope, status.Target), status.State, status.Spend,
+		status.Currency, status.PeriodStart, status.SoftLimit, status.HardLimit)
+	if status.State == BudgetStateExceeded {
+		logger.GetError().Println(message)
+	} else {
+		logger.GetInfo().Println(message)
+	}
+
+	if len(status.WebhookURL) == 0 {
+		return
+	}
+
+	buffer, err := serializer.Serialize(status)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	resp, err := budgetWebhookClient.Post(status.WebhookURL, "application/json",
+		bytes.NewReader(buffer))
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// cancelBudgetClusters - cancels the active clusters within the scope
+// of an exceeded budget
+func cancelBudgetClusters(budget Budget) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	for clusterID, buffer := range client.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) != nil ||
+			!inBudgetScope(budget, status.Team, status.CloudEnvironment) {
+			continue
+		}
+
+		switch status.Status {
+		case StatusQueued, StatusPending, StatusIdle, StatusRunning:
+			logger.GetError().Printf("budget %v exceeded; canceling cluster %v",
+				BudgetID(budget.Scope, budget.Target), clusterID)
+			setCanceled(clusterID, FailureBudgetExceeded)
+		}
+	}
+}
+
+// processBudgets - notifies budget state changes and cancels clusters
+// of exceeded budgets configured to do so
+func processBudgets() {
+	for _, budget := range GetBudgets() {
+		status := GetBudgetStatus(budget)
+		notifyBudget(status)
+
+		if status.State == BudgetStateExceeded && budget.CancelClusters {
+			cancelBudgetClusters(budget)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/daemon"
+	"allspark/datastore"
+	"allspark/logger"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	clusterCostMap      = "CLUSTER_COST"
+	costLedgerPrefix    = "cost.ledger."
+	costEnvLedgerPrefix = "cost.environment."
+	costDayFormat       = "2006-01-02"
+	costLedgerRetention = 400 * 24 * time.Hour
+	costFieldSeparator  = "|"
+)
+
+// CostReportRow - estimated cost accrued by the clusters of a team
+// launched from a template on a day (UTC)
+type CostReportRow struct {
+	Day      string
+	Team     string
+	Template string
+	Cost     float64
+}
+
+// CostReport - estimated cost accrued between two days (inclusive)
+type CostReport struct {
+	Currency string
+	From     string
+	To       string
+	Total    float64
+	Rows     []CostReportRow
+}
+
+// getTemplateName - returns the template the cluster was launched from;
+// clusters launched without a template name are grouped by environment
+func getTemplateName(status SparkClusterStatusAtEpoch) string {
+	if len(status.Template) > 0 {
+		return status.Template
+	}
+	return status.CloudEnvironment
+}
+
+// getHourlyCost - returns the estimated hourly cost of the cluster and
+// whether all of its instance types are priced
+func getHourlyCost(status SparkClusterStatusAtEpoch) (float64, bool) {
+	client, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err != nil {
+		return 0, false
+	}
+	return cloud.HourlyCost(client.GetResources())
+}
+
+// accrueCost - adds the estimated cost of the cluster between the two
+// timestamps to the cluster and to the daily ledgers of its team and
+// template and of its environment, splitting the interval at day boundaries
+func accrueCost(redisClient *redis.Client, clusterID string,
+	status SparkClusterStatusAtEpoch, resources cloud.ClusterResources,
+	from int64, to int64) {
+
+	hourlyCost, priced := cloud.HourlyCost(resources)
+	if !priced {
+		logger.GetDebug().Printf("cluster %v has instance types missing from the pricing catalog",
+			clusterID)
+	}
+
+	if hourlyCost <= 0 {
+		return
+	}
+
+	field := status.Team + costFieldSeparator + getTemplateName(status)
+	for start := from; start < to; {
+		day := time.Unix(start, 0).UTC()
+		end := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, time.UTC).Unix()
+		if end > to {
+			end = to
+		}
+
+		cost := hourlyCost * float64(end-start) / 3600
+		key := costLedgerPrefix + day.Format(costDayFormat)
+		redisClient.HIncrByFloat(key, field, cost)
+		redisClient.Expire(key, costLedgerRetention)
+
+		envKey := costEnvLedgerPrefix + day.Format(costDayFormat)
+		redisClient.HIncrByFloat(envKey, status.CloudEnvironment, cost)
+		redisClient.Expire(envKey, costLedgerRetention)
+
+		redisClient.HIncrByFloat(clusterCostMap, clusterID, cost)
+
+		start = end
+	}
+}
+
+// getAccruedCost - returns the estimated cost accrued by the cluster
+func getAccruedCost(clusterID string) float64 {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	cost, _ := redisClient.HGet(clusterCostMap, clusterID).Float64()
+	return cost
+}
+
+func deleteClusterCost(clusterID string) {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	redisClient.HDel(clusterCostMap, clusterID)
+}
+
+// GetCostReport - returns the estimated cost accrued on each day between
+// the two days (inclusive) grouped by team and template; rows of teams
+// rejected by the filter are omitted
+func GetCostReport(from time.Time, to time.Time, filter func(team string) bool) (CostReport, error) {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	if to.Before(from) {
+		return CostReport{}, errors.New("report end precedes report start")
+	}
+
+	if to.Sub(from) > costLedgerRetention {
+		return CostReport{}, errors.New("report period exceeds the cost retention period")
+	}
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	report := CostReport{
+		Currency: daemon.GetAllSparkConfig().Pricing.Currency,
+		From:     from.Format(costDayFormat),
+		To:       to.Format(costDayFormat),
+		Rows:     make([]CostReportRow, 0),
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		dayName := day.Format(costDayFormat)
+		for field, value := range redisClient.HGetAll(costLedgerPrefix + dayName).Val() {
+			segments := strings.SplitN(field, costFieldSeparator, 2)
+			if len(segments) != 2 || (filter != nil && !filter(segments[0])) {
+				continue
+			}
+
+			cost, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			report.Rows = append(report.Rows, CostReportRow{
+				Day:      dayName,
+				Team:     segments[0],
+				Template: segments[1],
+				Cost:     cost,
+			})
+			report.Total += cost
+		}
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		return a.Template < b.Template
+	})
+
+	return report, nil
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"context"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	clusterEventPrefix = "cluster.events."
+)
+
+// ClusterEvent describes a cluster status transition or
+// spark snapshot update
+type ClusterEvent struct {
+	ClusterID   string
+	Status      string
+	Timestamp   int64
+	LastCheckIn int64
+	Team        string                    `json:",omitempty"`
+	SparkStatus *cloud.SparkClusterStatus `json:",omitempty"`
+}
+
+func newClusterEvent(clusterID string, status SparkClusterStatusAtEpoch) ClusterEvent {
+	return ClusterEvent{
+		ClusterID:   clusterID,
+		Status:      status.Status,
+		Timestamp:   status.Timestamp,
+		LastCheckIn: status.LastCheckIn,
+		Team:        status.Team,
+		SparkStatus: status.SparkStatus,
+	}
+}
+
+func publishEvent(client *redis.Client, clusterID string, status SparkClusterStatusAtEpoch) {
+	buffer, err := serializer.Serialize(newClusterEvent(clusterID, status))
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	err = client.Publish(clusterEventPrefix+clusterID, string(buffer)).Err()
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+}
+
+// GetClusterEvents - returns the current state of the specified cluster
+// as an event, or the state of all registered clusters if clusterID is empty
+func GetClusterEvents(clusterID string) []ClusterEvent {
+	events := make([]ClusterEvent, 0)
+	if len(clusterID) > 0 {
+		status, err := getLastEpoch(clusterID)
+		if err == nil {
+			events = append(events, newClusterEvent(clusterID, status))
+		}
+		return events
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	for id, buffer := range client.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) == nil {
+			events = append(events, newClusterEvent(id, status))
+		}
+	}
+
+	return events
+}
+
+// WatchClusters - streams events for the specified cluster, or for all
+// clusters if clusterID is empty, until the context is done
+func WatchClusters(ctx context.Context, clusterID string) (<-chan ClusterEvent, error) {
+	client := datastore.GetRedisClient()
+
+	var pubsub *redis.PubSub
+	if len(clusterID) > 0 {
+		pubsub = client.Subscribe(clusterEventPrefix + clusterID)
+	} else {
+		pubsub = client.PSubscribe(clusterEventPrefix + "*")
+	}
+
+	_, err := pubsub.Receive()
+	if err != nil {
+		pubsub.Close()
+		client.Close()
+		return nil, err
+	}
+
+	events := make(chan ClusterEvent)
+	go func() {
+		defer close(events)
+		defer client.Close()
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var event ClusterEvent
+				err := serializer.Deserialize([]byte(message.Payload), &event)
+				if err != nil {
+					logger.GetError().Println(err)
+					continue
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,218 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/daemon"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	clusterHistory = "CLUSTER_HISTORY"
+)
+
+// HistoryPhases - cluster statuses whose first occurrence is recorded
+// in the cluster history, in lifecycle order
+var HistoryPhases = []string{
+	StatusQueued,
+	StatusPending,
+	StatusIdle,
+	StatusRunning,
+	StatusDone,
+	StatusError,
+	StatusCanceled,
+	StatusTerminating,
+}
+
+// ClusterSummary - archived record of a deregistered cluster
+type ClusterSummary struct {
+	ClusterID        string
+	CloudEnvironment string
+	Team             string `json:",omitempty"`
+	Owner            string `json:",omitempty"`
+	Caller           string `json:",omitempty"`
+	Template         string
+	TemplateHash     string
+	Nodes            int64
+	InstanceTypes    []string
+	RunID            string `json:",omitempty"`
+	Attempt          int    `json:",omitempty"`
+	RootClusterID    string `json:",omitempty"`
+	RegisteredAt     int64
+	DeregisteredAt   int64
+	Phases           map[string]int64
+	Outcome          string
+	FailureReason    string `json:",omitempty"`
+	EstimatedCost    float64
+	Currency         string `json:",omitempty"`
+}
+
+// recordPhase - records when the cluster first entered its status
+func recordPhase(status *SparkClusterStatusAtEpoch) {
+	if len(status.Status) == 0 || status.Status == StatusNotRegistered {
+		return
+	}
+
+	if status.Phases == nil {
+		status.Phases = make(map[string]int64)
+	}
+
+	if _, ok := status.Phases[status.Status]; !ok {
+		status.Phases[status.Status] = status.Timestamp
+	}
+}
+
+// getOutcome - returns the last completed, failed or canceled status
+// the cluster reached before termination
+func getOutcome(status SparkClusterStatusAtEpoch) string {
+	if status.Status != StatusTerminating {
+		return status.Status
+	}
+
+	outcome, latest := StatusTerminating, int64(-1)
+	for _, el := range []string{StatusDone, StatusError, StatusCanceled} {
+		if timestamp, ok := status.Phases[el]; ok && timestamp > latest {
+			outcome, latest = el, timestamp
+		}
+	}
+	return outcome
+}
+
+func newClusterSummary(clusterID string, status SparkClusterStatusAtEpoch) ClusterSummary {
+	summary := ClusterSummary{
+		ClusterID:        clusterID,
+		CloudEnvironment: status.CloudEnvironment,
+		Team:             status.Team,
+		Owner:            status.Owner,
+		Caller:           status.Caller,
+		Template:         getTemplateName(status),
+		RunID:            status.RunID,
+		Attempt:          status.Attempt,
+		RootClusterID:    status.RootClusterID,
+		RegisteredAt:     status.RegisteredAt,
+		DeregisteredAt:   getTimestamp(),
+		Phases:           status.Phases,
+		Outcome:          getOutcome(status),
+		FailureReason:    status.FailureReason,
+		InstanceTypes:    make([]string, 0),
+		Currency:         daemon.GetAllSparkConfig().Pricing.Currency,
+	}
+
+	summary.TemplateHash, _ = cloud.TemplateHash(status.Client)
+
+	client, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err == nil {
+		resources := client.GetResources()
+		summary.Nodes = resources.Nodes
+		for _, el := range resources.Groups {
+			summary.InstanceTypes = append(summary.InstanceTypes, el.InstanceType)
+		}
+	}
+
+	return summary
+}
+
+// archiveCluster - adds a summary of a cluster which is about to be
+// deregistered to the cluster history, and removes summaries older
+// than the configured retention
+func archiveCluster(clusterID string) {
+	status, err := getLastEpoch(clusterID)
+	if err != nil {
+		return
+	}
+
+	summary := newClusterSummary(clusterID, status)
+	summary.EstimatedCost = getAccruedCost(clusterID)
+
+	buffer, err := serializer.Serialize(summary)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	err = client.ZAdd(clusterHistory, redis.Z{
+		Score:  float64(summary.DeregisteredAt),
+		Member: string(buffer),
+	}).Err()
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	retentionDays := daemon.GetAllSparkConfig().HistoryRetentionDays
+	if retentionDays > 0 {
+		cutoff := summary.DeregisteredAt - retentionDays*24*3600
+		client.ZRemRangeByScore(clusterHistory, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	}
+}
+
+// GetClusterHistory - returns the summaries of clusters deregistered
+// between the two timestamps (inclusive) ordered by deregistration
+// time; summaries of teams rejected by the filter are omitted
+func GetClusterHistory(from int64, to int64, filter func(team string) bool) []ClusterSummary {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	history := make([]ClusterSummary, 0)
+	members := client.ZRangeByScore(clusterHistory, redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}).Val()
+
+	for _, el := range members {
+		var summary ClusterSummary
+		if serializer.Deserialize([]byte(el), &summary) != nil ||
+			(filter != nil && !filter(summary.Team)) {
+			continue
+		}
+		history = append(history, summary)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].DeregisteredAt < history[j].DeregisteredAt
+	})
+
+	return history
+}
+
+// HistoryCSVHeader - returns the column names of ClusterSummary.CSVRecord
+func HistoryCSVHeader() []string {
+	header := []string{"ClusterID", "CloudEnvironment", "Team", "Owner", "Caller",
+		"Template", "TemplateHash", "Nodes", "InstanceTypes", "RunID", "Attempt",
+		"RootClusterID", "RegisteredAt", "DeregisteredAt"}
+	for _, el := range HistoryPhases {
+		header = append(header, el+"At")
+	}
+	return append(header, "Outcome", "FailureReason", "EstimatedCost", "Currency")
+}
+
+func formatOptionalTimestamp(timestamp int64, ok bool) string {
+	if !ok || timestamp == 0 {
+		return ""
+	}
+	return strconv.FormatInt(timestamp, 10)
+}
+
+// CSVRecord - returns the summary as a row of HistoryCSVHeader columns
+func (s ClusterSummary) CSVRecord() []string {
+	record := []string{s.ClusterID, s.CloudEnvironment, s.Team, s.Owner, s.Caller,
+		s.Template, s.TemplateHash, strconv.FormatInt(s.Nodes, 10),
+		strings.Join(s.InstanceTypes, ";"), s.RunID, strconv.Itoa(s.Attempt),
+		s.RootClusterID, formatOptionalTimestamp(s.RegisteredAt, true),
+		formatOptionalTimestamp(s.DeregisteredAt, true)}
+	for _, el := range HistoryPhases {
+		timestamp, ok := s.Phases[el]
+		record = append(record, formatOptionalTimestamp(timestamp, ok))
+	}
+	return append(record, s.Outcome, s.FailureReason,
+		strconv.FormatFloat(s.EstimatedCost, 'f', 4, 64), s.Currency)
+}
@@ -0,0 +1,264 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	jobMapPrefix            = "cluster.jobs."
+	maxParallelJobRefreshes = 8
+)
+
+// saveJobScript - records a job only while its cluster is registered, so
+// that a job saved concurrently with the deregistration of its cluster is
+// not left behind by deleteJobs
+var saveJobScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[2], ARGV[2], ARGV[3])
+return 1
+`)
+
+// SparkJob describes a spark application submitted to a cluster
+type SparkJob struct {
+	ID          string
+	ClusterID   string
+	Spec        cloud.SparkJobSpec
+	State       string
+	SubmittedAt int64
+	UpdatedAt   int64
+}
+
+func saveJob(job SparkJob) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(job)
+	if err != nil {
+		return err
+	}
+
+	saved, err := saveJobScript.Run(client, []string{statusMap, jobMapPrefix + job.ClusterID},
+		job.ClusterID, job.ID, string(buffer)).Int()
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return errors.New("cluster " + job.ClusterID + " is not registered")
+	}
+	return nil
+}
+
+func deleteJobs(clusterID string) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	client.Del(jobMapPrefix + clusterID)
+}
+
+func getSparkMaster(clusterID string) (cloud.SparkMaster, error) {
+	state, err := getLastEpoch(clusterID)
+	if err != nil {
+		return cloud.SparkMaster{}, errors.New("cluster " + clusterID + " is not registered")
+	}
+
+	if state.SparkStatus == nil {
+		return cloud.SparkMaster{}, errors.New("cluster " + clusterID + " has not checked-in")
+	}
+
+	return cloud.GetSparkMaster(*state.SparkStatus)
+}
+
+func getJobs(clusterID string) []SparkJob {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	jobs := make([]SparkJob, 0)
+	for _, buffer := range client.HGetAll(jobMapPrefix + clusterID).Val() {
+		var job SparkJob
+		if serializer.Deserialize([]byte(buffer), &job) == nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].SubmittedAt < jobs[j].SubmittedAt
+	})
+
+	return jobs
+}
+
+func refreshJob(master cloud.SparkMaster, job SparkJob) SparkJob {
+	if cloud.IsDriverStateTerminal(job.State) {
+		return job
+	}
+
+	state, err := cloud.GetSparkJobState(master, job.ID)
+	if err != nil {
+		logger.GetError().Printf("unable to refresh state of job %v on cluster %v: %v",
+			job.ID, job.ClusterID, err)
+		return job
+	}
+
+	if state != job.State {
+		logger.GetInfo().Printf("job %v on cluster %v changed state from %v to %v",
+			job.ID, job.ClusterID, job.State, state)
+		job.State = state
+		job.UpdatedAt = getTimestamp()
+		err = saveJob(job)
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+	}
+
+	return job
+}
+
+// refreshJobs - updates the state of all non-terminal jobs
+// submitted to the cluster and returns the result; jobs are refreshed
+// concurrently, so that a check-in waits for at most a few requests to
+// the spark master
+func refreshJobs(clusterID string, status cloud.SparkClusterStatus) []SparkJob {
+	jobs := getJobs(clusterID)
+	if !jobsActive(jobs) {
+		return jobs
+	}
+
+	master, err := cloud.GetSparkMaster(status)
+	if err != nil {
+		logger.GetError().Println(err)
+		return jobs
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxParallelJobRefreshes)
+	for idx := range jobs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			jobs[idx] = refreshJob(master, jobs[idx])
+		}(idx)
+	}
+
+	wg.Wait()
+	return jobs
+}
+
+func jobsActive(jobs []SparkJob) bool {
+	for _, el := range jobs {
+		if !cloud.IsDriverStateTerminal(el.State) {
+			return true
+		}
+	}
+	return false
+}
+
+func jobsFailed(jobs []SparkJob) bool {
+	for _, el := range jobs {
+		if cloud.IsDriverStateFailed(el.State) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitJob - submits a spark application to a registered cluster
+func SubmitJob(clusterID string, spec cloud.SparkJobSpec) (SparkJob, error) {
+	status := GetLastKnownStatus(clusterID)
+	if status != StatusIdle && status != StatusRunning {
+		return SparkJob{}, errors.New("cluster " + clusterID +
+			" with status " + status + " is not accepting jobs")
+	}
+
+	master, err := getSparkMaster(clusterID)
+	if err != nil {
+		return SparkJob{}, err
+	}
+
+	submissionID, err := cloud.SubmitSparkJob(master, spec)
+	if err != nil {
+		return SparkJob{}, err
+	}
+
+	logger.GetInfo().Printf("submitted job %v to cluster %v", submissionID, clusterID)
+
+	job := SparkJob{
+		ID:          submissionID,
+		ClusterID:   clusterID,
+		Spec:        spec,
+		State:       cloud.DriverStateSubmitted,
+		SubmittedAt: getTimestamp(),
+		UpdatedAt:   getTimestamp(),
+	}
+
+	return job, saveJob(job)
+}
+
+// GetJobs - returns all jobs submitted to the cluster
+func GetJobs(clusterID string) ([]SparkJob, error) {
+	if GetLastKnownStatus(clusterID) == StatusNotRegistered {
+		return nil, errors.New("cluster " + clusterID + " is not registered")
+	}
+
+	return getJobs(clusterID), nil
+}
+
+// GetJob - returns the current state of a job submitted to the cluster
+func GetJob(clusterID string, jobID string) (SparkJob, error) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	var job SparkJob
+	buffer, err := client.HGet(jobMapPrefix+clusterID, jobID).Result()
+	if err != nil {
+		return job, errors.New("job " + jobID + " not found on cluster " + clusterID)
+	}
+
+	err = serializer.Deserialize([]byte(buffer), &job)
+	if err != nil {
+		return job, err
+	}
+
+	master, err := getSparkMaster(clusterID)
+	if err != nil {
+		return job, nil
+	}
+
+	return refreshJob(master, job), nil
+}
+
+// KillJob - requests the termination of a job submitted to the cluster
+func KillJob(clusterID string, jobID string) (SparkJob, error) {
+	job, err := GetJob(clusterID, jobID)
+	if err != nil {
+		return job, err
+	}
+
+	if cloud.IsDriverStateTerminal(job.State) {
+		return job, errors.New("job " + jobID + " has already completed with state " + job.State)
+	}
+
+	master, err := getSparkMaster(clusterID)
+	if err != nil {
+		return job, err
+	}
+
+	err = cloud.KillSparkJob(master, jobID)
+	if err != nil {
+		return job, err
+	}
+
+	logger.GetInfo().Printf("killed job %v on cluster %v", jobID, clusterID)
+	return refreshJob(master, job), nil
+}
@@ -2,10 +2,13 @@ package monitor
 
 import (
 	"allspark/cloud"
+	"allspark/daemon"
 	"allspark/datastore"
 	"allspark/logger"
+	"bytes"
 	"errors"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 // Spark cluster status constants
 const (
 	StatusNotRegistered = "NOT_REGISTERED"
+	StatusQueued        = "QUEUED"
 	StatusPending       = "PENDING"
 	StatusIdle          = "IDLE"
 	StatusRunning       = "RUNNING"
@@ -28,6 +32,13 @@ const (
 	clusterLockPreifx   = "cluster.lock."
 )
 
+// Application failure policies; determine which completed
+// applications are considered when resolving the cluster status
+const (
+	AppFailurePolicyAny  = "any"
+	AppFailurePolicyLast = "last"
+)
+
 // SparkClusterStatusAtEpoch describes the state of a cluster
 // at a given timestamp
 type SparkClusterStatusAtEpoch struct {
@@ -36,6 +47,33 @@ type SparkClusterStatusAtEpoch struct {
 	Status           string
 	Client           []byte
 	CloudEnvironment string
+	SparkStatus      *cloud.SparkClusterStatus `json:",omitempty"`
+	RunID            string                    `json:",omitempty"`
+	AppResults       []cloud.SparkAppResult    `json:",omitempty"`
+	FailureReason    string                    `json:",omitempty"`
+	Attempt          int                       `json:",omitempty"`
+	RootClusterID    string                    `json:",omitempty"`
+	Caller           string                    `json:",omitempty"`
+	Team             string                    `json:",omitempty"`
+	Owner            string                    `json:",omitempty"`
+	RuntimeExtension int64                     `json:",omitempty"`
+	Template         string                    `json:",omitempty"`
+	RegisteredAt     int64                     `json:",omitempty"`
+	Phases           map[string]int64          `json:",omitempty"`
+}
+
+// ClusterRequest describes a request to register and launch a cluster
+type ClusterRequest struct {
+	ClusterID        string
+	CloudEnvironment string
+	Client           []byte
+	RunID            string
+	Attempt          int
+	RootClusterID    string
+	Caller           string
+	Team             string
+	Owner            string
+	Template         string
 }
 
 // GetClientData - Returns the serialized and cloud environment
@@ -51,7 +89,17 @@ func GetClientData(clusterID string) ([]byte, string, error) {
 
 // HandleCheckIn - handles spark monitor check-in http requests
 func HandleCheckIn(clusterID string, appExitStatus string,
-	clusterStatus cloud.SparkClusterStatus) {
+	appResults []cloud.SparkAppResult, clusterStatus cloud.SparkClusterStatus) {
+
+	// jobs are refreshed before taking the lock, so that requests to the
+	// spark master do not hold it; clusters which are no longer registered
+	// or are being canceled have no use for their jobs
+	var jobs []SparkJob
+	switch GetLastKnownStatus(clusterID) {
+	case StatusNotRegistered, StatusCanceled:
+	default:
+		jobs = refreshJobs(clusterID, clusterStatus)
+	}
 
 	err := acquireClusterLock(clusterID, "check-in", 5)
 	if err != nil {
@@ -87,7 +135,8 @@ func HandleCheckIn(clusterID string, appExitStatus string,
 	}
 
 	var timestamp int64
-	reportedStatus := resolveClusterStatus(appExitStatus, clusterStatus, priorClusterState.Status)
+	reportedStatus := resolveClusterStatus(appExitStatus, appResults,
+		clusterStatus, priorClusterState.Status, jobs)
 	if reportedStatus == StatusError {
 		logger.GetError().Printf("cluster: %v reported status: %+v", clusterID, StatusError)
 	} else {
@@ -100,12 +149,16 @@ func HandleCheckIn(clusterID string, appExitStatus string,
 		timestamp = priorClusterState.Timestamp
 	}
 
-	epochStatus := SparkClusterStatusAtEpoch{
-		LastCheckIn:      getTimestamp(),
-		Timestamp:        timestamp,
-		Status:           reportedStatus,
-		Client:           priorClusterState.Client,
-		CloudEnvironment: priorClusterState.CloudEnvironment,
+	epochStatus := priorClusterState
+	epochStatus.LastCheckIn = getTimestamp()
+	epochStatus.Timestamp = timestamp
+	epochStatus.Status = reportedStatus
+	if reportedStatus == StatusError && len(epochStatus.FailureReason) == 0 {
+		epochStatus.FailureReason = FailureAppFailure
+	}
+	epochStatus.SparkStatus = &clusterStatus
+	if len(appResults) > 0 {
+		epochStatus.AppResults = appResults
 	}
 
 	if priorClusterState.Status != StatusDone &&
@@ -120,51 +173,240 @@ func HandleCheckIn(clusterID string, appExitStatus string,
 	}
 }
 
+// HandleInterruption - fails the cluster when one of its nodes receives
+// a termination notice, as its spark applications cannot complete
+func HandleInterruption(clusterID string, interruption cloud.NodeInterruption) {
+	err := acquireClusterLock(clusterID, "interruption", 5)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+	defer releaseClusterLock(clusterID)
+
+	status, err := getLastEpoch(clusterID)
+	if err != nil {
+		return
+	}
+
+	switch status.Status {
+	case StatusPending, StatusIdle, StatusRunning:
+	default:
+		logger.GetInfo().Printf("cluster: %v reported interruption of %v with status %v; ignoring",
+			clusterID, interruption.InstanceID, status.Status)
+		return
+	}
+
+	logger.GetError().Printf("cluster: %v node %v received %v notice for %v",
+		clusterID, interruption.InstanceID, interruption.Action, interruption.Time)
+	status.Status = StatusError
+	status.FailureReason = FailureInterrupted
+	status.Timestamp = getTimestamp()
+	setStatus(clusterID, status, true)
+}
+
 // RegisterCluster - registers newly created spark
 // cluster with a pending status
 func RegisterCluster(clusterID string, cloudEnvironment string, serializedClient []byte) error {
-	logger.GetInfo().Printf("registering cluster: %s, %s",
-		clusterID, cloudEnvironment)
+	return registerCluster(ClusterRequest{
+		ClusterID:        clusterID,
+		CloudEnvironment: cloudEnvironment,
+		Client:           serializedClient,
+	}, StatusPending)
+}
+
+func registerCluster(request ClusterRequest, status string) error {
+	logger.GetInfo().Printf("registering cluster: %s, %s, %s",
+		request.ClusterID, request.CloudEnvironment, status)
 
-	success := setStatus(clusterID, SparkClusterStatusAtEpoch{
-		Status:           StatusPending,
+	// queued clusters are registered once admitted
+	var registeredAt int64
+	if status != StatusQueued {
+		registeredAt = getTimestamp()
+	}
+
+	success := setStatus(request.ClusterID, SparkClusterStatusAtEpoch{
+		Status:           status,
 		Timestamp:        getTimestamp(),
 		LastCheckIn:      getTimestamp(),
-		Client:           serializedClient,
-		CloudEnvironment: cloudEnvironment,
+		Client:           request.Client,
+		CloudEnvironment: request.CloudEnvironment,
+		RunID:            request.RunID,
+		Attempt:          request.Attempt,
+		RootClusterID:    request.RootClusterID,
+		Caller:           request.Caller,
+		Team:             request.Team,
+		Owner:            request.Owner,
+		Template:         request.Template,
+		RegisteredAt:     registeredAt,
 	}, false)
 
 	if !success {
-		return errors.New("cluster" + clusterID + " already exists")
+		return errors.New("cluster" + request.ClusterID + " already exists")
 	}
 
 	return nil
 }
 
-// DeregisterCluster - registers newly created spark
-// cluster with a pending status
+// LaunchCluster - registers the cluster and creates it in the
+// requested cloud environment; clusters that fail to launch are canceled.
+// Clusters exceeding the configured capacity limits are either rejected
+// with ErrCapacityExceeded or registered as queued, in which case the
+// monitor creates them once capacity is available. Returns the status
+// of the cluster.
+func LaunchCluster(request ClusterRequest) (string, error) {
+	client, err := cloud.Create(request.CloudEnvironment, request.Client)
+	if err != nil {
+		return StatusNotRegistered, err
+	}
+
+	status, err := admitCluster(request, client)
+	if err != nil || status == StatusQueued {
+		return status, err
+	}
+
+	_, err = client.CreateCluster()
+	recordClient(request.ClusterID, client)
+	if err != nil {
+		setCanceled(request.ClusterID, FailureLaunch)
+		return StatusCanceled, err
+	}
+
+	return StatusPending, nil
+}
+
+// recordClient - stores the cloud environment of a created cluster, as
+// creation may record where the nodes of the cluster were launched
+func recordClient(clusterID string, client cloud.CloudEnvironment) {
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	err = acquireClusterLock(clusterID, "launch", 5)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+	defer releaseClusterLock(clusterID)
+
+	status, err := getLastEpoch(clusterID)
+	if err != nil || bytes.Equal(status.Client, serializedClient) {
+		return
+	}
+
+	status.Client = serializedClient
+	setStatus(clusterID, status, true)
+}
+
+// DeregisterCluster - removes the cluster from the registry, archives a
+// summary of it in the cluster history and publishes a final event, so
+// that watchers stop tracking the cluster
 func DeregisterCluster(clusterID string) {
 	logger.GetInfo().Printf("deregistering cluster %s", clusterID)
+	archiveCluster(clusterID)
+	status, err := getLastEpoch(clusterID)
+
 	client := datastore.GetRedisClient()
 	defer client.Close()
 
 	client.HDel(statusMap, clusterID)
+	if err == nil {
+		publishEvent(client, clusterID, SparkClusterStatusAtEpoch{
+			Status:      StatusNotRegistered,
+			Timestamp:   getTimestamp(),
+			LastCheckIn: status.LastCheckIn,
+			Team:        status.Team,
+		})
+	}
+	deleteJobs(clusterID)
+	deleteUsageAccrual(clusterID)
+	deleteClusterCost(clusterID)
+	deleteWorkerRegistrations(clusterID)
+}
+
+func getAppFailurePolicy() string {
+	policy := daemon.GetAllSparkConfig().AppFailurePolicy
+	if policy == AppFailurePolicyLast {
+		return policy
+	}
+	return AppFailurePolicyAny
+}
+
+// latestCompletedApp - returns the completed application which ended
+// last; spark lists completed applications oldest first, so ties go to
+// the later entry
+func latestCompletedApp(status cloud.SparkClusterStatus) (cloud.SparkApp, bool) {
+	if len(status.CompletedApps) == 0 {
+		return cloud.SparkApp{}, false
+	}
+
+	latest := status.CompletedApps[0]
+	for _, el := range status.CompletedApps[1:] {
+		if el.StartTime+el.Duration >= latest.StartTime+latest.Duration {
+			latest = el
+		}
+	}
+	return latest, true
 }
 
-func resolveClusterStatus(appExitStatus string, status cloud.SparkClusterStatus, priorStatus string) string {
+// appsFailed - returns true if the completed applications or reported
+// application results indicate a failure under the app failure policy
+func appsFailed(appResults []cloud.SparkAppResult,
+	status cloud.SparkClusterStatus, policy string) bool {
+
+	if policy == AppFailurePolicyLast {
+		if latest, ok := latestCompletedApp(status); ok && latest.State != StatusFinished {
+			return true
+		}
+
+		if len(appResults) > 0 {
+			last := appResults[0]
+			for _, el := range appResults[1:] {
+				if el.EndTime >= last.EndTime {
+					last = el
+				}
+			}
+			return last.Failed()
+		}
+		return false
+	}
+
+	for _, el := range status.CompletedApps {
+		if el.State != StatusFinished {
+			return true
+		}
+	}
+
+	for _, el := range appResults {
+		if el.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveClusterStatus(appExitStatus string, appResults []cloud.SparkAppResult,
+	status cloud.SparkClusterStatus, priorStatus string, jobs []SparkJob) string {
 	if len(appExitStatus) > 0 {
 		// currently all appExitStates with length > 0 are assumed to be error states
 		return StatusError
 	}
 
-	if len(status.ActiveApps) > 0 {
+	if jobsFailed(jobs) || appsFailed(appResults, status, getAppFailurePolicy()) {
+		return StatusError
+	}
+
+	if len(status.ActiveApps) > 0 || jobsActive(jobs) {
 		return StatusRunning
 	} else if len(status.CompletedApps) > 0 {
-		if status.CompletedApps[0].State != StatusFinished {
-			return StatusError
+		if len(jobs) > 0 {
+			// submitted jobs keep the cluster available for further
+			// submissions until the idle timeout is exceeded
+			return StatusIdle
 		}
 		return StatusDone
-	} else if (priorStatus == StatusRunning) && len(status.ActiveApps) == 0 {
+	} else if (priorStatus == StatusRunning) && len(jobs) == 0 {
 		// cluster has gone from running to idle; assume status done
 		return StatusDone
 	}
@@ -190,6 +432,111 @@ func getLastEpoch(clusterID string) (SparkClusterStatusAtEpoch, error) {
 	return clusterState, nil
 }
 
+// ClusterDetail describes the externally visible state of a cluster;
+// the serialized client is omitted as it may contain credentials
+type ClusterDetail struct {
+	ClusterID        string
+	Status           string
+	Timestamp        int64
+	LastCheckIn      int64
+	CloudEnvironment string
+	RunID            string                 `json:",omitempty"`
+	AppResults       []cloud.SparkAppResult `json:",omitempty"`
+	FailureReason    string                 `json:",omitempty"`
+	Attempt          int                    `json:",omitempty"`
+	RootClusterID    string                 `json:",omitempty"`
+	Caller           string                 `json:",omitempty"`
+	Team             string                 `json:",omitempty"`
+	Owner            string                 `json:",omitempty"`
+	RuntimeExtension int64                  `json:",omitempty"`
+	Template         string                 `json:",omitempty"`
+	RegisteredAt     int64                  `json:",omitempty"`
+	HourlyCost       float64
+	EstimatedCost    float64
+	CostPriced       bool
+	Pools            []PoolHealth `json:",omitempty"`
+	SparkStatus      *cloud.SparkClusterStatus
+}
+
+// GetClusterDetail - returns the externally visible state of a cluster
+func GetClusterDetail(clusterID string) (ClusterDetail, error) {
+	clusterState, err := getLastEpoch(clusterID)
+	if err != nil {
+		return ClusterDetail{}, errors.New("cluster " + clusterID + " is not registered")
+	}
+
+	hourlyCost, priced := getHourlyCost(clusterState)
+
+	return ClusterDetail{
+		ClusterID:        clusterID,
+		Status:           clusterState.Status,
+		Timestamp:        clusterState.Timestamp,
+		LastCheckIn:      clusterState.LastCheckIn,
+		CloudEnvironment: clusterState.CloudEnvironment,
+		RunID:            clusterState.RunID,
+		AppResults:       clusterState.AppResults,
+		FailureReason:    clusterState.FailureReason,
+		Attempt:          clusterState.Attempt,
+		RootClusterID:    clusterState.RootClusterID,
+		Caller:           clusterState.Caller,
+		Team:             clusterState.Team,
+		Owner:            clusterState.Owner,
+		RuntimeExtension: clusterState.RuntimeExtension,
+		Template:         getTemplateName(clusterState),
+		RegisteredAt:     clusterState.RegisteredAt,
+		HourlyCost:       hourlyCost,
+		EstimatedCost:    getAccruedCost(clusterID),
+		CostPriced:       priced,
+		Pools:            getClusterPoolHealth(clusterID, clusterState),
+		SparkStatus:      clusterState.SparkStatus,
+	}, nil
+}
+
+// GetClusters - returns the externally visible state of all registered clusters
+func GetClusters() []ClusterDetail {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	clusters := make([]ClusterDetail, 0)
+	for clusterID := range client.HGetAll(statusMap).Val() {
+		detail, err := GetClusterDetail(clusterID)
+		if err == nil {
+			clusters = append(clusters, detail)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].ClusterID < clusters[j].ClusterID
+	})
+
+	return clusters
+}
+
+// ExtendCluster - extends the maximum run-time of a registered cluster
+func ExtendCluster(clusterID string, seconds int64) (ClusterDetail, error) {
+	if seconds <= 0 {
+		return ClusterDetail{}, errors.New("extension must be a positive number of seconds")
+	}
+
+	err := acquireClusterLock(clusterID, "extend", 5)
+	if err != nil {
+		return ClusterDetail{}, err
+	}
+	defer releaseClusterLock(clusterID)
+
+	status, err := getLastEpoch(clusterID)
+	if err != nil {
+		return ClusterDetail{}, errors.New("cluster " + clusterID + " is not registered")
+	}
+
+	logger.GetInfo().Printf("extending max run-time of cluster %v by %v seconds",
+		clusterID, seconds)
+	status.RuntimeExtension += seconds
+	setStatus(clusterID, status, true)
+
+	return GetClusterDetail(clusterID)
+}
+
 // GetLastKnownStatus - returns the last known status of the cluster
 func GetLastKnownStatus(clusterID string) string {
 	clusterState, err := getLastEpoch(clusterID)
@@ -201,6 +548,10 @@ func GetLastKnownStatus(clusterID string) string {
 
 // SetCanceled - Sets the cluster to StatusCanceled so be terminated
 func SetCanceled(clusterID string) error {
+	return setCanceled(clusterID, "")
+}
+
+func setCanceled(clusterID string, failureReason string) error {
 	logger.GetInfo().Printf("handling request to cancel cluster %v ",
 		clusterID)
 	err := acquireClusterLock(clusterID, "canceled", 5)
@@ -222,13 +573,10 @@ func SetCanceled(clusterID string) error {
 		priorClusterState.Status != StatusCanceled &&
 		priorClusterState.Status != StatusNotRegistered {
 
-		epochStatus := SparkClusterStatusAtEpoch{
-			LastCheckIn:      priorClusterState.LastCheckIn,
-			Timestamp:        getTimestamp(),
-			Status:           StatusCanceled,
-			Client:           priorClusterState.Client,
-			CloudEnvironment: priorClusterState.CloudEnvironment,
-		}
+		epochStatus := priorClusterState
+		epochStatus.Timestamp = getTimestamp()
+		epochStatus.Status = StatusCanceled
+		epochStatus.FailureReason = failureReason
 
 		setStatus(clusterID, epochStatus, true)
 	} else {
@@ -244,16 +592,25 @@ func setStatus(clusterID string, status SparkClusterStatusAtEpoch, overwrite boo
 	client := datastore.GetRedisClient()
 	defer client.Close()
 
+	recordPhase(&status)
+
 	result, err := serializer.Serialize(status)
 	if err != nil {
 		logger.GetError().Println(err)
 	}
 
+	var success bool
 	if overwrite {
-		return client.HSet(statusMap, clusterID, string(result)).Val()
+		success = client.HSet(statusMap, clusterID, string(result)).Val()
+		publishEvent(client, clusterID, status)
+	} else {
+		success = client.HSetNX(statusMap, clusterID, string(result)).Val()
+		if success {
+			publishEvent(client, clusterID, status)
+		}
 	}
 
-	return client.HSetNX(statusMap, clusterID, string(result)).Val()
+	return success
 }
 
 // Run - daemon used for monitoring all spark clusters;
@@ -311,12 +668,26 @@ func monitorClusterHelper(maxRuntime int64, idleTimeout int64,
 		serializer.Deserialize([]byte(buffer), &status)
 
 		client, err := cloud.Create(status.CloudEnvironment, status.Client)
+		if err == nil {
+			accrueUsage(clusterID, status, client)
+		}
+
 		if err != nil {
 			logger.GetError().Println(err)
 			logger.GetError().Printf("cluster does not appear to be valid %v: %v",
 				clusterID, redisClient.HGet(statusMap, clusterID).Val())
 			logger.GetError().Printf("deregistering cluster %v", clusterID)
 			DeregisterCluster(clusterID)
+		} else if status.Status == StatusQueued {
+			logger.GetInfo().Printf("monitor reported %s for cluster %s",
+				status.Status, clusterID)
+		} else if len(status.RunID) > 0 && advanceRun(clusterID, &status) {
+			logger.GetInfo().Printf("run %v on cluster %s has completed; terminating",
+				status.RunID, clusterID)
+			terminateCluster(client)
+			status.Status = StatusTerminating
+			status.Timestamp = getTimestamp()
+			setStatus(clusterID, status, true)
 		} else {
 			currentTime := getTimestamp()
 			if currentTime-status.LastCheckIn > maxTimeWithoutCheckin &&
@@ -326,12 +697,14 @@ func monitorClusterHelper(maxRuntime int64, idleTimeout int64,
 					clusterID)
 
 				status.Status = StatusError
+				status.FailureReason = FailureMissedCheckIn
 				status.Timestamp = getTimestamp()
 				setStatus(clusterID, status, true)
-			} else if currentTime-status.Timestamp > maxRuntime {
+			} else if currentTime-status.Timestamp > maxRuntime+status.RuntimeExtension {
 				logger.GetError().Printf("max run-time exceeded for cluster %s; terminating",
 					clusterID)
 				status.Status = StatusError
+				status.FailureReason = FailureMaxRuntime
 				status.Timestamp = getTimestamp()
 				setStatus(clusterID, status, true)
 			} else {
@@ -344,6 +717,7 @@ func monitorClusterHelper(maxRuntime int64, idleTimeout int64,
 							clusterID)
 
 						status.Status = StatusError
+						status.FailureReason = FailurePendingTimeout
 						status.Timestamp = getTimestamp()
 						setStatus(clusterID, status, true)
 					}
@@ -388,6 +762,7 @@ func monitorClusterHelper(maxRuntime int64, idleTimeout int64,
 					logger.GetInfo().Printf("monitor reported %s for cluster %s",
 						status.Status, clusterID)
 					if client.DestructionConfirmed() {
+						scheduleRetry(clusterID, status)
 						DeregisterCluster(clusterID)
 					}
 					break
@@ -400,6 +775,10 @@ func monitorClusterHelper(maxRuntime int64, idleTimeout int64,
 		}
 		releaseClusterLock(clusterID)
 	}
+
+	processAdmissionQueue()
+	processRetries()
+	processBudgets()
 }
 
 func releaseLock() {
@@ -2,8 +2,13 @@ package monitor
 
 import (
 	"allspark/cloud"
+	"allspark/daemon"
+	"allspark/datastore"
 	"allspark/util/serializer"
 	"bytes"
+	"context"
+	"errors"
+	"math"
 	"strconv"
 	"testing"
 	"time"
@@ -336,7 +341,7 @@ func TestHandleCheckinError(t *testing.T) {
 	}
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
-	HandleCheckIn(client.ClusterID, "", clusterStatus)
+	HandleCheckIn(client.ClusterID, "", nil, clusterStatus)
 	status := GetLastKnownStatus(client.ClusterID)
 	if status != StatusError {
 		t.Error("status mismatch")
@@ -366,7 +371,7 @@ func TestHandleCheckinDone(t *testing.T) {
 	}
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
-	HandleCheckIn(client.ClusterID, "", clusterStatus)
+	HandleCheckIn(client.ClusterID, "", nil, clusterStatus)
 	status := GetLastKnownStatus(client.ClusterID)
 	if status != StatusDone {
 		t.Error("status mismatch")
@@ -396,7 +401,7 @@ func TestHandleCheckinIdle(t *testing.T) {
 	}
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
-	HandleCheckIn(client.ClusterID, "", clusterStatus)
+	HandleCheckIn(client.ClusterID, "", nil, clusterStatus)
 	status := GetLastKnownStatus(client.ClusterID)
 	if status != StatusIdle {
 		t.Error("status mismatch")
@@ -426,7 +431,7 @@ func TestHandleCheckinRunning(t *testing.T) {
 	}
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
-	HandleCheckIn(client.ClusterID, "", clusterStatus)
+	HandleCheckIn(client.ClusterID, "", nil, clusterStatus)
 	status := GetLastKnownStatus(client.ClusterID)
 	if status != StatusRunning {
 		t.Error("status mismatch")
@@ -456,7 +461,7 @@ func TestHandleCheckinAppExitStatus(t *testing.T) {
 	}
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
-	HandleCheckIn(client.ClusterID, StatusError, clusterStatus)
+	HandleCheckIn(client.ClusterID, StatusError, nil, clusterStatus)
 	status := GetLastKnownStatus(client.ClusterID)
 	if status != StatusError {
 		t.Error("status mismatch")
@@ -468,7 +473,7 @@ func TestHandleCheckinAppExitStatus(t *testing.T) {
 
 	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
 
-	HandleCheckIn(client.ClusterID, StatusError, clusterStatus)
+	HandleCheckIn(client.ClusterID, StatusError, nil, clusterStatus)
 	status = GetLastKnownStatus(client.ClusterID)
 	if status != StatusError {
 		t.Error("status mismatch")
@@ -476,7 +481,7 @@ func TestHandleCheckinAppExitStatus(t *testing.T) {
 		t.Error("-actual: " + status)
 	}
 
-	HandleCheckIn(client.ClusterID, StatusDone, clusterStatus)
+	HandleCheckIn(client.ClusterID, StatusDone, nil, clusterStatus)
 	status = GetLastKnownStatus(client.ClusterID)
 	if status != StatusError {
 		t.Error("status mismatch")
@@ -744,3 +749,891 @@ func TestUnregisterdEpochHandler(t *testing.T) {
 		t.Error("-actual: " + priorStatus.Status)
 	}
 }
+
+func TestWatchClusters(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var clusterStatus cloud.SparkClusterStatus
+	err = serializer.Deserialize([]byte(RunningStateCheckIn), &clusterStatus)
+	if err != nil {
+		t.Error(err)
+	}
+
+	DeregisterCluster(client.ClusterID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := WatchClusters(ctx, client.ClusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
+	HandleCheckIn(client.ClusterID, "", nil, clusterStatus)
+
+	expected := []string{StatusPending, StatusRunning}
+	for _, status := range expected {
+		select {
+		case event := <-events:
+			if event.Status != status {
+				t.Error("status mismatch")
+				t.Error("-expected: " + status)
+				t.Error("-actual: " + event.Status)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for cluster event")
+		}
+	}
+
+	if len(GetClusterEvents(client.ClusterID)) != 1 {
+		t.Error("expected a single event for a registered cluster")
+	}
+
+	DeregisterCluster(client.ClusterID)
+	select {
+	case event := <-events:
+		if event.Status != StatusNotRegistered || event.Timestamp == 0 {
+			t.Errorf("expected a final event for the deregistered cluster: %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for cluster event")
+	}
+
+	if len(GetClusterEvents(client.ClusterID)) != 0 {
+		t.Error("expected no events for a deregistered cluster")
+	}
+	cancel()
+
+	if _, ok := <-events; ok {
+		t.Error("expected event channel to be closed")
+	}
+}
+
+func TestResolveClusterStatusWithJobs(t *testing.T) {
+	var idleStatus cloud.SparkClusterStatus
+	err := serializer.Deserialize([]byte(IdleStateCheckIn), &idleStatus)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var doneStatus cloud.SparkClusterStatus
+	err = serializer.Deserialize([]byte(DoneStateCheckIn), &doneStatus)
+	if err != nil {
+		t.Error(err)
+	}
+
+	activeJobs := []SparkJob{{ID: "driver-1", State: cloud.DriverStateSubmitted}}
+	finishedJobs := []SparkJob{{ID: "driver-1", State: cloud.DriverStateFinished}}
+	failedJobs := []SparkJob{
+		{ID: "driver-1", State: cloud.DriverStateFinished},
+		{ID: "driver-2", State: cloud.DriverStateFailed},
+	}
+
+	testCases := []struct {
+		status      cloud.SparkClusterStatus
+		priorStatus string
+		jobs        []SparkJob
+		expected    string
+	}{
+		{idleStatus, StatusIdle, activeJobs, StatusRunning},
+		{idleStatus, StatusRunning, finishedJobs, StatusIdle},
+		{doneStatus, StatusRunning, finishedJobs, StatusIdle},
+		{doneStatus, StatusRunning, failedJobs, StatusError},
+		{idleStatus, StatusRunning, nil, StatusDone},
+		{doneStatus, StatusRunning, nil, StatusDone},
+	}
+
+	for _, el := range testCases {
+		status := resolveClusterStatus("", nil, el.status, el.priorStatus, el.jobs)
+		if status != el.expected {
+			t.Error("status mismatch")
+			t.Error("-expected: " + el.expected)
+			t.Error("-actual: " + status)
+		}
+	}
+}
+
+func TestSubmitJobUnregisteredCluster(t *testing.T) {
+	_, err := SubmitJob("does-not-exist", cloud.SparkJobSpec{
+		PythonFile: "s3://bucket/app.py",
+	})
+	if err == nil {
+		t.Error("expected non-nil error")
+	}
+
+	_, err = GetJobs("does-not-exist")
+	if err == nil {
+		t.Error("expected non-nil error")
+	}
+}
+
+func TestSaveJobDeregisteredCluster(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	DeregisterCluster(client.ClusterID)
+	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
+
+	job := SparkJob{ID: "driver-1", ClusterID: client.ClusterID, State: cloud.DriverStateSubmitted}
+	if err = saveJob(job); err != nil || len(getJobs(client.ClusterID)) != 1 {
+		t.Errorf("expected the job of a registered cluster to be saved: %v", err)
+	}
+
+	// a refresh racing the deregistration must not leave the job behind
+	DeregisterCluster(client.ClusterID)
+	job.State = cloud.DriverStateRunning
+	if saveJob(job) == nil || len(getJobs(client.ClusterID)) != 0 {
+		t.Error("expected the job of a deregistered cluster not to be saved")
+	}
+}
+
+func TestRunCanceledCluster(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	runID := NewRunID()
+	client.ClusterID = runID
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = saveRun(SparkRun{
+		ID:               runID,
+		ClusterID:        runID,
+		CloudEnvironment: cloud.Aws,
+		Job:              cloud.SparkJobSpec{PythonFile: "s3://bucket/app.py"},
+		Status:           RunStatusPending,
+		CreatedAt:        getTimestamp(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerCluster(ClusterRequest{
+		ClusterID:        runID,
+		CloudEnvironment: cloud.Aws,
+		Client:           serlializedClient,
+		RunID:            runID,
+	}, StatusPending)
+	SetCanceled(runID)
+
+	Run(1, 9999, 9999, 9999, 9999, 9999, 9999)
+	run, err := GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Status != RunStatusFailed {
+		t.Error("status mismatch")
+		t.Error("-expected: " + RunStatusFailed)
+		t.Error("-actual: " + run.Status)
+	}
+
+	if run.ClusterStatus != StatusCanceled {
+		t.Error("cluster status mismatch")
+		t.Error("-expected: " + StatusCanceled)
+		t.Error("-actual: " + run.ClusterStatus)
+	}
+
+	DeregisterCluster(runID)
+
+	_, err = GetRun(runID)
+	if err != nil {
+		t.Error("expected run record to be retained after deregistration")
+	}
+}
+
+func TestAppsFailed(t *testing.T) {
+	var doneStatus cloud.SparkClusterStatus
+	err := serializer.Deserialize([]byte(DoneStateCheckIn), &doneStatus)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// spark lists completed applications oldest first
+	finished := doneStatus.CompletedApps[0]
+	failedLast := doneStatus
+	failedLast.CompletedApps = []cloud.SparkApp{finished, {ID: "app-2", State: "FAILED",
+		StartTime: finished.StartTime + finished.Duration + 1000, Duration: 5000}}
+
+	failedFirst := doneStatus
+	failedFirst.CompletedApps = []cloud.SparkApp{{ID: "app-0", State: "FAILED",
+		StartTime: finished.StartTime - 10000, Duration: 5000}, finished}
+
+	results := []cloud.SparkAppResult{
+		{AppID: "app-1", ExitCode: 1, EndTime: 10},
+		{AppID: "app-2", ExitCode: 0, DriverState: cloud.DriverStateFinished, EndTime: 20},
+	}
+
+	testCases := []struct {
+		status   cloud.SparkClusterStatus
+		results  []cloud.SparkAppResult
+		policy   string
+		expected bool
+	}{
+		{doneStatus, nil, AppFailurePolicyAny, false},
+		{failedLast, nil, AppFailurePolicyAny, true},
+		{failedFirst, nil, AppFailurePolicyAny, true},
+		{failedFirst, nil, AppFailurePolicyLast, false},
+		{failedLast, nil, AppFailurePolicyLast, true},
+		{doneStatus, results, AppFailurePolicyAny, true},
+		{doneStatus, results, AppFailurePolicyLast, false},
+		{failedLast, results, AppFailurePolicyLast, true},
+		{doneStatus, []cloud.SparkAppResult{{AppID: "app-3", AppState: "KILLED"}},
+			AppFailurePolicyAny, true},
+		{doneStatus, []cloud.SparkAppResult{{AppID: "app-3", AppState: cloud.AppStateFinished}},
+			AppFailurePolicyAny, false},
+	}
+
+	for idx, el := range testCases {
+		if appsFailed(el.results, el.status, el.policy) != el.expected {
+			t.Errorf("test case %v: expected appsFailed to return %v", idx, el.expected)
+		}
+	}
+
+	latest, ok := latestCompletedApp(failedFirst)
+	if !ok || latest.ID != finished.ID {
+		t.Errorf("expected the latest completed app to be %v, got %v", finished.ID, latest.ID)
+	}
+	if _, ok = latestCompletedApp(cloud.SparkClusterStatus{}); ok {
+		t.Error("expected no latest app without completed apps")
+	}
+
+	status := resolveClusterStatus("", results, doneStatus, StatusRunning, nil)
+	if status != StatusError {
+		t.Error("status mismatch")
+		t.Error("-expected: " + StatusError)
+		t.Error("-actual: " + status)
+	}
+}
+
+func TestGetLastAppState(t *testing.T) {
+	if getLastAppState(SparkClusterStatusAtEpoch{}) != "" {
+		t.Error("expected no app state before check-in")
+	}
+
+	// spark lists completed applications oldest first
+	status := SparkClusterStatusAtEpoch{SparkStatus: &cloud.SparkClusterStatus{
+		CompletedApps: []cloud.SparkApp{
+			{ID: "app-1", State: "FAILED", StartTime: 1000, Duration: 500},
+			{ID: "app-2", State: StatusFinished, StartTime: 2000, Duration: 500},
+		},
+	}}
+	if state := getLastAppState(status); state != StatusFinished {
+		t.Errorf("expected the state of the last app, got %v", state)
+	}
+}
+
+func TestHandleCheckinAppResults(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var clusterStatus cloud.SparkClusterStatus
+	err = serializer.Deserialize([]byte(DoneStateCheckIn), &clusterStatus)
+	if err != nil {
+		t.Error(err)
+	}
+
+	results := []cloud.SparkAppResult{
+		{AppID: "app-1", DriverState: cloud.DriverStateFinished},
+		{AppID: "app-2", FailureReason: "executor lost", DriverState: cloud.DriverStateFailed},
+	}
+
+	RegisterCluster(client.ClusterID, cloud.Aws, serlializedClient)
+	HandleCheckIn(client.ClusterID, "", results, clusterStatus)
+
+	detail, err := GetClusterDetail(client.ClusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if detail.Status != StatusError {
+		t.Error("status mismatch")
+		t.Error("-expected: " + StatusError)
+		t.Error("-actual: " + detail.Status)
+	}
+
+	if len(detail.AppResults) != 2 || detail.AppResults[1].FailureReason != "executor lost" {
+		t.Errorf("unexpected app results: %+v", detail.AppResults)
+	}
+
+	DeregisterCluster(client.ClusterID)
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	client.RetryPolicy = &cloud.RetryPolicy{
+		MaxAttempts:      3,
+		BackoffSeconds:   30,
+		RetryableReasons: []string{FailureMissedCheckIn},
+	}
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	status := SparkClusterStatusAtEpoch{
+		Client:        serlializedClient,
+		FailureReason: FailureMissedCheckIn,
+	}
+	if getRetryPolicy(status) == nil {
+		t.Error("expected first attempt to be retried")
+	}
+
+	status.Attempt = 3
+	if getRetryPolicy(status) != nil {
+		t.Error("expected final attempt not to be retried")
+	}
+
+	status.Attempt = 1
+	status.FailureReason = FailureAppFailure
+	if getRetryPolicy(status) != nil {
+		t.Error("expected non-retryable reason not to be retried")
+	}
+
+	status.FailureReason = ""
+	if getRetryPolicy(status) != nil {
+		t.Error("expected cluster without failure not to be retried")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &cloud.RetryPolicy{MaxAttempts: cloud.MaxRetryAttempts, BackoffSeconds: 60}
+	for attempt, expected := range map[int]int64{2: 60, 3: 120, 5: 480} {
+		if backoff := retryBackoff(policy, attempt); backoff != expected {
+			t.Errorf("expected a backoff of %v for attempt %v, got %v", expected, attempt, backoff)
+		}
+	}
+
+	policy.BackoffSeconds = cloud.MaxRetryBackoffSeconds / 2
+	if backoff := retryBackoff(policy, 200); backoff != cloud.MaxRetryBackoffSeconds {
+		t.Errorf("expected the backoff to be capped, got %v", backoff)
+	}
+
+	policy.BackoffSeconds = 0
+	if backoff := retryBackoff(policy, 3); backoff != 0 {
+		t.Errorf("expected no backoff, got %v", backoff)
+	}
+}
+
+func TestFailRetryLaunch(t *testing.T) {
+	runID := NewRunID()
+	retryClusterID := runID + attemptIdentifier + "2"
+	err := saveRun(SparkRun{
+		ID:               runID,
+		ClusterID:        retryClusterID,
+		CloudEnvironment: cloud.Aws,
+		Job:              cloud.SparkJobSpec{PythonFile: "s3://bucket/app.py"},
+		Status:           RunStatusPending,
+		Attempts:         []string{runID, retryClusterID},
+		CreatedAt:        getTimestamp(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attempts of other clusters of the run are ignored
+	failRetryLaunch(ClusterRequest{ClusterID: runID + attemptIdentifier + "3", RunID: runID},
+		errors.New("quota exceeded"))
+	run, err := GetRun(runID)
+	if err != nil || run.Status != RunStatusPending {
+		t.Errorf("expected the run to await its attempt, got %+v: %v", run, err)
+	}
+
+	failRetryLaunch(ClusterRequest{ClusterID: retryClusterID, RunID: runID},
+		errors.New("quota exceeded"))
+	run, err = GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != RunStatusFailed || run.ClusterStatus != StatusNotRegistered ||
+		run.Error != "quota exceeded" {
+		t.Errorf("expected the run to fail with the launch error, got %+v", run)
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	runID := NewRunID()
+	client.ClusterID = runID
+	client.RetryPolicy = &cloud.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 9999}
+	serlializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = saveRun(SparkRun{
+		ID:               runID,
+		ClusterID:        runID,
+		CloudEnvironment: cloud.Aws,
+		Job:              cloud.SparkJobSpec{PythonFile: "s3://bucket/app.py"},
+		Status:           RunStatusRetrying,
+		CreatedAt:        getTimestamp(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduleRetry(runID, SparkClusterStatusAtEpoch{
+		Client:           serlializedClient,
+		CloudEnvironment: cloud.Aws,
+		RunID:            runID,
+		FailureReason:    FailureMissedCheckIn,
+	})
+
+	retryClusterID := runID + attemptIdentifier + "2"
+	run, err := GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.ClusterID != retryClusterID {
+		t.Error("cluster mismatch")
+		t.Error("-expected: " + retryClusterID)
+		t.Error("-actual: " + run.ClusterID)
+	}
+
+	if run.Status != RunStatusPending {
+		t.Error("status mismatch")
+		t.Error("-expected: " + RunStatusPending)
+		t.Error("-actual: " + run.Status)
+	}
+
+	if len(run.Attempts) != 2 || run.Attempts[0] != runID {
+		t.Error("expected both attempts to be recorded on the run")
+	}
+
+	// backoff has not elapsed, so the retry must remain queued
+	processRetries()
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	buffer, err := redisClient.HGet(retryQueue, retryClusterID).Result()
+	if err != nil {
+		t.Fatal("expected retry to be queued")
+	}
+	redisClient.HDel(retryQueue, retryClusterID)
+
+	var retry pendingRetry
+	err = serializer.Deserialize([]byte(buffer), &retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if retry.Request.Attempt != 2 || retry.Request.RootClusterID != runID {
+		t.Error("unexpected retry request: " + string(buffer))
+	}
+
+	retryID, err := cloud.GetClusterID(retry.Request.Client)
+	if err != nil || retryID != retryClusterID {
+		t.Error("expected serialized client to carry the retry cluster ID")
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	limits := daemon.CapacityLimits{MaxClusters: 2, MaxNodes: 5, MaxVCPU: 16}
+	requested := cloud.ClusterResources{Nodes: 2, VCPU: 8, MemoryGB: 32, ShapeKnown: true}
+
+	err := checkLimits("test", limits, capacityUsage{}, requested)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tests := []capacityUsage{
+		{Clusters: 2},
+		{Clusters: 1, Nodes: 4},
+		{Clusters: 1, Nodes: 1, VCPU: 9},
+	}
+
+	for _, usage := range tests {
+		err = checkLimits("test", limits, usage, requested)
+		if !errors.Is(err, ErrCapacityExceeded) {
+			t.Errorf("expected capacity to be exceeded for usage %+v", usage)
+		}
+	}
+
+	requested.ShapeKnown = false
+	err = checkLimits("test", limits, capacityUsage{}, requested)
+	if !errors.Is(err, ErrCapacityExceeded) {
+		t.Error("expected unknown instance shapes to be rejected by vCPU limits")
+	}
+
+	err = checkLimits("test", daemon.CapacityLimits{MaxClusters: 1},
+		capacityUsage{}, requested)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckTeamQuota(t *testing.T) {
+	request := ClusterRequest{Team: "etl"}
+	resources := cloud.ClusterResources{Nodes: 3,
+		Groups: []cloud.NodeGroup{{InstanceType: "m5.2xlarge", Nodes: 3}}}
+
+	err := checkTeamQuota(request, nil, resources)
+	if err != nil {
+		t.Error(err)
+	}
+
+	quota := &TeamQuota{Team: "etl", AllowedInstanceTypes: []string{"m5.xlarge", "m5.2xlarge"}}
+	err = checkTeamQuota(request, quota, resources)
+	if err != nil {
+		t.Error(err)
+	}
+
+	quota.AllowedInstanceTypes = []string{"m5.xlarge"}
+	err = checkTeamQuota(request, quota, resources)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Error("expected instance type to be rejected by team quota")
+	}
+}
+
+func TestAccrueCost(t *testing.T) {
+	daemon.Init("../daemon/allspark_config.json")
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	clusterID := NewRunID()
+	status := SparkClusterStatusAtEpoch{Team: NewRunID(), Template: "nightly"}
+	resources := cloud.ClusterResources{Nodes: 2,
+		Groups: []cloud.NodeGroup{{InstanceType: cloud.DockerInstanceType, Nodes: 2}}}
+
+	// accrue an hour spanning midnight so the cost is split across two days
+	midnight := time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+	accrueCost(redisClient, clusterID, status, resources,
+		midnight.Unix()-1800, midnight.Unix()+1800)
+	defer deleteClusterCost(clusterID)
+
+	expected := 2 * daemon.GetAllSparkConfig().Pricing.DockerNodeHourly
+	if cost := getAccruedCost(clusterID); math.Abs(cost-expected) > 1e-9 {
+		t.Error("accrued cost mismatch")
+		t.Errorf("-expected: %v", expected)
+		t.Errorf("-actual: %v", cost)
+	}
+
+	report, err := GetCostReport(midnight.AddDate(0, 0, -1), midnight,
+		func(team string) bool { return team == status.Team })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Rows) != 2 || report.Rows[0].Day != "2021-03-01" ||
+		report.Rows[1].Template != "nightly" || math.Abs(report.Total-expected) > 1e-9 {
+		t.Errorf("unexpected cost report: %+v", report)
+	}
+
+	for _, day := range []string{"2021-03-01", "2021-03-02"} {
+		redisClient.HDel(costLedgerPrefix+day, status.Team+costFieldSeparator+"nightly")
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	budget := Budget{Scope: BudgetScopeTeam, Target: "etl",
+		Period: BudgetPeriodMonthly, SoftLimit: 80, HardLimit: 100}
+	if err := ValidateBudget(budget); err != nil {
+		t.Error(err)
+	}
+
+	invalid := []Budget{
+		{Scope: "region", Target: "etl", Period: BudgetPeriodDaily, HardLimit: 1},
+		{Scope: BudgetScopeTeam, Period: BudgetPeriodDaily, HardLimit: 1},
+		{Scope: BudgetScopeTeam, Target: "etl", Period: "weekly", HardLimit: 1},
+		{Scope: BudgetScopeTeam, Target: "etl", Period: BudgetPeriodDaily},
+		{Scope: BudgetScopeTeam, Target: "etl", Period: BudgetPeriodDaily, SoftLimit: 2, HardLimit: 1},
+	}
+	for _, el := range invalid {
+		if ValidateBudget(el) == nil {
+			t.Errorf("expected non-nil error for budget %+v", el)
+		}
+	}
+}
+
+func TestGetBudgetState(t *testing.T) {
+	budget := Budget{Scope: BudgetScopeEnvironment, Target: cloud.Aws,
+		Period: BudgetPeriodMonthly, SoftLimit: 80, HardLimit: 100}
+
+	tests := []struct {
+		spend    float64
+		expected string
+	}{
+		{0, BudgetStateOK},
+		{79.99, BudgetStateOK},
+		{80, BudgetStateWarning},
+		{100, BudgetStateExceeded},
+	}
+	for _, el := range tests {
+		if state := getBudgetState(budget, el.spend); state != el.expected {
+			t.Errorf("state mismatch for spend %v", el.spend)
+			t.Error("-expected: " + el.expected)
+			t.Error("-actual: " + state)
+		}
+	}
+
+	now := time.Date(2021, 3, 17, 15, 4, 5, 0, time.UTC)
+	if start := getPeriodStart(BudgetPeriodMonthly, now); start.Day() != 1 || start.Hour() != 0 {
+		t.Errorf("unexpected monthly period start: %v", start)
+	}
+	if start := getPeriodStart(BudgetPeriodDaily, now); start.Day() != 17 || start.Hour() != 0 {
+		t.Errorf("unexpected daily period start: %v", start)
+	}
+
+	if !inBudgetScope(budget, "", cloud.Aws) || inBudgetScope(budget, "", cloud.Azure) {
+		t.Error("unexpected environment budget scope")
+	}
+
+	budget.Scope, budget.Target = BudgetScopeTeam, "etl"
+	if !inBudgetScope(budget, "etl", cloud.Aws) || inBudgetScope(budget, "", cloud.Aws) {
+		t.Error("unexpected team budget scope")
+	}
+}
+
+func TestClusterSummary(t *testing.T) {
+	status := SparkClusterStatusAtEpoch{Status: StatusPending, Timestamp: 100}
+	recordPhase(&status)
+	status.Status, status.Timestamp = StatusRunning, 200
+	recordPhase(&status)
+	status.Status, status.Timestamp = StatusDone, 300
+	recordPhase(&status)
+	status.Status, status.Timestamp = StatusDone, 350
+	recordPhase(&status)
+	status.Status, status.Timestamp = StatusTerminating, 400
+	recordPhase(&status)
+
+	if status.Phases[StatusDone] != 300 || status.Phases[StatusPending] != 100 {
+		t.Errorf("unexpected phases: %+v", status.Phases)
+	}
+
+	if outcome := getOutcome(status); outcome != StatusDone {
+		t.Error("outcome mismatch")
+		t.Error("-expected: " + StatusDone)
+		t.Error("-actual: " + outcome)
+	}
+
+	summary := ClusterSummary{ClusterID: "test", Nodes: 3, Phases: status.Phases,
+		InstanceTypes: []string{"m5.2xlarge", "m5.xlarge"}, Outcome: StatusDone}
+	record := summary.CSVRecord()
+	header := HistoryCSVHeader()
+	if len(record) != len(header) {
+		t.Fatalf("csv record has %v columns; header has %v", len(record), len(header))
+	}
+
+	for i, el := range header {
+		switch el {
+		case "InstanceTypes":
+			if record[i] != "m5.2xlarge;m5.xlarge" {
+				t.Error("unexpected instance types: " + record[i])
+			}
+		case "RUNNINGAt":
+			if record[i] != "200" {
+				t.Error("unexpected running timestamp: " + record[i])
+			}
+		case "QUEUEDAt":
+			if record[i] != "" {
+				t.Error("expected empty timestamp for unvisited phase: " + record[i])
+			}
+		}
+	}
+}
+
+func TestGetPoolHealth(t *testing.T) {
+	groups := []cloud.NodeGroup{
+		{InstanceType: "m5.2xlarge", Nodes: 1},
+		{InstanceType: "r5.4xlarge", Nodes: 2, Pool: "ondemand"},
+		{InstanceType: "r5.2xlarge", Nodes: 1, Pool: "spot"},
+	}
+	hosts := map[string]string{"10.0.0.1": "ondemand", "10.0.0.2": "ondemand", "10.0.0.3": "spot"}
+	status := &cloud.SparkClusterStatus{Workers: []cloud.SparkWorker{
+		{Host: "10.0.0.1", State: "ALIVE"},
+		{Host: "10.0.0.2", State: "DEAD"},
+		{Host: "10.0.0.3", State: "ALIVE"},
+	}}
+
+	pools := getPoolHealth(groups, hosts, status)
+	if len(pools) != 2 {
+		t.Fatalf("expected 2 pools, got %v", len(pools))
+	}
+
+	if pools[0].Registered != 2 || pools[0].Alive != 1 || pools[0].Healthy {
+		t.Errorf("unexpected ondemand pool health: %+v", pools[0])
+	}
+
+	if pools[1].Registered != 1 || pools[1].Alive != 1 || !pools[1].Healthy {
+		t.Errorf("unexpected spot pool health: %+v", pools[1])
+	}
+
+	single := getPoolHealth(groups[:2], nil, status)
+	if single[0].Alive != 2 || !single[0].Healthy {
+		t.Errorf("expected alive workers to be attributed to the only pool: %+v", single[0])
+	}
+}
+
+func TestResizeWorkerPool(t *testing.T) {
+	var client cloud.AwsEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/aws.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+	client.ClusterID = "resize-pool-test"
+
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	team := "resize-budget-team"
+	err = registerCluster(ClusterRequest{ClusterID: client.ClusterID,
+		CloudEnvironment: cloud.Aws, Client: serializedClient, Team: team}, StatusPending)
+	if err != nil {
+		t.Error(err)
+	}
+	defer DeregisterCluster(client.ClusterID)
+
+	_, err = ResizeWorkerPool(client.ClusterID, cloud.DefaultWorkerPool, 5)
+	if err == nil {
+		t.Error("expected pending clusters not to be resized")
+	}
+
+	status, err := getLastEpoch(client.ClusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status.Status = StatusRunning
+	setStatus(client.ClusterID, status, true)
+
+	_, err = ResizeWorkerPool(client.ClusterID, "does-not-exist", 5)
+	if err == nil {
+		t.Error("expected unknown pools to be rejected")
+	}
+
+	day := costLedgerPrefix + time.Now().UTC().Format(costDayFormat)
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+	redisClient.HSet(day, team+costFieldSeparator+"spent", 10)
+	defer redisClient.HDel(day, team+costFieldSeparator+"spent")
+
+	err = SetBudget(Budget{Scope: BudgetScopeTeam, Target: team,
+		Period: BudgetPeriodDaily, HardLimit: 5})
+	if err != nil {
+		t.Error(err)
+	}
+	defer DeleteBudget(BudgetScopeTeam, team)
+
+	_, err = ResizeWorkerPool(client.ClusterID, cloud.DefaultWorkerPool, client.WorkerNodes+1)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected growth over budget to be rejected: %v", err)
+	}
+
+	var docker cloud.DockerEnvironment
+	err = serializer.DeserializePath("../dist/sample_templates/docker.json", &docker)
+	if err != nil {
+		t.Error(err)
+	}
+	docker.ClusterID = "resize-pool-docker"
+
+	serializedClient, err = serializer.Serialize(docker)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = registerCluster(ClusterRequest{ClusterID: docker.ClusterID,
+		CloudEnvironment: cloud.Docker, Client: serializedClient}, StatusRunning)
+	if err != nil {
+		t.Error(err)
+	}
+	defer DeregisterCluster(docker.ClusterID)
+
+	_, err = ResizeWorkerPool(docker.ClusterID, cloud.DefaultWorkerPool, 1)
+	if err == nil {
+		t.Error("expected environments without pool resizing to be rejected")
+	}
+}
+
+func TestPromoteQueuedClusterOverBudget(t *testing.T) {
+	var client cloud.DockerEnvironment
+	err := serializer.DeserializePath("../dist/sample_templates/docker.json", &client)
+	if err != nil {
+		t.Error(err)
+	}
+	client.ClusterID = "queued-over-budget"
+
+	serializedClient, err := serializer.Serialize(client)
+	if err != nil {
+		t.Error(err)
+	}
+
+	team := "queued-budget-team"
+	day := costLedgerPrefix + time.Now().UTC().Format(costDayFormat)
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+	redisClient.HSet(day, team+costFieldSeparator+"spent", 10)
+	defer redisClient.HDel(day, team+costFieldSeparator+"spent")
+
+	err = SetBudget(Budget{Scope: BudgetScopeTeam, Target: team,
+		Period: BudgetPeriodDaily, HardLimit: 5})
+	if err != nil {
+		t.Error(err)
+	}
+	defer DeleteBudget(BudgetScopeTeam, team)
+
+	err = registerCluster(ClusterRequest{ClusterID: client.ClusterID,
+		CloudEnvironment: cloud.Docker, Client: serializedClient, Team: team}, StatusQueued)
+	if err != nil {
+		t.Error(err)
+	}
+	defer DeregisterCluster(client.ClusterID)
+
+	if promoteQueuedCluster(client.ClusterID) {
+		t.Error("expected cluster over budget to remain unlaunched")
+	}
+
+	status, err := getLastEpoch(client.ClusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Status != StatusCanceled || status.FailureReason != FailureBudgetExceeded {
+		t.Errorf("expected cluster to be canceled by its budget: %+v", status)
+	}
+}
@@ -0,0 +1,219 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"errors"
+	"time"
+)
+
+const (
+	workerPoolPrefix  = "cluster.workers."
+	poolResizePrefix  = "cluster.resizing."
+	sparkWorkerAlive  = "ALIVE"
+	poolResizeTimeout = time.Hour
+)
+
+// PoolHealth - health of a worker pool of a cluster; Registered counts
+// the nodes of the pool which have checked in and Alive the spark workers
+// of the pool registered with the master. A pool is healthy once all of
+// its nodes are alive.
+type PoolHealth struct {
+	Name         string
+	InstanceType string
+	Nodes        int64
+	Registered   int64
+	Alive        int64
+	Healthy      bool
+}
+
+// RegisterWorker - records the worker pool of a worker node of the cluster
+func RegisterWorker(clusterID string, registration cloud.WorkerRegistration) {
+	status, err := getLastEpoch(clusterID)
+	if err != nil || status.Status == StatusNotRegistered {
+		logger.GetInfo().Printf("cluster: %v worker %v checked-in, but the cluster is not registered",
+			clusterID, registration.Host)
+		return
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	logger.GetInfo().Printf("cluster: %v registered worker %v of pool %v",
+		clusterID, registration.Host, registration.Pool)
+	client.HSet(workerPoolPrefix+clusterID, registration.Host, registration.Pool)
+}
+
+func deleteWorkerRegistrations(clusterID string) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	client.Del(workerPoolPrefix + clusterID)
+}
+
+// getPoolHealth - returns the health of each worker pool of the node
+// groups; hosts maps the registered worker nodes to their pools. The alive
+// workers of clusters with a single pool are attributed to that pool
+// whether or not its nodes have registered.
+func getPoolHealth(groups []cloud.NodeGroup, hosts map[string]string,
+	sparkStatus *cloud.SparkClusterStatus) []PoolHealth {
+
+	var pools []PoolHealth
+	for _, el := range groups {
+		if len(el.Pool) > 0 {
+			pools = append(pools, PoolHealth{
+				Name:         el.Pool,
+				InstanceType: el.InstanceType,
+				Nodes:        el.Nodes,
+			})
+		}
+	}
+
+	for idx := range pools {
+		for _, pool := range hosts {
+			if pool == pools[idx].Name {
+				pools[idx].Registered++
+			}
+		}
+
+		if sparkStatus == nil {
+			continue
+		}
+
+		for _, worker := range sparkStatus.Workers {
+			if worker.State != sparkWorkerAlive {
+				continue
+			}
+			if len(pools) == 1 || hosts[worker.Host] == pools[idx].Name {
+				pools[idx].Alive++
+			}
+		}
+		pools[idx].Healthy = pools[idx].Alive >= pools[idx].Nodes
+	}
+
+	return pools
+}
+
+// getClusterPoolHealth - returns the health of the worker pools of the cluster
+func getClusterPoolHealth(clusterID string, status SparkClusterStatusAtEpoch) []PoolHealth {
+	client, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err != nil {
+		return nil
+	}
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	return getPoolHealth(client.GetResources().Groups,
+		redisClient.HGetAll(workerPoolPrefix+clusterID).Val(), status.SparkStatus)
+}
+
+// resizedResources - returns the resources the cluster gains by resizing
+// the worker pool; the resources are negative if the pool shrinks
+func resizedResources(status SparkClusterStatusAtEpoch, client cloud.CloudEnvironment,
+	pool string, nodes int64) (cloud.ClusterResources, error) {
+
+	resized, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err != nil {
+		return cloud.ClusterResources{}, err
+	}
+
+	err = resized.(cloud.PoolResizer).SetPoolNodes(pool, nodes)
+	if err != nil {
+		return cloud.ClusterResources{}, err
+	}
+
+	before, after := client.GetResources(), resized.GetResources()
+	return cloud.ClusterResources{
+		Nodes:      after.Nodes - before.Nodes,
+		VCPU:       after.VCPU - before.VCPU,
+		MemoryGB:   after.MemoryGB - before.MemoryGB,
+		ShapeKnown: after.ShapeKnown,
+		Groups:     after.Groups,
+	}, nil
+}
+
+// resizePool - resizes the worker pool and records the resulting template;
+// removed workers are dropped from the pool registrations
+func resizePool(clusterID string, client cloud.CloudEnvironment, pool string, nodes int64) {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+	defer redisClient.Del(poolResizePrefix + clusterID)
+
+	removed, err := client.(cloud.PoolResizer).ResizePool(pool, nodes)
+	recordClient(clusterID, client)
+	if len(removed) > 0 {
+		redisClient.HDel(workerPoolPrefix+clusterID, removed...)
+	}
+
+	if err != nil {
+		logger.GetError().Printf("failed to resize pool %v of cluster %v: %v", pool, clusterID, err)
+		return
+	}
+	logger.GetInfo().Printf("resized pool %v of cluster %v to %v workers", pool, clusterID, nodes)
+}
+
+// ResizeWorkerPool - starts resizing a worker pool of an idle or running
+// cluster to the specified number of nodes. Growth is subject to the
+// budgets, team quota and capacity limits of the cluster; the resize
+// itself completes in the background
+func ResizeWorkerPool(clusterID string, pool string, nodes int64) (ClusterDetail, error) {
+	if nodes < 0 {
+		return ClusterDetail{}, errors.New("node count must not be negative")
+	}
+
+	err := acquireClusterLock(clusterID, "resize", 5)
+	if err != nil {
+		return ClusterDetail{}, err
+	}
+	defer releaseClusterLock(clusterID)
+
+	status, err := getLastEpoch(clusterID)
+	if err != nil {
+		return ClusterDetail{}, errors.New("cluster " + clusterID + " is not registered")
+	}
+
+	if status.Status != StatusIdle && status.Status != StatusRunning {
+		return ClusterDetail{}, errors.New("cluster " + clusterID + " is " + status.Status +
+			"; only idle or running clusters can be resized")
+	}
+
+	client, err := cloud.Create(status.CloudEnvironment, status.Client)
+	if err != nil {
+		return ClusterDetail{}, err
+	}
+
+	if _, ok := client.(cloud.PoolResizer); !ok {
+		return ClusterDetail{}, errors.New("worker pools of " + status.CloudEnvironment +
+			" clusters cannot be resized")
+	}
+
+	added, err := resizedResources(status, client, pool, nodes)
+	if err != nil {
+		return ClusterDetail{}, err
+	}
+
+	if added.Nodes > 0 {
+		err = checkGrowth(ClusterRequest{
+			ClusterID:        clusterID,
+			CloudEnvironment: status.CloudEnvironment,
+			Caller:           status.Caller,
+			Team:             status.Team,
+		}, added)
+		if err != nil {
+			return ClusterDetail{}, err
+		}
+	}
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+	if !redisClient.SetNX(poolResizePrefix+clusterID, pool, poolResizeTimeout).Val() {
+		return ClusterDetail{}, errors.New("cluster " + clusterID + " is already being resized")
+	}
+
+	logger.GetInfo().Printf("resizing pool %v of cluster %v to %v workers", pool, clusterID, nodes)
+	go resizePool(clusterID, client, pool, nodes)
+
+	return GetClusterDetail(clusterID)
+}
@@ -0,0 +1,213 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"strconv"
+	"strings"
+)
+
+// Cluster failure reasons
+const (
+	FailureMissedCheckIn  = cloud.FailureMissedCheckIn
+	FailurePendingTimeout = cloud.FailurePendingTimeout
+	FailureMaxRuntime     = cloud.FailureMaxRuntime
+	FailureAppFailure     = cloud.FailureAppFailure
+	FailureLaunch         = cloud.FailureLaunch
+	FailureBudgetExceeded = cloud.FailureBudgetExceeded
+	FailureInterrupted    = cloud.FailureInterrupted
+	retryQueue            = "RETRY_QUEUE"
+	attemptIdentifier     = "-attempt-"
+)
+
+// pendingRetry describes a failed cluster awaiting relaunch
+type pendingRetry struct {
+	Request   ClusterRequest
+	NotBefore int64
+}
+
+func getAttempt(status SparkClusterStatusAtEpoch) int {
+	if status.Attempt < 1 {
+		return 1
+	}
+	return status.Attempt
+}
+
+func getRootClusterID(clusterID string, status SparkClusterStatusAtEpoch) string {
+	if len(status.RootClusterID) > 0 {
+		return status.RootClusterID
+	}
+	return clusterID
+}
+
+func isRetryableReason(policy *cloud.RetryPolicy, failureReason string) bool {
+	if len(policy.RetryableReasons) == 0 {
+		return true
+	}
+
+	for _, el := range policy.RetryableReasons {
+		if el == failureReason {
+			return true
+		}
+	}
+	return false
+}
+
+// getRetryPolicy - returns the retry policy of the cluster if the
+// failure is eligible for another attempt; nil otherwise
+func getRetryPolicy(status SparkClusterStatusAtEpoch) *cloud.RetryPolicy {
+	// clusters canceled by a budget would be rejected on relaunch
+	if len(status.FailureReason) == 0 || status.FailureReason == FailureBudgetExceeded {
+		return nil
+	}
+
+	policy, err := cloud.GetRetryPolicy(status.Client)
+	if err != nil || policy == nil {
+		return nil
+	}
+
+	if getAttempt(status) >= policy.MaxAttempts ||
+		!isRetryableReason(policy, status.FailureReason) {
+		return nil
+	}
+
+	return policy
+}
+
+// retryBackoff - returns the seconds to wait before the attempt; the
+// backoff doubles with every attempt up to MaxRetryBackoffSeconds
+func retryBackoff(policy *cloud.RetryPolicy, attempt int) int64 {
+	backoff := policy.BackoffSeconds
+	if backoff <= 0 {
+		return 0
+	}
+
+	for i := 2; i < attempt && backoff < cloud.MaxRetryBackoffSeconds; i++ {
+		backoff *= 2
+	}
+	if backoff > cloud.MaxRetryBackoffSeconds {
+		return cloud.MaxRetryBackoffSeconds
+	}
+	return backoff
+}
+
+// scheduleRetry - queues a new attempt of a failed cluster once it has
+// been torn down, if permitted by the cluster's retry policy
+func scheduleRetry(clusterID string, status SparkClusterStatusAtEpoch) {
+	policy := getRetryPolicy(status)
+	if policy == nil {
+		return
+	}
+
+	attempt := getAttempt(status) + 1
+	rootClusterID := getRootClusterID(clusterID, status)
+	retryClusterID := rootClusterID + attemptIdentifier + strconv.Itoa(attempt)
+
+	serializedClient, err := cloud.SetClusterID(status.Client, retryClusterID)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	backoff := retryBackoff(policy, attempt)
+	retry := pendingRetry{
+		Request: ClusterRequest{
+			ClusterID:        retryClusterID,
+			CloudEnvironment: status.CloudEnvironment,
+			Client:           serializedClient,
+			RunID:            status.RunID,
+			Attempt:          attempt,
+			RootClusterID:    rootClusterID,
+			Caller:           status.Caller,
+			Team:             status.Team,
+			Owner:            status.Owner,
+			Template:         status.Template,
+		},
+		NotBefore: getTimestamp() + backoff,
+	}
+
+	buffer, err := serializer.Serialize(retry)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	logger.GetInfo().Printf("cluster %v failed with reason %v; scheduling attempt %v as %v in %v seconds",
+		clusterID, status.FailureReason, attempt, retryClusterID, backoff)
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+	client.HSet(retryQueue, retryClusterID, string(buffer))
+
+	if len(status.RunID) > 0 {
+		retryRun(status.RunID, clusterID, retryClusterID)
+	}
+}
+
+// processRetries - launches queued attempts whose backoff has elapsed
+func processRetries() {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	for clusterID, buffer := range client.HGetAll(retryQueue).Val() {
+		var retry pendingRetry
+		err := serializer.Deserialize([]byte(buffer), &retry)
+		if err != nil {
+			logger.GetError().Println(err)
+			client.HDel(retryQueue, clusterID)
+			continue
+		}
+
+		if getTimestamp() < retry.NotBefore {
+			continue
+		}
+
+		if client.HDel(retryQueue, clusterID).Val() == 0 {
+			continue
+		}
+
+		logger.GetInfo().Printf("launching attempt %v of cluster %v as %v",
+			retry.Request.Attempt, retry.Request.RootClusterID, clusterID)
+
+		go func(request ClusterRequest) {
+			_, err := LaunchCluster(request)
+			if err != nil {
+				logger.GetError().Println(err)
+				failRetryLaunch(request, err)
+			}
+		}(retry.Request)
+	}
+}
+
+// failRetryLaunch - fails the run of an attempt which could not be
+// launched, so that the run does not await the attempt forever
+func failRetryLaunch(request ClusterRequest, err error) {
+	if len(request.RunID) == 0 {
+		return
+	}
+
+	run, runErr := GetRun(request.RunID)
+	if runErr != nil || run.ClusterID != request.ClusterID || IsRunComplete(run) {
+		return
+	}
+	failRunLaunch(run, err)
+}
+
+// IsClusterActive - returns true if the cluster, or a retry of it,
+// is registered or awaiting relaunch
+func IsClusterActive(clusterID string) bool {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	attemptPrefix := clusterID + attemptIdentifier
+	for _, key := range []string{statusMap, retryQueue} {
+		for _, el := range client.HKeys(key).Val() {
+			if el == clusterID || strings.HasPrefix(el, attemptPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// Spark run status constants
+const (
+	RunStatusPending   = "PENDING"
+	RunStatusSubmitted = "SUBMITTED"
+	RunStatusRetrying  = "RETRYING"
+	RunStatusSucceeded = "SUCCEEDED"
+	RunStatusFailed    = "FAILED"
+	runMap             = "RUN_MAP"
+)
+
+// SparkRun describes an ephemeral cluster created to run a single job;
+// the run record is retained after the cluster is deregistered
+type SparkRun struct {
+	ID               string
+	ClusterID        string
+	Attempts         []string `json:",omitempty"`
+	CloudEnvironment string
+	Team             string `json:",omitempty"`
+	Owner            string `json:",omitempty"`
+	Job              cloud.SparkJobSpec
+	JobID            string
+	Status           string
+	DriverState      string
+	AppState         string
+	ClusterStatus    string
+	Error            string
+	CreatedAt        int64
+	CompletedAt      int64
+}
+
+// RunRequest - form body for the /runs endpoint
+type RunRequest struct {
+	CloudEnvironment string
+	Template         json.RawMessage
+	Job              cloud.SparkJobSpec
+}
+
+// NewRunID - returns a unique identifier for a run
+func NewRunID() string {
+	buffer := make([]byte, 4)
+	rand.Read(buffer)
+	return "run-" + strconv.FormatInt(getTimestamp(), 36) + "-" + hex.EncodeToString(buffer)
+}
+
+// IsRunComplete - returns true if the run has reached a terminal status
+func IsRunComplete(run SparkRun) bool {
+	return run.Status == RunStatusSucceeded || run.Status == RunStatusFailed
+}
+
+func saveRun(run SparkRun) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(run)
+	if err != nil {
+		return err
+	}
+
+	return client.HSet(runMap, run.ID, string(buffer)).Err()
+}
+
+// GetRun - returns the run record
+func GetRun(runID string) (SparkRun, error) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	var run SparkRun
+	buffer, err := client.HGet(runMap, runID).Result()
+	if err != nil {
+		return run, errors.New("run " + runID + " not found")
+	}
+
+	err = serializer.Deserialize([]byte(buffer), &run)
+	return run, err
+}
+
+// GetRuns - returns all run records, ordered by creation time
+func GetRuns() []SparkRun {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	runs := make([]SparkRun, 0)
+	for _, buffer := range client.HGetAll(runMap).Val() {
+		var run SparkRun
+		if serializer.Deserialize([]byte(buffer), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt < runs[j].CreatedAt
+	})
+
+	return runs
+}
+
+// StartRun - records the run and launches its cluster; the job is
+// submitted by the monitor once the cluster is ready
+func StartRun(request ClusterRequest, job cloud.SparkJobSpec) (SparkRun, error) {
+	clusterID, err := cloud.GetClusterID(request.Client)
+	if err != nil {
+		return SparkRun{}, err
+	}
+	request.ClusterID = clusterID
+
+	run := SparkRun{
+		ID:               request.RunID,
+		ClusterID:        clusterID,
+		CloudEnvironment: request.CloudEnvironment,
+		Team:             request.Team,
+		Owner:            request.Owner,
+		Job:              job,
+		Status:           RunStatusPending,
+		CreatedAt:        getTimestamp(),
+	}
+
+	err = saveRun(run)
+	if err != nil {
+		return run, err
+	}
+
+	go func() {
+		_, err := LaunchCluster(request)
+		if err != nil {
+			logger.GetError().Println(err)
+			failRunLaunch(run, err)
+		}
+	}()
+
+	return run, nil
+}
+
+// failRunLaunch - records the failed launch of a run's cluster; the run
+// fails outright if the cluster was never registered
+func failRunLaunch(run SparkRun, err error) {
+	status, statusErr := getLastEpoch(run.ClusterID)
+	if statusErr != nil {
+		run.Error = err.Error()
+		completeRun(run, RunStatusFailed, StatusNotRegistered)
+		return
+	}
+	failRun(run, status, err.Error())
+}
+
+func completeRun(run SparkRun, status string, clusterStatus string) SparkRun {
+	logger.GetInfo().Printf("run %v on cluster %v completed with status %v",
+		run.ID, run.ClusterID, status)
+
+	run.Status = status
+	run.ClusterStatus = clusterStatus
+	run.CompletedAt = getTimestamp()
+
+	err := saveRun(run)
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+
+	return run
+}
+
+// failRun - records the failure of a run's cluster; the run is
+// retried if permitted by the cluster's retry policy
+func failRun(run SparkRun, status SparkClusterStatusAtEpoch, message string) SparkRun {
+	run.Error = message
+	if getRetryPolicy(status) != nil {
+		logger.GetInfo().Printf("run %v on cluster %v failed and will be retried: %v",
+			run.ID, run.ClusterID, message)
+		run.Status = RunStatusRetrying
+		run.ClusterStatus = status.Status
+		err := saveRun(run)
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+		return run
+	}
+
+	return completeRun(run, RunStatusFailed, status.Status)
+}
+
+// retryRun - links a new cluster attempt to the run
+func retryRun(runID string, clusterID string, retryClusterID string) {
+	run, err := GetRun(runID)
+	if err != nil || run.ClusterID != clusterID {
+		return
+	}
+
+	if len(run.Attempts) == 0 {
+		run.Attempts = []string{clusterID}
+	}
+	run.Attempts = append(run.Attempts, retryClusterID)
+	run.ClusterID = retryClusterID
+	run.Status = RunStatusPending
+	run.JobID = ""
+	run.DriverState = ""
+	run.AppState = ""
+	run.CompletedAt = 0
+
+	err = saveRun(run)
+	if err != nil {
+		logger.GetError().Println(err)
+	}
+}
+
+func submitRunJob(run SparkRun, status SparkClusterStatusAtEpoch) (SparkRun, error) {
+	if status.SparkStatus == nil {
+		return run, errors.New("cluster " + run.ClusterID + " has not checked-in")
+	}
+
+	master, err := cloud.GetSparkMaster(*status.SparkStatus)
+	if err != nil {
+		return run, err
+	}
+
+	submissionID, err := cloud.SubmitSparkJob(master, run.Job)
+	if err != nil {
+		return run, err
+	}
+
+	logger.GetInfo().Printf("submitted job %v for run %v to cluster %v",
+		submissionID, run.ID, run.ClusterID)
+
+	err = saveJob(SparkJob{
+		ID:          submissionID,
+		ClusterID:   run.ClusterID,
+		Spec:        run.Job,
+		State:       cloud.DriverStateSubmitted,
+		SubmittedAt: getTimestamp(),
+		UpdatedAt:   getTimestamp(),
+	})
+	if err != nil {
+		return run, err
+	}
+
+	run.JobID = submissionID
+	run.Status = RunStatusSubmitted
+	return run, saveRun(run)
+}
+
+func getLastAppState(status SparkClusterStatusAtEpoch) string {
+	if status.SparkStatus == nil {
+		return ""
+	}
+
+	latest, ok := latestCompletedApp(*status.SparkStatus)
+	if !ok {
+		return ""
+	}
+	return latest.State
+}
+
+// advanceRun - submits the job of a run once its cluster is ready and
+// records the outcome once the job completes; returns true if the
+// cluster should be terminated
+func advanceRun(clusterID string, status *SparkClusterStatusAtEpoch) bool {
+	run, err := GetRun(status.RunID)
+	if err != nil {
+		logger.GetError().Println(err)
+		return false
+	}
+
+	if IsRunComplete(run) || run.Status == RunStatusRetrying ||
+		run.ClusterID != clusterID {
+		return false
+	}
+
+	if run.Status == RunStatusPending && status.Status == StatusIdle {
+		run, err = submitRunJob(run, *status)
+		if err != nil {
+			logger.GetError().Printf("unable to submit job for run %v: %v", run.ID, err)
+			status.FailureReason = FailureAppFailure
+			failRun(run, *status, err.Error())
+			return true
+		}
+		return false
+	}
+
+	if run.Status == RunStatusSubmitted {
+		job, err := GetJob(clusterID, run.JobID)
+		if err == nil && cloud.IsDriverStateTerminal(job.State) {
+			run.DriverState = job.State
+			run.AppState = getLastAppState(*status)
+			if job.State != cloud.DriverStateFinished {
+				if len(status.FailureReason) == 0 {
+					status.FailureReason = FailureAppFailure
+				}
+				failRun(run, *status, "job completed with driver state "+job.State)
+			} else if status.Status == StatusError {
+				failRun(run, *status, "cluster "+clusterID+" reported status "+StatusError)
+			} else {
+				completeRun(run, RunStatusSucceeded, status.Status)
+			}
+			return status.Status != StatusTerminating
+		}
+	}
+
+	switch status.Status {
+	case StatusError, StatusCanceled, StatusTerminating:
+		run.AppState = getLastAppState(*status)
+		message := run.Error
+		if len(message) == 0 {
+			message = "cluster " + clusterID + " ended with status " + status.Status
+		}
+		failRun(run, *status, message)
+		return status.Status == StatusError
+	}
+
+	return false
+}
@@ -0,0 +1,228 @@
+package monitor
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/util/serializer"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	teamQuotaMap      = "TEAM_QUOTA_MAP"
+	usageAccrualMap   = "USAGE_ACCRUAL"
+	nodeSecondsPrefix = "usage.node_seconds."
+	usageMonthFormat  = "2006-01"
+)
+
+// ErrQuotaExceeded - returned when a cluster is rejected by its team quota
+var ErrQuotaExceeded = errors.New("team quota exceeded")
+
+// TeamQuota - limits applied to the clusters owned by a team;
+// zero values and an empty instance type list are unlimited
+type TeamQuota struct {
+	Team                 string
+	MaxClusters          int
+	MaxNodeHoursPerMonth float64
+	AllowedInstanceTypes []string
+}
+
+// TeamUsage - resources consumed by the clusters of a team
+type TeamUsage struct {
+	Team      string
+	Month     string
+	Clusters  int
+	NodeHours float64
+	Quota     *TeamQuota `json:",omitempty"`
+}
+
+func getUsageMonth(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format(usageMonthFormat)
+}
+
+// SetTeamQuota - creates or replaces the quota of a team
+func SetTeamQuota(quota TeamQuota) error {
+	if len(quota.Team) == 0 {
+		return errors.New("team not specified")
+	}
+
+	if quota.MaxClusters < 0 || quota.MaxNodeHoursPerMonth < 0 {
+		return errors.New("quota limits must not be negative")
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(quota)
+	if err != nil {
+		return err
+	}
+
+	logger.GetInfo().Printf("setting quota for team %v: %+v", quota.Team, quota)
+	return client.HSet(teamQuotaMap, quota.Team, string(buffer)).Err()
+}
+
+// GetTeamQuota - returns the quota of a team, or nil if none is set
+func GetTeamQuota(team string) *TeamQuota {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := client.HGet(teamQuotaMap, team).Result()
+	if err != nil {
+		return nil
+	}
+
+	var quota TeamQuota
+	if serializer.Deserialize([]byte(buffer), &quota) != nil {
+		return nil
+	}
+
+	return &quota
+}
+
+// DeleteTeamQuota - removes the quota of a team
+func DeleteTeamQuota(team string) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	if client.HDel(teamQuotaMap, team).Val() == 0 {
+		return errors.New("quota for team " + team + " not found")
+	}
+	return nil
+}
+
+// GetTeamUsage - returns the current usage of a team for the month
+// containing the specified timestamp
+func GetTeamUsage(team string, timestamp int64) TeamUsage {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	month := getUsageMonth(timestamp)
+	nodeSeconds, _ := client.HGet(nodeSecondsPrefix+month, team).Float64()
+
+	usage := TeamUsage{
+		Team:      team,
+		Month:     month,
+		NodeHours: nodeSeconds / 3600,
+		Quota:     GetTeamQuota(team),
+	}
+
+	for _, buffer := range client.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) == nil &&
+			status.Team == team && status.Status != StatusQueued {
+			usage.Clusters++
+		}
+	}
+
+	return usage
+}
+
+// GetTeams - returns the teams with a quota or registered clusters
+func GetTeams() []string {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	teams := make(map[string]bool)
+	for _, team := range client.HKeys(teamQuotaMap).Val() {
+		teams[team] = true
+	}
+
+	for _, buffer := range client.HGetAll(statusMap).Val() {
+		var status SparkClusterStatusAtEpoch
+		if serializer.Deserialize([]byte(buffer), &status) == nil &&
+			len(status.Team) > 0 {
+			teams[status.Team] = true
+		}
+	}
+
+	result := make([]string, 0, len(teams))
+	for team := range teams {
+		result = append(result, team)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// checkTeamQuota - verifies the instance types and monthly node-hours
+// of the team permit the cluster; concurrent cluster limits are
+// evaluated by admission control
+func checkTeamQuota(request ClusterRequest, quota *TeamQuota,
+	resources cloud.ClusterResources) error {
+
+	if quota == nil {
+		return nil
+	}
+
+	if len(quota.AllowedInstanceTypes) > 0 {
+		for _, group := range resources.Groups {
+			allowed := false
+			for _, el := range quota.AllowedInstanceTypes {
+				if el == group.InstanceType {
+					allowed = true
+					break
+				}
+			}
+
+			if !allowed {
+				return fmt.Errorf("%w: instance type %v is not allowed for team %v",
+					ErrQuotaExceeded, group.InstanceType, request.Team)
+			}
+		}
+	}
+
+	if quota.MaxNodeHoursPerMonth > 0 {
+		usage := GetTeamUsage(request.Team, getTimestamp())
+		if usage.NodeHours >= quota.MaxNodeHoursPerMonth {
+			return fmt.Errorf("%w: team %v has used %.1f of %v node-hours in %v",
+				ErrQuotaExceeded, request.Team, usage.NodeHours,
+				quota.MaxNodeHoursPerMonth, usage.Month)
+		}
+	}
+
+	return nil
+}
+
+// accrueUsage - records the node-seconds and cost consumed by the
+// cluster since the previous accrual, or since registration on the
+// first accrual, against its team
+func accrueUsage(clusterID string, status SparkClusterStatusAtEpoch,
+	client cloud.CloudEnvironment) {
+
+	if status.Status == StatusQueued {
+		return
+	}
+
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	now := getTimestamp()
+	last, err := redisClient.HGet(usageAccrualMap, clusterID).Int64()
+	if err != nil {
+		last = status.RegisteredAt
+	}
+	redisClient.HSet(usageAccrualMap, clusterID, strconv.FormatInt(now, 10))
+	if last == 0 || now <= last {
+		return
+	}
+
+	resources := client.GetResources()
+	if len(status.Team) > 0 {
+		nodeSeconds := float64(resources.Nodes * (now - last))
+		redisClient.HIncrByFloat(nodeSecondsPrefix+getUsageMonth(now), status.Team, nodeSeconds)
+	}
+
+	accrueCost(redisClient, clusterID, status, resources, last, now)
+}
+
+func deleteUsageAccrual(clusterID string) {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	redisClient.HDel(usageAccrualMap, clusterID)
+}
@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maximum number of years searched for the next activation of a
+// cron expression; guards against expressions which never match
+// (e.g. 0 0 30 2 *)
+const cronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+type cronField struct {
+	values     map[int]bool
+	restricted bool
+}
+
+// cronSchedule - a parsed five field cron expression
+// (minute, hour, day of month, month, day of week)
+type cronSchedule struct {
+	minute     cronField
+	hour       cronField
+	dayOfMonth cronField
+	month      cronField
+	dayOfWeek  cronField
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if result, ok := names[strings.ToLower(value)]; ok {
+		return result, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func parseCronField(field string, min int, max int,
+	names map[string]int) (cronField, error) {
+
+	result := cronField{values: make(map[int]bool)}
+	for _, el := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(el, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(el[idx+1:])
+			if err != nil || step <= 0 {
+				return result, errors.New("invalid step in cron field " + field)
+			}
+			el = el[:idx]
+		}
+
+		start, end := min, max
+		switch {
+		case el == "*":
+		case strings.Contains(el, "-"):
+			bounds := strings.SplitN(el, "-", 2)
+			var err error
+			start, err = parseCronValue(bounds[0], names)
+			if err != nil {
+				return result, errors.New("invalid range in cron field " + field)
+			}
+			end, err = parseCronValue(bounds[1], names)
+			if err != nil {
+				return result, errors.New("invalid range in cron field " + field)
+			}
+			result.restricted = true
+		default:
+			var err error
+			start, err = parseCronValue(el, names)
+			if err != nil {
+				return result, errors.New("invalid value in cron field " + field)
+			}
+			if step > 1 {
+				end = max
+			} else {
+				end = start
+			}
+			result.restricted = true
+		}
+
+		if start < min || end > max || start > end {
+			return result, errors.New("cron field " + field + " out of range [" +
+				strconv.Itoa(min) + "-" + strconv.Itoa(max) + "]")
+		}
+
+		for i := start; i <= end; i += step {
+			result.values[i] = true
+		}
+	}
+
+	return result, nil
+}
+
+// parseCronExpression - parses a standard five field cron expression;
+// lists, ranges, steps, month and day names and the @hourly, @daily,
+// @weekly, @monthly and @yearly macros are supported
+func parseCronExpression(expression string) (*cronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("invalid cron expression \"" + expression +
+			"\"; expected 5 fields (minute hour day-of-month month day-of-week)")
+	}
+
+	var schedule cronSchedule
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+
+	// both 0 and 7 denote sunday
+	if schedule.dayOfWeek.values[7] {
+		schedule.dayOfWeek.values[0] = true
+	}
+
+	return &schedule, nil
+}
+
+// matchesDay - follows cron semantics; if both day of month and day of
+// week are restricted, either may match
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := c.dayOfMonth.values[t.Day()]
+	dowMatch := c.dayOfWeek.values[int(t.Weekday())]
+	if c.dayOfMonth.restricted && c.dayOfWeek.restricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// advance - returns the candidate time unless a daylight saving
+// transition normalized it to a time at or before the current one,
+// in which case the start of the following hour is returned
+func advance(current time.Time, candidate time.Time) time.Time {
+	if candidate.After(current) {
+		return candidate
+	}
+	return current.Add(time.Hour - time.Duration(current.Minute())*time.Minute)
+}
+
+// next - returns the first activation strictly after the specified
+// time, evaluated in the time's location; returns the zero time if
+// the expression does not match within the search window
+func (c *cronSchedule) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month.values[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !c.hour.values[t.Hour()] {
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if !c.minute.values[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronExpression(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for _, el := range invalid {
+		_, err := parseCronExpression(el)
+		if err == nil {
+			t.Error("expected error parsing cron expression \"" + el + "\"")
+		}
+	}
+
+	valid := []string{
+		"* * * * *",
+		"*/15 2,14 1-15 jan-jun mon-fri",
+		"0 0 * * 7",
+		"@daily",
+		"@hourly",
+	}
+
+	for _, el := range valid {
+		_, err := parseCronExpression(el)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		after      time.Time
+		expected   time.Time
+	}{
+		{"30 2 * * *",
+			time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * *",
+			time.Date(2026, 10, 19, 2, 30, 0, 0, time.UTC),
+			time.Date(2026, 10, 20, 2, 30, 0, 0, time.UTC)},
+		{"*/20 * * * *",
+			time.Date(2026, 10, 19, 1, 41, 10, 0, time.UTC),
+			time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *",
+			time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri",
+			time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *",
+			time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day of month or day of week matches when both are restricted
+		{"0 0 13 * 5",
+			time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 2 * * *",
+			time.Date(2026, 10, 19, 0, 0, 0, 0, newYork),
+			time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)},
+		// 2:30 does not exist on the spring daylight saving transition
+		{"30 2 * * *",
+			time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			time.Date(2026, 3, 9, 2, 30, 0, 0, newYork)},
+	}
+
+	for _, el := range tests {
+		schedule, err := parseCronExpression(el.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := schedule.next(el.after)
+		if !actual.Equal(el.expected) {
+			t.Error("next activation mismatch for " + el.expression)
+			t.Error("-expected: " + el.expected.String())
+			t.Error("-actual: " + actual.String())
+		}
+	}
+
+	schedule, err := parseCronExpression("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !schedule.next(time.Now()).IsZero() {
+		t.Error("expected expression which never matches to return the zero time")
+	}
+}
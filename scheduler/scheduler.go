@@ -0,0 +1,433 @@
+package scheduler
+
+import (
+	"allspark/cloud"
+	"allspark/datastore"
+	"allspark/logger"
+	"allspark/monitor"
+	"allspark/util/serializer"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Concurrency policies applied when a schedule becomes due while the
+// cluster of its previous launch is still active
+const (
+	ConcurrencySkip  = "skip"
+	ConcurrencyQueue = "queue"
+)
+
+// Schedule history outcomes
+const (
+	LaunchLaunched = "LAUNCHED"
+	LaunchSkipped  = "SKIPPED"
+	LaunchQueued   = "QUEUED"
+	LaunchFailed   = "FAILED"
+)
+
+const (
+	scheduleMap          = "SCHEDULE_MAP"
+	historyPrefix        = "schedule.history."
+	schedulerLock        = "SCHEDULER_LOCK"
+	maxHistory           = 100
+	schedulerInterval    = 15 * time.Second
+	clusterIDTimeFormat  = "20060102t150405"
+	defaultTimezone      = "UTC"
+	maxScheduleNameChars = 40
+	scheduleCallerPrefix = "schedule:"
+)
+
+var scheduleNamePattern = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+
+// Schedule - a recurring cluster launch
+type Schedule struct {
+	Name              string
+	Cron              string
+	Timezone          string
+	CloudEnvironment  string
+	Team              string `json:",omitempty"`
+	Owner             string `json:",omitempty"`
+	Template          json.RawMessage
+	ConcurrencyPolicy string
+	NextLaunch        int64
+	LastClusterID     string `json:",omitempty"`
+	QueuedLaunches    int    `json:",omitempty"`
+	CreatedAt         int64
+	UpdatedAt         int64
+}
+
+// ScheduledLaunch - an entry in the launch history of a schedule
+type ScheduledLaunch struct {
+	ScheduleName string
+	ClusterID    string `json:",omitempty"`
+	Outcome      string
+	Error        string `json:",omitempty"`
+	DueAt        int64
+	Timestamp    int64
+}
+
+func getLocation(schedule Schedule) (*time.Location, error) {
+	if len(schedule.Timezone) == 0 {
+		return time.LoadLocation(defaultTimezone)
+	}
+	return time.LoadLocation(schedule.Timezone)
+}
+
+// getNextLaunch - returns the unix time of the first activation of the
+// schedule after the specified time
+func getNextLaunch(schedule Schedule, after time.Time) (int64, error) {
+	location, err := getLocation(schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	expression, err := parseCronExpression(schedule.Cron)
+	if err != nil {
+		return 0, err
+	}
+
+	next := expression.next(after.In(location))
+	if next.IsZero() {
+		return 0, errors.New("cron expression " + schedule.Cron + " never matches")
+	}
+
+	return next.Unix(), nil
+}
+
+// ValidateSchedule - verifies the schedule definition; the template is
+// validated by the caller against its cloud environment
+func ValidateSchedule(schedule Schedule) error {
+	if len(schedule.Name) > maxScheduleNameChars ||
+		!scheduleNamePattern.MatchString(schedule.Name) {
+		return errors.New("invalid schedule name \"" + schedule.Name +
+			"\"; names must be lowercase alphanumeric and dashes")
+	}
+
+	switch schedule.ConcurrencyPolicy {
+	case "", ConcurrencySkip, ConcurrencyQueue:
+	default:
+		return errors.New("invalid concurrency policy " + schedule.ConcurrencyPolicy +
+			"; expected " + ConcurrencySkip + " or " + ConcurrencyQueue)
+	}
+
+	if len(schedule.Template) == 0 {
+		return errors.New("schedule " + schedule.Name + " does not specify a template")
+	}
+
+	_, err := getNextLaunch(schedule, time.Now())
+	return err
+}
+
+func saveSchedule(schedule Schedule) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(schedule)
+	if err != nil {
+		return err
+	}
+
+	return client.HSet(scheduleMap, schedule.Name, string(buffer)).Err()
+}
+
+// PutSchedule - creates or replaces a schedule; the launch state of an
+// existing schedule is retained
+func PutSchedule(schedule Schedule) (Schedule, error) {
+	err := ValidateSchedule(schedule)
+	if err != nil {
+		return schedule, err
+	}
+
+	if len(schedule.ConcurrencyPolicy) == 0 {
+		schedule.ConcurrencyPolicy = ConcurrencySkip
+	}
+
+	schedule.NextLaunch, err = getNextLaunch(schedule, time.Now())
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.CreatedAt = time.Now().Unix()
+	schedule.LastClusterID = ""
+	schedule.QueuedLaunches = 0
+	existing, err := GetSchedule(schedule.Name)
+	if err == nil {
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.LastClusterID = existing.LastClusterID
+		if schedule.ConcurrencyPolicy == ConcurrencyQueue {
+			schedule.QueuedLaunches = existing.QueuedLaunches
+		}
+	}
+	schedule.UpdatedAt = time.Now().Unix()
+
+	logger.GetInfo().Printf("saving schedule %v (%v %v); next launch at %v",
+		schedule.Name, schedule.Cron, schedule.Timezone,
+		time.Unix(schedule.NextLaunch, 0).UTC())
+
+	return schedule, saveSchedule(schedule)
+}
+
+// GetSchedule - returns the schedule with the specified name
+func GetSchedule(name string) (Schedule, error) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	var schedule Schedule
+	buffer, err := client.HGet(scheduleMap, name).Result()
+	if err != nil {
+		return schedule, errors.New("schedule " + name + " not found")
+	}
+
+	err = serializer.Deserialize([]byte(buffer), &schedule)
+	return schedule, err
+}
+
+// GetSchedules - returns all schedules, ordered by name
+func GetSchedules() []Schedule {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	schedules := make([]Schedule, 0)
+	for _, buffer := range client.HGetAll(scheduleMap).Val() {
+		var schedule Schedule
+		if serializer.Deserialize([]byte(buffer), &schedule) == nil {
+			schedules = append(schedules, schedule)
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+
+	return schedules
+}
+
+// DeleteSchedule - removes a schedule and its history; clusters already
+// launched by the schedule are unaffected
+func DeleteSchedule(name string) error {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	if client.HDel(scheduleMap, name).Val() == 0 {
+		return errors.New("schedule " + name + " not found")
+	}
+
+	client.Del(historyPrefix + name)
+	return nil
+}
+
+func recordLaunch(entry ScheduledLaunch) {
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	buffer, err := serializer.Serialize(entry)
+	if err != nil {
+		logger.GetError().Println(err)
+		return
+	}
+
+	key := historyPrefix + entry.ScheduleName
+	client.LPush(key, string(buffer))
+	client.LTrim(key, 0, maxHistory-1)
+}
+
+// GetScheduleHistory - returns the launch history of a schedule,
+// ordered by most recent first
+func GetScheduleHistory(name string) ([]ScheduledLaunch, error) {
+	_, err := GetSchedule(name)
+	if err != nil {
+		return nil, err
+	}
+
+	client := datastore.GetRedisClient()
+	defer client.Close()
+
+	history := make([]ScheduledLaunch, 0)
+	for _, buffer := range client.LRange(historyPrefix+name, 0, -1).Val() {
+		var entry ScheduledLaunch
+		if serializer.Deserialize([]byte(buffer), &entry) == nil {
+			history = append(history, entry)
+		}
+	}
+
+	return history, nil
+}
+
+func newClusterID(schedule Schedule, now time.Time) string {
+	return schedule.Name + "-" + now.UTC().Format(clusterIDTimeFormat)
+}
+
+// launch - starts a cluster for the schedule; creation proceeds in the
+// background and the outcome is recorded in the schedule history
+func launch(schedule Schedule, dueAt int64, now time.Time) (Schedule, error) {
+	clusterID := newClusterID(schedule, now)
+	template, err := cloud.SetClusterID(schedule.Template, clusterID)
+	if err != nil {
+		return schedule, err
+	}
+
+	logger.GetInfo().Printf("schedule %v launching cluster %v", schedule.Name, clusterID)
+	schedule.LastClusterID = clusterID
+
+	go func() {
+		entry := ScheduledLaunch{
+			ScheduleName: schedule.Name,
+			ClusterID:    clusterID,
+			Outcome:      LaunchLaunched,
+			DueAt:        dueAt,
+		}
+
+		_, err := monitor.LaunchCluster(monitor.ClusterRequest{
+			ClusterID:        clusterID,
+			CloudEnvironment: schedule.CloudEnvironment,
+			Client:           template,
+			Caller:           scheduleCallerPrefix + schedule.Name,
+			Team:             schedule.Team,
+			Owner:            schedule.Owner,
+			Template:         schedule.Name,
+		})
+		if err != nil {
+			logger.GetError().Println(err)
+			entry.Outcome = LaunchFailed
+			entry.Error = err.Error()
+		}
+
+		entry.Timestamp = time.Now().Unix()
+		recordLaunch(entry)
+	}()
+
+	return schedule, nil
+}
+
+// processSchedule - launches, queues or skips a due schedule according
+// to its concurrency policy and drains queued launches once the
+// previous cluster has completed
+func processSchedule(schedule Schedule, now time.Time) Schedule {
+	active := len(schedule.LastClusterID) > 0 &&
+		monitor.IsClusterActive(schedule.LastClusterID)
+
+	if schedule.QueuedLaunches > 0 && !active {
+		var err error
+		schedule.QueuedLaunches--
+		schedule, err = launch(schedule, now.Unix(), now)
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+		active = true
+	}
+
+	if now.Unix() < schedule.NextLaunch {
+		return schedule
+	}
+
+	dueAt := schedule.NextLaunch
+	next, err := getNextLaunch(schedule, now)
+	if err != nil {
+		logger.GetError().Printf("unable to compute next launch of schedule %v: %v",
+			schedule.Name, err)
+	}
+	schedule.NextLaunch = next
+
+	switch {
+	case !active:
+		schedule, err = launch(schedule, dueAt, now)
+		if err != nil {
+			recordLaunch(ScheduledLaunch{
+				ScheduleName: schedule.Name,
+				Outcome:      LaunchFailed,
+				Error:        err.Error(),
+				DueAt:        dueAt,
+				Timestamp:    now.Unix(),
+			})
+		}
+	case schedule.ConcurrencyPolicy == ConcurrencyQueue:
+		logger.GetInfo().Printf("schedule %v is due while cluster %v is active; queueing launch",
+			schedule.Name, schedule.LastClusterID)
+		schedule.QueuedLaunches++
+		recordLaunch(ScheduledLaunch{
+			ScheduleName: schedule.Name,
+			ClusterID:    schedule.LastClusterID,
+			Outcome:      LaunchQueued,
+			DueAt:        dueAt,
+			Timestamp:    now.Unix(),
+		})
+	default:
+		logger.GetInfo().Printf("schedule %v is due while cluster %v is active; skipping launch",
+			schedule.Name, schedule.LastClusterID)
+		recordLaunch(ScheduledLaunch{
+			ScheduleName: schedule.Name,
+			ClusterID:    schedule.LastClusterID,
+			Outcome:      LaunchSkipped,
+			DueAt:        dueAt,
+			Timestamp:    now.Unix(),
+		})
+	}
+
+	return schedule
+}
+
+func schedulerHelper() {
+	now := time.Now()
+	for _, schedule := range GetSchedules() {
+		if schedule.NextLaunch == 0 {
+			continue
+		}
+
+		updated := processSchedule(schedule, now)
+		if updated.NextLaunch == schedule.NextLaunch &&
+			updated.QueuedLaunches == schedule.QueuedLaunches &&
+			updated.LastClusterID == schedule.LastClusterID {
+			continue
+		}
+
+		// the schedule may have been replaced or deleted through the
+		// API while it was processed
+		current, err := GetSchedule(schedule.Name)
+		if err != nil || current.UpdatedAt != schedule.UpdatedAt {
+			continue
+		}
+
+		err = saveSchedule(updated)
+		if err != nil {
+			logger.GetError().Println(err)
+		}
+	}
+}
+
+func releaseSchedulerLock() {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	redisClient.Del(schedulerLock)
+}
+
+func acquireSchedulerLock() bool {
+	redisClient := datastore.GetRedisClient()
+	defer redisClient.Close()
+
+	id, err := os.Hostname()
+	if err != nil {
+		logger.GetError().Println(err)
+		return false
+	}
+
+	redisClient.SetNX(schedulerLock, id, 15*time.Minute).Val()
+	return id == redisClient.Get(schedulerLock).Val()
+}
+
+// Run - daemon used for launching clusters of registered schedules;
+// the scheduler will run for the specified number of iterations, or
+// indefinitely if iterations <= 0.
+func Run(iterations int) {
+	for i := 0; iterations <= 0 || i < iterations; i++ {
+		if acquireSchedulerLock() {
+			schedulerHelper()
+			releaseSchedulerLock()
+		}
+		time.Sleep(schedulerInterval)
+	}
+}
@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"allspark/cloud"
+	"allspark/monitor"
+	"testing"
+	"time"
+)
+
+func getTestSchedule(t *testing.T, name string, policy string) Schedule {
+	template, err := cloud.ReadTemplateConfiguration("../dist/sample_templates/docker.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule, err := PutSchedule(Schedule{
+		Name:              name,
+		Cron:              "0 2 * * *",
+		Timezone:          "America/New_York",
+		CloudEnvironment:  cloud.Docker,
+		Template:          template,
+		ConcurrencyPolicy: policy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schedule
+}
+
+func TestPutSchedule(t *testing.T) {
+	schedule := getTestSchedule(t, "test-put-schedule", "")
+	defer DeleteSchedule(schedule.Name)
+
+	if schedule.ConcurrencyPolicy != ConcurrencySkip {
+		t.Error("concurrency policy mismatch")
+		t.Error("-expected: " + ConcurrencySkip)
+		t.Error("-actual: " + schedule.ConcurrencyPolicy)
+	}
+
+	next := time.Unix(schedule.NextLaunch, 0).In(time.UTC)
+	if next.Minute() != 0 || (next.Hour() != 6 && next.Hour() != 7) {
+		t.Error("expected next launch at 02:00 America/New_York, got " + next.String())
+	}
+
+	stored, err := GetSchedule(schedule.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.NextLaunch != schedule.NextLaunch {
+		t.Error("expected schedule to be persisted")
+	}
+
+	err = DeleteSchedule(schedule.Name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = GetSchedule(schedule.Name)
+	if err == nil {
+		t.Error("expected schedule to be deleted")
+	}
+}
+
+func TestProcessScheduleConcurrency(t *testing.T) {
+	for _, policy := range []string{ConcurrencySkip, ConcurrencyQueue} {
+		schedule := getTestSchedule(t, "test-concurrency-"+policy, policy)
+
+		// simulate an active cluster from the previous launch
+		schedule.LastClusterID = newClusterID(schedule, time.Now())
+		template, err := cloud.SetClusterID(schedule.Template, schedule.LastClusterID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		monitor.RegisterCluster(schedule.LastClusterID, cloud.Docker, template)
+
+		now := time.Unix(schedule.NextLaunch, 0)
+		updated := processSchedule(schedule, now)
+
+		if updated.NextLaunch <= schedule.NextLaunch {
+			t.Error("expected next launch to advance")
+		}
+
+		if updated.LastClusterID != schedule.LastClusterID {
+			t.Error("expected no launch while the previous cluster is active")
+		}
+
+		history, err := GetScheduleHistory(schedule.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := LaunchSkipped
+		expectedQueue := 0
+		if policy == ConcurrencyQueue {
+			expected = LaunchQueued
+			expectedQueue = 1
+		}
+
+		if len(history) != 1 || history[0].Outcome != expected {
+			t.Error("history mismatch")
+			t.Error("-expected: " + expected)
+			t.Errorf("-actual: %+v", history)
+		}
+
+		if updated.QueuedLaunches != expectedQueue {
+			t.Errorf("expected %v queued launches, got %v",
+				expectedQueue, updated.QueuedLaunches)
+		}
+
+		monitor.DeregisterCluster(schedule.LastClusterID)
+		DeleteSchedule(schedule.Name)
+	}
+}